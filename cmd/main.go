@@ -3,7 +3,6 @@ package main
 import (
 	"auth/internal/app"
 	"auth/internal/config"
-	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -19,8 +18,6 @@ func main() {
 	// инициализировать проект
 	cfg := config.EnvLoad()
 
-	fmt.Println(cfg)
-
 	// инициализировать логгер
 
 	log := setupLogger(cfg.Env)
@@ -29,16 +26,21 @@ func main() {
 
 	// инициализировать приложение
 
-	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTL)
+	application := app.New(log, cfg)
 
 	// start
 	go application.GRPCApp.MustRun()
+	go application.HTTPApp.MustRun()
+	go application.SchedulerApp.MustRun()
 
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 	signalStop := <-stop
 	application.GRPCApp.Stop()
+	application.HTTPApp.Stop()
+	application.SchedulerApp.Stop()
+	application.AuthService.Wait()
 	log.Info("application stopped", slog.String("signal", signalStop.String()))
 }
 
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	//Драйвер для миграций на sqlite3
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	//Драйвер для получения миграций из файлов
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
@@ -38,11 +41,81 @@ func main() {
 	}
 
 	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			fmt.Println("No migrations to apply")
-			return
+		if !errors.Is(err, migrate.ErrNoChange) {
+			panic(err)
 		}
+		fmt.Println("No migrations to apply")
+	} else {
+		fmt.Println("migrations applied successfully")
+	}
+
+	// secrets are hashed on every run, a database migrated by an older
+	// migrator may still hold some in plaintext
+	hashed, err := hashAppSecrets(storagePath)
+	if err != nil {
 		panic(err)
 	}
-	fmt.Println("migrations applied successfully")
+	if hashed > 0 {
+		fmt.Printf("hashed plaintext secrets of %d apps\n", hashed)
+	}
+}
+
+// hashAppSecrets replaces the plaintext secrets apps created before
+// secrets were hashed still have with their bcrypt hashes. SQL can't
+// compute them, so this runs after the migrations. It returns the number
+// of apps changed.
+func hashAppSecrets(storagePath string) (int, error) {
+	db, err := sql.Open("sqlite3", storagePath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	type plainSecrets struct {
+		id                int
+		secret            string
+		previousSecret    string
+		previousExpiresAt int64
+	}
+	rows, err := db.Query(`SELECT id, secret, previous_secret, previous_secret_expires_at FROM apps
+		WHERE secret != '' OR previous_secret != ''`)
+	if err != nil {
+		return 0, err
+	}
+	var apps []plainSecrets
+	for rows.Next() {
+		var app plainSecrets
+		if err := rows.Scan(&app.id, &app.secret, &app.previousSecret, &app.previousExpiresAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		apps = append(apps, app)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	for _, app := range apps {
+		var secretHash, previousHash []byte
+		if app.secret != "" {
+			if secretHash, err = bcrypt.GenerateFromPassword([]byte(app.secret), bcrypt.DefaultCost); err != nil {
+				return 0, err
+			}
+		}
+		// an expired previous secret is dropped
+		if app.previousSecret != "" && now < app.previousExpiresAt {
+			if previousHash, err = bcrypt.GenerateFromPassword([]byte(app.previousSecret), bcrypt.DefaultCost); err != nil {
+				return 0, err
+			}
+		}
+		_, err := db.Exec(`UPDATE apps SET secret = '', secret_hash = COALESCE(?, secret_hash),
+			previous_secret = '', previous_secret_hash = COALESCE(?, previous_secret_hash) WHERE id = ?`,
+			secretHash, previousHash, app.id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(apps), nil
 }
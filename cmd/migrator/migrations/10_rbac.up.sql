@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions
+(
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+-- app_id 0 grants the role in every app
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    app_id INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, role_id, app_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role ON user_roles(role_id);
+
+INSERT INTO roles(name, description) VALUES ('admin', 'Full access to the auth service');
+
+INSERT INTO permissions(name) VALUES ('apps:manage'), ('keys:rotate'), ('roles:manage');
+
+INSERT INTO role_permissions(role_id, permission_id)
+    SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin';
+
+INSERT INTO user_roles(user_id, role_id, app_id)
+    SELECT u.id, r.id, 0 FROM users u, roles r WHERE u.is_admin = TRUE AND r.name = 'admin';
+
+ALTER TABLE users DROP COLUMN is_admin;
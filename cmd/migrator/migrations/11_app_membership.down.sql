@@ -0,0 +1,5 @@
+DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE name = 'members:manage');
+DELETE FROM permissions WHERE name = 'members:manage';
+ALTER TABLE apps DROP COLUMN allow_self_registration;
+DROP TABLE IF EXISTS app_invitations;
+DROP TABLE IF EXISTS user_apps;
\ No newline at end of file
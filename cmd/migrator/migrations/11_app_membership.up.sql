@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS user_apps
+(
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    app_id INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, app_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_apps_app ON user_apps(app_id);
+
+CREATE TABLE IF NOT EXISTS app_invitations
+(
+    id INTEGER PRIMARY KEY,
+    token_hash BLOB NOT NULL UNIQUE,
+    app_id INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- existing apps stay open so that their users can still log in
+ALTER TABLE apps
+    ADD COLUMN allow_self_registration BOOLEAN NOT NULL DEFAULT TRUE;
+
+INSERT OR IGNORE INTO user_apps(user_id, app_id, created_at)
+    SELECT DISTINCT user_id, app_id, strftime('%s', 'now') FROM refresh_tokens;
+
+INSERT OR IGNORE INTO permissions(name) VALUES ('members:manage');
+
+INSERT OR IGNORE INTO role_permissions(role_id, permission_id)
+    SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = 'members:manage';
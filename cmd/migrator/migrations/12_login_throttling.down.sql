@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE name = 'users:manage');
+DELETE FROM permissions WHERE name = 'users:manage';
+DROP TABLE IF EXISTS login_failures;
\ No newline at end of file
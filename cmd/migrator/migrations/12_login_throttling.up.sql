@@ -0,0 +1,15 @@
+-- key is "user:<email>" or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at INTEGER NOT NULL,
+    blocked_until INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last_failure_at ON login_failures(last_failure_at);
+
+INSERT OR IGNORE INTO permissions(name) VALUES ('users:manage');
+
+INSERT OR IGNORE INTO role_permissions(role_id, permission_id)
+    SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = 'users:manage';
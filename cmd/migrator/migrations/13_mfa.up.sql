@@ -0,0 +1,21 @@
+-- secret is encrypted by the service, the id of the user is bound to it
+CREATE TABLE IF NOT EXISTS user_totp
+(
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret BLOB NOT NULL,
+    confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+    -- time step of the last accepted code, codes can't be used twice
+    last_used_step INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges
+(
+    id INTEGER PRIMARY KEY,
+    token_hash BLOB NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    app_id INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_mfa_challenges_user ON mfa_challenges(user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash BLOB NOT NULL,
+    created_at INTEGER NOT NULL,
+    -- when the code was used, 0 while it is unused
+    used_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
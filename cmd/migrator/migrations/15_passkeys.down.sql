@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS webauthn_sessions;
+DROP TABLE IF EXISTS passkeys;
+ALTER TABLE apps DROP COLUMN webauthn_origins;
+ALTER TABLE apps DROP COLUMN webauthn_rp_id;
\ No newline at end of file
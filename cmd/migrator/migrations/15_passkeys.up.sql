@@ -0,0 +1,38 @@
+-- relying party of the app, passkeys can't be used while rp_id is empty
+ALTER TABLE apps ADD COLUMN webauthn_rp_id TEXT NOT NULL DEFAULT '';
+-- space separated origins the app is served from
+ALTER TABLE apps ADD COLUMN webauthn_origins TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS passkeys
+(
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rp_id TEXT NOT NULL,
+    credential_id BLOB NOT NULL UNIQUE,
+    public_key BLOB NOT NULL,
+    attestation_type TEXT NOT NULL,
+    aaguid BLOB NOT NULL,
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    -- space separated transports reported by the authenticator
+    transports TEXT NOT NULL DEFAULT '',
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at INTEGER NOT NULL,
+    last_used_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_passkeys_user ON passkeys(user_id, rp_id);
+
+-- state of a registration or login ceremony between its begin and finish
+CREATE TABLE IF NOT EXISTS webauthn_sessions
+(
+    id INTEGER PRIMARY KEY,
+    token_hash BLOB NOT NULL UNIQUE,
+    -- 0 for a login that doesn't name the user
+    user_id INTEGER NOT NULL DEFAULT 0,
+    app_id INTEGER NOT NULL,
+    ceremony TEXT NOT NULL,
+    data BLOB NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS passwordless_challenges;
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- links are looked up by the hash of their token, codes are short so they
+-- are hashed with bcrypt and looked up by user
+CREATE TABLE IF NOT EXISTS passwordless_challenges
+(
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    app_id INTEGER NOT NULL,
+    method TEXT NOT NULL,
+    secret_hash BLOB NOT NULL,
+    expires_at INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_passwordless_challenges_secret ON passwordless_challenges(secret_hash);
+CREATE INDEX IF NOT EXISTS idx_passwordless_challenges_user ON passwordless_challenges(user_id, app_id);
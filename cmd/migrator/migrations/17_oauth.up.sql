@@ -0,0 +1,18 @@
+-- space separated redirect URIs OAuth clients of the app may use
+ALTER TABLE apps ADD COLUMN redirect_uris TEXT NOT NULL DEFAULT '';
+
+-- codes of the authorization_code grant, looked up by the hash of the code
+CREATE TABLE IF NOT EXISTS authorization_codes
+(
+    id INTEGER PRIMARY KEY,
+    code_hash BLOB NOT NULL UNIQUE,
+    app_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    code_challenge TEXT NOT NULL,
+    -- refresh token family the code is exchanged into, revoked if the
+    -- code is used twice
+    family_id TEXT NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
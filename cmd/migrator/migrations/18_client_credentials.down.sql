@@ -0,0 +1,25 @@
+-- hashed secrets can't be restored, those apps get a random secret and
+-- have to rotate it
+CREATE TABLE apps_old
+(
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    secret TEXT NOT NULL UNIQUE,
+    require_email_verification BOOLEAN NOT NULL DEFAULT FALSE,
+    previous_secret TEXT NOT NULL DEFAULT '',
+    previous_secret_expires_at INTEGER NOT NULL DEFAULT 0,
+    allow_self_registration BOOLEAN NOT NULL DEFAULT TRUE,
+    webauthn_rp_id TEXT NOT NULL DEFAULT '',
+    webauthn_origins TEXT NOT NULL DEFAULT '',
+    redirect_uris TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO apps_old(id, name, secret, require_email_verification, previous_secret, previous_secret_expires_at,
+    allow_self_registration, webauthn_rp_id, webauthn_origins, redirect_uris)
+SELECT id, name, COALESCE(NULLIF(secret, ''), lower(hex(randomblob(32)))), require_email_verification,
+    previous_secret, previous_secret_expires_at, allow_self_registration, webauthn_rp_id, webauthn_origins,
+    redirect_uris
+FROM apps;
+
+DROP TABLE apps;
+ALTER TABLE apps_old RENAME TO apps;
\ No newline at end of file
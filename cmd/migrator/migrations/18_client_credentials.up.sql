@@ -0,0 +1,30 @@
+-- Secrets are stored as bcrypt hashes from now on, the migrator hashes and
+-- clears plaintext secrets after the migrations are applied.
+-- Cleared secrets are no longer unique and SQLite can't drop the
+-- constraint, so the table is rebuilt.
+CREATE TABLE apps_new
+(
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    secret TEXT NOT NULL DEFAULT '',
+    secret_hash BLOB,
+    require_email_verification BOOLEAN NOT NULL DEFAULT FALSE,
+    allow_self_registration BOOLEAN NOT NULL DEFAULT TRUE,
+    previous_secret TEXT NOT NULL DEFAULT '',
+    previous_secret_hash BLOB,
+    previous_secret_expires_at INTEGER NOT NULL DEFAULT 0,
+    webauthn_rp_id TEXT NOT NULL DEFAULT '',
+    webauthn_origins TEXT NOT NULL DEFAULT '',
+    redirect_uris TEXT NOT NULL DEFAULT '',
+    -- space separated scopes the app may request with the client_credentials grant
+    scopes TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO apps_new(id, name, secret, require_email_verification, allow_self_registration, previous_secret,
+    previous_secret_expires_at, webauthn_rp_id, webauthn_origins, redirect_uris)
+SELECT id, name, secret, require_email_verification, allow_self_registration, previous_secret,
+    previous_secret_expires_at, webauthn_rp_id, webauthn_origins, redirect_uris
+FROM apps;
+
+DROP TABLE apps;
+ALTER TABLE apps_new RENAME TO apps;
@@ -0,0 +1,16 @@
+-- device authorization grant, both codes are looked up by their hash
+CREATE TABLE IF NOT EXISTS device_codes
+(
+    id INTEGER PRIMARY KEY,
+    device_code_hash BLOB NOT NULL UNIQUE,
+    user_code_hash BLOB NOT NULL UNIQUE,
+    app_id INTEGER NOT NULL,
+    -- set once the user approved or denied the device
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    -- pending, approved, denied or used
+    status TEXT NOT NULL DEFAULT 'pending',
+    -- seconds the device has to wait between polls
+    poll_interval INTEGER NOT NULL,
+    last_polled_at INTEGER NOT NULL DEFAULT 0,
+    expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE authorization_codes DROP COLUMN auth_time;
+ALTER TABLE authorization_codes DROP COLUMN nonce;
+ALTER TABLE authorization_codes DROP COLUMN scope;
\ No newline at end of file
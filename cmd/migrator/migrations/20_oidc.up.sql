@@ -0,0 +1,4 @@
+ALTER TABLE authorization_codes ADD COLUMN scope TEXT NOT NULL DEFAULT '';
+ALTER TABLE authorization_codes ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
+-- when the user logged in, the auth_time claim of ID tokens
+ALTER TABLE authorization_codes ADD COLUMN auth_time INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE passkeys DROP COLUMN user_handle;
\ No newline at end of file
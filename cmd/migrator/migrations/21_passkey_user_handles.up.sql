@@ -0,0 +1,6 @@
+-- random user handle shown to authenticators instead of the user id, the
+-- same for every passkey of the user for the relying party
+ALTER TABLE passkeys ADD COLUMN user_handle BLOB NOT NULL DEFAULT x'';
+
+-- passkeys registered before were given the big-endian user id
+UPDATE passkeys SET user_handle = unhex(printf('%016X', user_id));
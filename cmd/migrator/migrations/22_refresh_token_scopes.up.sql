@@ -0,0 +1,3 @@
+-- space separated OpenID Connect scopes granted to the tokens of the
+-- family, kept when the token is rotated
+ALTER TABLE refresh_tokens ADD COLUMN scope TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+-- emails are stored lowercased and trimmed, addresses that would collide
+-- with another account are left as they are for an admin to resolve
+UPDATE users SET email = lower(trim(email))
+WHERE email != lower(trim(email))
+  AND NOT EXISTS (
+    SELECT 1 FROM users AS other
+    WHERE other.id != users.id AND lower(trim(other.email)) = lower(trim(users.email))
+  );
+
+-- pending verifications follow the address of their user
+UPDATE email_verification_tokens SET email = lower(trim(email))
+WHERE EXISTS (
+    SELECT 1 FROM users
+    WHERE users.id = email_verification_tokens.user_id AND users.email = lower(trim(email_verification_tokens.email))
+);
+
+UPDATE app_invitations SET email = lower(trim(email));
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS signing_keys;
\ No newline at end of file
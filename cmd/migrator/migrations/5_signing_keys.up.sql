@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS signing_keys
+(
+    kid TEXT PRIMARY KEY,
+    app_id INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    algorithm TEXT NOT NULL,
+    private_key BLOB NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_app ON signing_keys(app_id, algorithm);
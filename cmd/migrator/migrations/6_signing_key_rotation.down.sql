@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_signing_keys_status;
+ALTER TABLE signing_keys DROP COLUMN expires_at;
+ALTER TABLE signing_keys DROP COLUMN activates_at;
+ALTER TABLE signing_keys DROP COLUMN status;
\ No newline at end of file
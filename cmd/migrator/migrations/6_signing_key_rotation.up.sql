@@ -0,0 +1,10 @@
+ALTER TABLE signing_keys
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE signing_keys
+    ADD COLUMN activates_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE signing_keys
+    ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0;
+
+UPDATE signing_keys SET activates_at = created_at;
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_status ON signing_keys(status);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_verification_tokens;
+ALTER TABLE apps DROP COLUMN require_email_verification;
+ALTER TABLE users DROP COLUMN email_verified;
\ No newline at end of file
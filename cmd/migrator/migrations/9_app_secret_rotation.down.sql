@@ -0,0 +1,2 @@
+ALTER TABLE apps DROP COLUMN previous_secret_expires_at;
+ALTER TABLE apps DROP COLUMN previous_secret;
\ No newline at end of file
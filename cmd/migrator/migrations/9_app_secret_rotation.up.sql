@@ -0,0 +1,5 @@
+ALTER TABLE apps
+    ADD COLUMN previous_secret TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE apps
+    ADD COLUMN previous_secret_expires_at INTEGER NOT NULL DEFAULT 0;
@@ -2,27 +2,159 @@ package app
 
 import (
 	grpcapp "auth/internal/app/grpc"
+	httpapp "auth/internal/app/http"
+	schedulerapp "auth/internal/app/scheduler"
+	"auth/internal/config"
+	"auth/internal/lib/encryption"
+	"auth/internal/lib/jwt"
+	"auth/internal/lib/mailer"
+	"auth/internal/lib/password"
+	"auth/internal/lib/ratelimit"
 	auth "auth/internal/services"
 	"auth/internal/storage/sqlite"
+	"encoding/base64"
 	"log/slog"
+	"os"
 	"time"
 )
 
+// keyRotationInterval is how often signing keys are checked for due
+// lifecycle steps.
+const keyRotationInterval = time.Minute
+
+// loginFailuresPruneInterval is how often failed logins that no longer
+// count are deleted.
+const loginFailuresPruneInterval = time.Hour
+
 type App struct {
-	GRPCApp *grpcapp.App
+	GRPCApp      *grpcapp.App
+	HTTPApp      *httpapp.App
+	SchedulerApp *schedulerapp.App
+	// AuthService sends some emails in the background, Wait for them
+	// before exiting
+	AuthService *auth.Auth
 }
 
 // New create New server app.
-func New(log *slog.Logger, grpcPort int, storagePath string, tokenTTL time.Duration) *App {
+func New(log *slog.Logger, cfg *config.Config) *App {
 	// TODO: инициализировать хранилище
-	storage, err := sqlite.New(storagePath)
+	storage, err := sqlite.New(cfg.StoragePath)
 	if err != nil {
 		panic(err)
 	}
 	// TODO: init auth service
-	authService := auth.New(log, tokenTTL, storage)
+	authService := auth.New(log, authConfig(cfg), storage, newMailer(cfg.Mailer))
 	// init grpc Server
-	grpcApp := grpcapp.New(log, grpcPort, authService)
+	grpcApp := grpcapp.New(log, cfg.GRPC.Port, authService, rateLimits(cfg.GRPC.RateLimits))
+	// init http Server
+	httpApp := httpapp.New(log, cfg.HTTP.Port, cfg.HTTP.Timeout, authService)
+	// init background jobs
+	schedulerApp := schedulerapp.New(log,
+		schedulerapp.Job{Name: "signing key rotation", Interval: keyRotationInterval, Run: authService.RotateExpiredSigningKeys},
+		schedulerapp.Job{Name: "login failures pruning", Interval: loginFailuresPruneInterval, Run: authService.PruneLoginFailures},
+	)
+
+	return &App{GRPCApp: grpcApp, HTTPApp: httpApp, SchedulerApp: schedulerApp, AuthService: authService}
+}
+
+func authConfig(cfg *config.Config) auth.Config {
+	authCfg := auth.Config{
+		TokenTTL:             cfg.TokenTTL,
+		RefreshTokenTTL:      cfg.RefreshTokenTTL,
+		SigningAlgorithm:     cfg.Signing.Algorithm,
+		KeyRotationPeriod:    cfg.Signing.RotationPeriod,
+		KeyPrepublishPeriod:  cfg.Signing.PrepublishPeriod,
+		EmailVerificationTTL: cfg.EmailVerificationTTL,
+		PasswordResetTTL:     cfg.PasswordResetTTL,
+		PasswordPolicy:       password.Policy{MinLength: cfg.PasswordPolicy.MinLength},
+		AppSecretGracePeriod: cfg.AppSecretGracePeriod,
+		InvitationTTL:        cfg.InvitationTTL,
+		LoginThrottling: auth.LoginThrottling{
+			MaxFailures:      cfg.LoginThrottling.MaxFailures,
+			MaxFailuresPerIP: cfg.LoginThrottling.MaxFailuresPerIP,
+			LockoutDuration:  cfg.LoginThrottling.LockoutDuration,
+			BaseDelay:        cfg.LoginThrottling.BaseDelay,
+			MaxDelay:         cfg.LoginThrottling.MaxDelay,
+			FailureWindow:    cfg.LoginThrottling.FailureWindow,
+		},
+		MFAIssuer:          cfg.MFA.Issuer,
+		MFAChallengeTTL:    cfg.MFA.ChallengeTTL,
+		WebAuthnSessionTTL: cfg.WebAuthnSessionTTL,
+		Passwordless: auth.Passwordless{
+			TTL:         cfg.Passwordless.CodeTTL,
+			MaxAttempts: cfg.Passwordless.MaxAttempts,
+			LinkURL:     cfg.Passwordless.LinkURL,
+		},
+		AuthorizationCodeTTL: cfg.OAuth.AuthorizationCodeTTL,
+		Issuer:               cfg.Issuer,
+		TokenLeeway:          cfg.TokenLeeway,
+		DeviceFlow: auth.DeviceFlow{
+			TTL:             cfg.OAuth.DeviceCodeTTL,
+			PollInterval:    cfg.OAuth.DevicePollInterval,
+			VerificationURI: cfg.OAuth.DeviceVerificationURI,
+		},
+	}
+
+	if cfg.MFA.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.MFA.EncryptionKey)
+		if err != nil {
+			panic("invalid mfa encryption key: " + err.Error())
+		}
+		authCfg.MFACipher, err = encryption.New(key)
+		if err != nil {
+			panic("invalid mfa encryption key: " + err.Error())
+		}
+	}
+
+	if cfg.Signing.KeyFile != "" {
+		key, err := jwt.LoadSigningKeyFile(cfg.Signing.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+		authCfg.SigningKey = &key
+		authCfg.SigningAlgorithm = key.Algorithm
+	} else if cfg.Signing.Algorithm != jwt.AlgHS256 && !jwt.IsAsymmetric(cfg.Signing.Algorithm) {
+		panic("unsupported signing algorithm: " + cfg.Signing.Algorithm)
+	}
+	if cfg.Signing.PrepublishPeriod >= cfg.Signing.RotationPeriod {
+		panic("signing key prepublish period must be shorter than rotation period")
+	}
 
-	return &App{GRPCApp: grpcApp}
+	return authCfg
+}
+
+func rateLimits(cfg config.RateLimitConfig) grpcapp.RateLimits {
+	limits := grpcapp.RateLimits{
+		Default: methodLimits(cfg.Default),
+		Methods: make(map[string]grpcapp.MethodLimits, len(cfg.Methods)),
+	}
+	for method, l := range cfg.Methods {
+		limits.Methods[method] = methodLimits(l)
+	}
+	return limits
+}
+
+func methodLimits(cfg config.MethodRateLimitConfig) grpcapp.MethodLimits {
+	limit := func(l config.LimitConfig) ratelimit.Limit {
+		return ratelimit.Limit{Rate: l.Rate, Burst: l.Burst}
+	}
+	return grpcapp.MethodLimits{
+		Total:  limit(cfg.Total),
+		PerIP:  limit(cfg.PerIP),
+		PerApp: limit(cfg.PerApp),
+	}
+}
+
+func newMailer(cfg config.MailerConfig) auth.Mailer {
+	switch cfg.Type {
+	case "stdout":
+		return mailer.NewWriter(os.Stdout, cfg.From)
+	case "file":
+		m, err := mailer.NewFile(cfg.Path, cfg.From)
+		if err != nil {
+			panic(err)
+		}
+		return m
+	}
+	panic("unsupported mailer type: " + cfg.Type)
 }
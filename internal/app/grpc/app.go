@@ -15,9 +15,20 @@ type App struct {
 	port       int
 }
 
-// New create New gRPC server app.
-func New(log *slog.Logger, port int, auth server.Auth) *App {
-	gRPCServer := grpc.NewServer()
+// New create New gRPC server app. Calls are rate limited before they are
+// authenticated.
+func New(log *slog.Logger, port int, auth server.Auth, limits RateLimits) *App {
+	limiter := newRateLimiter(limits)
+	gRPCServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			rateLimitUnaryInterceptor(limiter),
+			authUnaryInterceptor(auth, methodPolicies),
+		),
+		grpc.ChainStreamInterceptor(
+			rateLimitStreamInterceptor(limiter),
+			authStreamInterceptor(auth, methodPolicies),
+		),
+	)
 
 	server.Register(gRPCServer, auth)
 
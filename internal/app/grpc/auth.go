@@ -0,0 +1,168 @@
+package grpcapp
+
+import (
+	server "auth/internal/grpc"
+	"auth/internal/lib/jwt"
+	"auth/internal/lib/principal"
+	auth "auth/internal/services"
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Policy tells who may call a method.
+type Policy int
+
+const (
+	// PolicyPublic methods are open to anyone, a bearer token is ignored.
+	PolicyPublic Policy = iota
+	// PolicyAuthenticated methods require a valid access token. Methods
+	// check permissions of its owner themselves.
+	PolicyAuthenticated
+	// PolicyAdmin methods require an access token of an admin.
+	PolicyAdmin
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerScheme        = "bearer"
+)
+
+// methodPolicies lists the policy of every method of the Auth service.
+// Methods missing here are admin only, so that a new method is never
+// exposed by accident.
+var methodPolicies = map[string]Policy{
+	"/auth.Auth/Register":                  PolicyPublic,
+	"/auth.Auth/Login":                     PolicyPublic,
+	"/auth.Auth/isAdmin":                   PolicyPublic,
+	"/auth.Auth/ValidateToken":             PolicyPublic,
+	"/auth.Auth/Refresh":                   PolicyPublic,
+	"/auth.Auth/Logout":                    PolicyPublic,
+	"/auth.Auth/RevokeToken":               PolicyPublic,
+	"/auth.Auth/GetJWKS":                   PolicyPublic,
+	"/auth.Auth/SendVerificationEmail":     PolicyPublic,
+	"/auth.Auth/VerifyEmail":               PolicyPublic,
+	"/auth.Auth/RequestPasswordReset":      PolicyPublic,
+	"/auth.Auth/ResetPassword":             PolicyPublic,
+	"/auth.Auth/AcceptInvitation":          PolicyPublic,
+	"/auth.Auth/VerifyMFA":                 PolicyPublic,
+	"/auth.Auth/LoginWithRecoveryCode":     PolicyPublic,
+	"/auth.Auth/BeginPasskeyLogin":         PolicyPublic,
+	"/auth.Auth/FinishPasskeyLogin":        PolicyPublic,
+	"/auth.Auth/StartPasswordlessLogin":    PolicyPublic,
+	"/auth.Auth/CompletePasswordlessLogin": PolicyPublic,
+	"/auth.Auth/IssueClientToken":          PolicyPublic,
+	"/auth.Auth/IntrospectToken":           PolicyPublic,
+	"/auth.Auth/ChangePassword":            PolicyAuthenticated,
+	"/auth.Auth/ChangeEmail":               PolicyAuthenticated,
+	"/auth.Auth/EnrollTOTP":                PolicyAuthenticated,
+	"/auth.Auth/ConfirmTOTP":               PolicyAuthenticated,
+	"/auth.Auth/GenerateRecoveryCodes":     PolicyAuthenticated,
+	"/auth.Auth/RegenerateRecoveryCodes":   PolicyAuthenticated,
+	"/auth.Auth/ApproveDevice":             PolicyAuthenticated,
+	"/auth.Auth/BeginPasskeyRegistration":  PolicyAuthenticated,
+	"/auth.Auth/FinishPasskeyRegistration": PolicyAuthenticated,
+	"/auth.Auth/GetApp":                    PolicyAuthenticated,
+	"/auth.Auth/UpdateApp":                 PolicyAuthenticated,
+	"/auth.Auth/DeleteApp":                 PolicyAuthenticated,
+	"/auth.Auth/RotateAppSecret":           PolicyAuthenticated,
+	"/auth.Auth/RotateSigningKeys":         PolicyAuthenticated,
+	"/auth.Auth/AssignRole":                PolicyAuthenticated,
+	"/auth.Auth/RevokeRole":                PolicyAuthenticated,
+	"/auth.Auth/ListRoles":                 PolicyAuthenticated,
+	"/auth.Auth/CheckPermission":           PolicyAuthenticated,
+	"/auth.Auth/InviteMember":              PolicyAuthenticated,
+	"/auth.Auth/AddMember":                 PolicyAuthenticated,
+	"/auth.Auth/RemoveMember":              PolicyAuthenticated,
+	"/auth.Auth/CreateApp":                 PolicyAdmin,
+	"/auth.Auth/UnlockUser":                PolicyAdmin,
+	"/auth.Auth/ListApps":                  PolicyAdmin,
+}
+
+// Authenticator validates access tokens and tells admins apart.
+type Authenticator interface {
+	ValidateToken(ctx context.Context, token string) (jwt.Claims, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+}
+
+// authUnaryInterceptor enforces the policy of the method and puts claims
+// of the access token into the context of the handler.
+func authUnaryInterceptor(authenticator Authenticator, policies map[string]Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, authenticator, policies, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStreamInterceptor is authUnaryInterceptor for streaming methods.
+func authStreamInterceptor(authenticator Authenticator, policies map[string]Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), authenticator, policies, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authenticate(ctx context.Context, authenticator Authenticator, policies map[string]Policy, method string) (context.Context, error) {
+	policy, ok := policies[method]
+	if !ok {
+		policy = PolicyAdmin
+	}
+	if policy == PolicyPublic {
+		return ctx, nil
+	}
+
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return nil, server.ToStatus(auth.ErrInvalidToken)
+	}
+	claims, err := authenticator.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, server.ToStatus(err)
+	}
+	if claims.IsClient() {
+		// tokens of apps have no user to act for
+		return nil, server.ToStatus(auth.ErrPermissionDenied)
+	}
+
+	if policy == PolicyAdmin {
+		isAdmin, err := authenticator.IsAdmin(ctx, claims.UID)
+		if err != nil {
+			return nil, server.ToStatus(err)
+		}
+		if !isAdmin {
+			return nil, server.ToStatus(auth.ErrPermissionDenied)
+		}
+	}
+	return principal.WithClaims(ctx, claims), nil
+}
+
+// bearerToken returns the access token sent in the authorization metadata
+// as "Bearer <token>".
+func bearerToken(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(authorizationHeader) {
+		scheme, token, found := strings.Cut(value, " ")
+		token = strings.TrimSpace(token)
+		if found && strings.EqualFold(scheme, bearerScheme) && token != "" {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,93 @@
+package grpcapp
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/lib/principal"
+	auth "auth/internal/services"
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeAuthenticator knows the claims of a fixed set of tokens.
+type fakeAuthenticator struct {
+	tokens map[string]jwt.Claims
+	admins map[int64]bool
+}
+
+func (f fakeAuthenticator) ValidateToken(_ context.Context, token string) (jwt.Claims, error) {
+	claims, ok := f.tokens[token]
+	if !ok {
+		return jwt.Claims{}, auth.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (f fakeAuthenticator) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	return f.admins[userID], nil
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	authenticator := fakeAuthenticator{
+		tokens: map[string]jwt.Claims{
+			"user":   {UID: 1, AppID: 1},
+			"admin":  {UID: 2, AppID: 1},
+			"client": {ClientID: 1, AppID: 1},
+		},
+		admins: map[int64]bool{2: true},
+	}
+	policies := map[string]Policy{
+		"/auth.Auth/Public":        PolicyPublic,
+		"/auth.Auth/Authenticated": PolicyAuthenticated,
+		"/auth.Auth/Admin":         PolicyAdmin,
+	}
+	interceptor := authUnaryInterceptor(authenticator, policies)
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantCode      codes.Code
+		wantUID       int64
+	}{
+		{name: "public without token", method: "/auth.Auth/Public"},
+		{name: "public ignores invalid token", method: "/auth.Auth/Public", authorization: "Bearer invalid"},
+		{name: "authenticated", method: "/auth.Auth/Authenticated", authorization: "Bearer user", wantUID: 1},
+		{name: "scheme is case insensitive", method: "/auth.Auth/Authenticated", authorization: "bearer user", wantUID: 1},
+		{name: "authenticated without token", method: "/auth.Auth/Authenticated", wantCode: codes.Unauthenticated},
+		{name: "other scheme", method: "/auth.Auth/Authenticated", authorization: "Basic user", wantCode: codes.Unauthenticated},
+		{name: "invalid token", method: "/auth.Auth/Authenticated", authorization: "Bearer invalid", wantCode: codes.Unauthenticated},
+		{name: "client token", method: "/auth.Auth/Authenticated", authorization: "Bearer client", wantCode: codes.PermissionDenied},
+		{name: "admin", method: "/auth.Auth/Admin", authorization: "Bearer admin", wantUID: 2},
+		{name: "admin method as user", method: "/auth.Auth/Admin", authorization: "Bearer user", wantCode: codes.PermissionDenied},
+		{name: "unlisted method is admin only", method: "/auth.Auth/Unlisted", authorization: "Bearer user", wantCode: codes.PermissionDenied},
+		{name: "unlisted method as admin", method: "/auth.Auth/Unlisted", authorization: "Bearer admin", wantUID: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationHeader, tt.authorization))
+			}
+
+			var uid int64
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				if claims, ok := principal.FromContext(ctx); ok {
+					uid = claims.UID
+				}
+				return nil, nil
+			}
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("interceptor code = %s, want %s", code, tt.wantCode)
+			}
+			if uid != tt.wantUID {
+				t.Errorf("principal uid = %d, want %d", uid, tt.wantUID)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package grpcapp
+
+import (
+	server "auth/internal/grpc"
+	"auth/internal/lib/ratelimit"
+	auth "auth/internal/services"
+	"context"
+	"path"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// MethodLimits are rate limits of a method. Total is shared by all calls,
+// PerIP by calls from one client address and PerApp by calls for one app,
+// for requests that name an app.
+type MethodLimits struct {
+	Total  ratelimit.Limit
+	PerIP  ratelimit.Limit
+	PerApp ratelimit.Limit
+}
+
+// RateLimits configures rate limiting of the server. Methods are keyed by
+// method name, e.g. "Login", methods missing there use Default.
+type RateLimits struct {
+	Default MethodLimits
+	Methods map[string]MethodLimits
+}
+
+// methodLimiters keeps buckets of a method.
+type methodLimiters struct {
+	total  *ratelimit.Limiter
+	perIP  *ratelimit.Limiter
+	perApp *ratelimit.Limiter
+}
+
+func newMethodLimiters(limits MethodLimits) *methodLimiters {
+	return &methodLimiters{
+		total:  ratelimit.New(limits.Total),
+		perIP:  ratelimit.New(limits.PerIP),
+		perApp: ratelimit.New(limits.PerApp),
+	}
+}
+
+// rateLimiter applies RateLimits in memory, so limits are per server
+// instance.
+type rateLimiter struct {
+	// methods are created upfront, so lookups need no locking
+	methods map[string]*methodLimiters
+	// dflt is shared by all methods without own limits
+	dflt *methodLimiters
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	methods := make(map[string]*methodLimiters, len(limits.Methods))
+	for name, l := range limits.Methods {
+		methods[name] = newMethodLimiters(l)
+	}
+	return &rateLimiter{methods: methods, dflt: newMethodLimiters(limits.Default)}
+}
+
+// appRequest is implemented by requests that have an app_id field.
+type appRequest interface {
+	GetAppId() int32
+}
+
+// allow takes a token from every bucket the call falls into, or from none
+// of them if any is empty, and then returns how long to wait. appID is 0
+// when the request doesn't name an app.
+func (r *rateLimiter) allow(fullMethod string, clientIP string, appID int32) (bool, time.Duration) {
+	limiters, ok := r.methods[path.Base(fullMethod)]
+	if !ok {
+		limiters = r.dflt
+	}
+
+	// limits shared by methods are keyed by method as well
+	keys := []ratelimit.Key{{Limiter: limiters.total, Key: fullMethod}}
+	if clientIP != "" {
+		keys = append(keys, ratelimit.Key{Limiter: limiters.perIP, Key: fullMethod + " " + clientIP})
+	}
+	if appID != 0 {
+		keys = append(keys, ratelimit.Key{Limiter: limiters.perApp, Key: fullMethod + " " + strconv.Itoa(int(appID))})
+	}
+	return ratelimit.AllowAll(time.Now(), keys...)
+}
+
+func rateLimitUnaryInterceptor(limiter *rateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var appID int32
+		if r, ok := req.(appRequest); ok {
+			appID = r.GetAppId()
+		}
+		if ok, wait := limiter.allow(info.FullMethod, server.ClientIP(ctx), appID); !ok {
+			return nil, rateLimited(wait)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// rateLimitStreamInterceptor applies limits before the first message is
+// read, so per app limits don't apply to streams.
+func rateLimitStreamInterceptor(limiter *rateLimiter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if ok, wait := limiter.allow(info.FullMethod, server.ClientIP(ss.Context()), 0); !ok {
+			return rateLimited(wait)
+		}
+		return handler(srv, ss)
+	}
+}
+
+func rateLimited(wait time.Duration) error {
+	return server.ToStatus(&auth.RetryError{Err: server.ErrRateLimited, RetryAfter: wait})
+}
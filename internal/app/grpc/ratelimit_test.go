@@ -0,0 +1,43 @@
+package grpcapp
+
+import (
+	"auth/internal/lib/ratelimit"
+	"testing"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := newRateLimiter(RateLimits{
+		Default: MethodLimits{PerIP: ratelimit.Limit{Rate: 0.001, Burst: 1}},
+		Methods: map[string]MethodLimits{
+			"Login": {
+				PerIP:  ratelimit.Limit{Rate: 0.001, Burst: 2},
+				PerApp: ratelimit.Limit{Rate: 0.001, Burst: 3},
+			},
+		},
+	})
+
+	// the calls run in order against the same limiter
+	calls := []struct {
+		name     string
+		method   string
+		clientIP string
+		appID    int32
+		want     bool
+	}{
+		{name: "login", method: "/auth.Auth/Login", clientIP: "192.0.2.1", appID: 1, want: true},
+		{name: "login burst", method: "/auth.Auth/Login", clientIP: "192.0.2.1", appID: 1, want: true},
+		{name: "login address limited", method: "/auth.Auth/Login", clientIP: "192.0.2.1", appID: 1, want: false},
+		{name: "login from another address", method: "/auth.Auth/Login", clientIP: "192.0.2.2", appID: 1, want: true},
+		{name: "login app limited", method: "/auth.Auth/Login", clientIP: "192.0.2.3", appID: 1, want: false},
+		{name: "login to another app", method: "/auth.Auth/Login", clientIP: "192.0.2.3", appID: 2, want: true},
+		{name: "default limits", method: "/auth.Auth/Register", clientIP: "192.0.2.1", want: true},
+		{name: "default limits exhausted", method: "/auth.Auth/Register", clientIP: "192.0.2.1", want: false},
+		{name: "default limits are kept per method", method: "/auth.Auth/GetJWKS", clientIP: "192.0.2.1", want: true},
+		{name: "address unknown", method: "/auth.Auth/Register", want: true},
+	}
+	for _, c := range calls {
+		if ok, _ := limiter.allow(c.method, c.clientIP, c.appID); ok != c.want {
+			t.Errorf("%s: allow() = %v, want %v", c.name, ok, c.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package httpapp
+
+import (
+	httpserver "auth/internal/http"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+type App struct {
+	log        *slog.Logger
+	httpServer *http.Server
+	port       int
+}
+
+// New create New HTTP server app.
+func New(log *slog.Logger, port int, timeout time.Duration, auth httpserver.Auth) *App {
+	mux := http.NewServeMux()
+
+	httpserver.Register(mux, log, auth)
+
+	httpServer := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+
+	return &App{log: log, httpServer: httpServer, port: port}
+}
+
+func (a *App) run() error {
+	const op = "httpapp.run()"
+
+	log := a.log.With(slog.String("op", op))
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("HTTP server is running ...", slog.String("addr", l.Addr().String()))
+
+	if err := a.httpServer.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Must Run HTTP server
+func (a *App) MustRun() {
+	err := a.run()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// For graceful shutdown
+func (a *App) Stop() {
+	const op = "httpapp.Stop()"
+
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("stopping HTTP server", slog.Int("port", a.port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.httpServer.Shutdown(ctx); err != nil {
+		log.Error("failed to stop HTTP server", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,78 @@
+package schedulerapp
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Job is a task run periodically in the background.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type App struct {
+	log    *slog.Logger
+	jobs   []Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New create New background jobs app.
+func New(log *slog.Logger, jobs ...Job) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &App{log: log, jobs: jobs, ctx: ctx, cancel: cancel}
+}
+
+// Must Run every job on its interval until Stop is called
+func (a *App) MustRun() {
+	const op = "schedulerapp.MustRun()"
+
+	log := a.log.With(slog.String("op", op))
+
+	for _, job := range a.jobs {
+		log.Info("scheduling job", slog.String("job", job.Name), slog.Duration("interval", job.Interval))
+
+		a.wg.Add(1)
+		go a.loop(job)
+	}
+	a.wg.Wait()
+}
+
+func (a *App) loop(job Job) {
+	defer a.wg.Done()
+
+	log := a.log.With(slog.String("job", job.Name))
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job.Run(a.ctx); err != nil {
+			log.Error("job failed", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// For graceful shutdown
+func (a *App) Stop() {
+	const op = "schedulerapp.Stop()"
+
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("stopping background jobs")
+
+	a.cancel()
+	a.wg.Wait()
+}
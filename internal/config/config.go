@@ -9,15 +9,131 @@ import (
 )
 
 type Config struct {
-	Env         string        `yaml:"env"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
-	GRPC        GRPCConfig    `yaml:"grpc" env-required:"true"`
+	Env                  string                `yaml:"env"`
+	StoragePath          string                `yaml:"storage_path" env-required:"true"`
+	TokenTTL             time.Duration         `yaml:"token_ttl" env-required:"true"`
+	Issuer               string                `yaml:"issuer"`
+	TokenLeeway          time.Duration         `yaml:"token_leeway" env-default:"30s"`
+	RefreshTokenTTL      time.Duration         `yaml:"refresh_token_ttl" env-default:"720h"`
+	EmailVerificationTTL time.Duration         `yaml:"email_verification_ttl" env-default:"24h"`
+	PasswordResetTTL     time.Duration         `yaml:"password_reset_ttl" env-default:"1h"`
+	PasswordPolicy       PasswordPolicyConfig  `yaml:"password_policy"`
+	AppSecretGracePeriod time.Duration         `yaml:"app_secret_grace_period" env-default:"24h"`
+	InvitationTTL        time.Duration         `yaml:"invitation_ttl" env-default:"168h"`
+	LoginThrottling      LoginThrottlingConfig `yaml:"login_throttling"`
+	MFA                  MFAConfig             `yaml:"mfa"`
+	WebAuthnSessionTTL   time.Duration         `yaml:"webauthn_session_ttl" env-default:"5m"`
+	Passwordless         PasswordlessConfig    `yaml:"passwordless"`
+	OAuth                OAuthConfig           `yaml:"oauth"`
+	GRPC                 GRPCConfig            `yaml:"grpc" env-required:"true"`
+	HTTP                 HTTPConfig            `yaml:"http"`
+	Signing              SigningConfig         `yaml:"signing"`
+	Mailer               MailerConfig          `yaml:"mailer"`
 }
 
 type GRPCConfig struct {
-	Port    int           `yaml:"port"`
-	Timeout time.Duration `yaml:"timeout"`
+	Port       int             `yaml:"port"`
+	Timeout    time.Duration   `yaml:"timeout"`
+	RateLimits RateLimitConfig `yaml:"rate_limits"`
+}
+
+// RateLimitConfig limits calls of gRPC methods, methods are keyed by name,
+// e.g. Login. Methods not listed use the default limits.
+type RateLimitConfig struct {
+	Default MethodRateLimitConfig            `yaml:"default"`
+	Methods map[string]MethodRateLimitConfig `yaml:"methods"`
+}
+
+type MethodRateLimitConfig struct {
+	// Shared by all clients
+	Total LimitConfig `yaml:"total"`
+	// Per client address
+	PerIP LimitConfig `yaml:"per_ip"`
+	// Per app named in the request
+	PerApp LimitConfig `yaml:"per_app"`
+}
+
+// LimitConfig is a token bucket, zero rate or burst turns it off.
+type LimitConfig struct {
+	// Requests per second
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type HTTPConfig struct {
+	Port    int           `yaml:"port" env-default:"8080"`
+	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+type SigningConfig struct {
+	// RS256, ES256 and EdDSA sign with a key pair published in the JWKS.
+	// HS256 signs with a random per-app secret kept in storage, nothing is
+	// published and OIDC stays off
+	Algorithm string `yaml:"algorithm" env-default:"RS256"`
+	// Optional PEM private key on disk used instead of per-app keys
+	// generated and kept in storage
+	KeyFile string `yaml:"key_file"`
+	// How long a stored key signs tokens before it is rotated
+	RotationPeriod time.Duration `yaml:"rotation_period" env-default:"720h"`
+	// How long before activation the next key is published in JWKS
+	PrepublishPeriod time.Duration `yaml:"prepublish_period" env-default:"24h"`
+}
+
+type PasswordPolicyConfig struct {
+	MinLength int `yaml:"min_length" env-default:"8"`
+}
+
+type LoginThrottlingConfig struct {
+	// Failures of a user before it is locked out, 0 turns the lockout off
+	MaxFailures int `yaml:"max_failures" env-default:"5"`
+	// Failures from an address before it is locked out, 0 turns it off
+	MaxFailuresPerIP int           `yaml:"max_failures_per_ip" env-default:"50"`
+	LockoutDuration  time.Duration `yaml:"lockout_duration" env-default:"15m"`
+	// Delay after the first failure of a user, doubled after each next one
+	BaseDelay time.Duration `yaml:"base_delay" env-default:"1s"`
+	MaxDelay  time.Duration `yaml:"max_delay" env-default:"1m"`
+	// How long a failure is counted for
+	FailureWindow time.Duration `yaml:"failure_window" env-default:"1h"`
+}
+
+type MFAConfig struct {
+	// Name of the service shown in authenticator apps
+	Issuer string `yaml:"issuer" env-default:"auth"`
+	// Base64 encoded 32 byte key authenticator app secrets are encrypted
+	// with, MFA can't be enrolled without it
+	EncryptionKey string `yaml:"encryption_key" env:"MFA_ENCRYPTION_KEY"`
+	// How long the second factor may be provided after the password
+	ChallengeTTL time.Duration `yaml:"challenge_ttl" env-default:"5m"`
+}
+
+type PasswordlessConfig struct {
+	// How long a mailed login link or code is valid
+	CodeTTL time.Duration `yaml:"code_ttl" env-default:"10m"`
+	// Wrong codes after which a code stops working
+	MaxAttempts int `yaml:"max_attempts" env-default:"5"`
+	// Page of the app the login link opens, the token is added to it as
+	// the token query parameter. The bare token is mailed without it.
+	LinkURL string `yaml:"link_url"`
+}
+
+type OAuthConfig struct {
+	// How long an authorization code can be exchanged for tokens
+	AuthorizationCodeTTL time.Duration `yaml:"authorization_code_ttl" env-default:"1m"`
+	// How long the user has to approve a device
+	DeviceCodeTTL time.Duration `yaml:"device_code_ttl" env-default:"10m"`
+	// How long a device waits between polls for tokens
+	DevicePollInterval time.Duration `yaml:"device_poll_interval" env-default:"5s"`
+	// Page users enter the code shown by a device at, the device grant is
+	// disabled without it
+	DeviceVerificationURI string `yaml:"device_verification_uri"`
+}
+
+type MailerConfig struct {
+	// stdout or file, both only record messages for local runs and tests
+	Type string `yaml:"type" env-default:"stdout"`
+	// File messages are appended to when type is file
+	Path string `yaml:"path"`
+	From string `yaml:"from" env-default:"no-reply@localhost"`
 }
 
 func EnvLoad() *Config {
@@ -0,0 +1,89 @@
+package server
+
+import (
+	auth "auth/internal/services"
+	"auth/internal/storage"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain is sent in ErrorInfo details along with the reason.
+const errorDomain = "auth"
+
+// ErrRateLimited is returned when a client exceeds a rate limit.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// errorMapping describes how a service error is reported to clients.
+// Reasons are part of the API and must not change.
+type errorMapping struct {
+	err     error
+	code    codes.Code
+	reason  string
+	message string
+}
+
+var errorMappings = []errorMapping{
+	{ErrRateLimited, codes.ResourceExhausted, "RATE_LIMITED", "rate limit exceeded"},
+	{auth.ErrTooManyAttempts, codes.ResourceExhausted, "TOO_MANY_ATTEMPTS", "too many failed login attempts"},
+	{auth.ErrInvalidCredentials, codes.Unauthenticated, "INVALID_CREDENTIALS", "invalid email or password"},
+	{auth.ErrInvalidMFACode, codes.Unauthenticated, "INVALID_MFA_CODE", "invalid mfa code"},
+	{auth.ErrInvalidLoginCode, codes.Unauthenticated, "INVALID_LOGIN_CODE", "invalid login code"},
+	{auth.ErrUnknownPasswordlessMethod, codes.InvalidArgument, "UNKNOWN_PASSWORDLESS_METHOD", "method must be link or code"},
+	{auth.ErrMFADisabled, codes.FailedPrecondition, "MFA_DISABLED", "mfa is not configured"},
+	{auth.ErrMFAEnabled, codes.FailedPrecondition, "MFA_ALREADY_ENABLED", "mfa is already enabled"},
+	{auth.ErrRecoveryCodesExist, codes.FailedPrecondition, "RECOVERY_CODES_EXIST", "recovery codes already generated"},
+	{auth.ErrPasskeysNotConfigured, codes.FailedPrecondition, "PASSKEYS_NOT_CONFIGURED", "passkeys are not configured for the app"},
+	{storage.ErrPasskeyExists, codes.AlreadyExists, "PASSKEY_EXISTS", "passkey already registered"},
+	{auth.ErrMFANotEnrolled, codes.FailedPrecondition, "MFA_NOT_ENROLLED", "mfa is not enrolled"},
+	{auth.ErrExpiredToken, codes.Unauthenticated, "TOKEN_EXPIRED", "token expired"},
+	{auth.ErrInvalidToken, codes.Unauthenticated, "INVALID_TOKEN", "invalid token"},
+	{auth.ErrWeakPassword, codes.InvalidArgument, "WEAK_PASSWORD", "password doesn't meet the policy"},
+	{auth.ErrInvalidClient, codes.Unauthenticated, "INVALID_CLIENT", "invalid app id or secret"},
+	{auth.ErrInvalidUserCode, codes.NotFound, "INVALID_USER_CODE", "user code not found or already used"},
+	{auth.ErrInvalidScope, codes.InvalidArgument, "INVALID_SCOPE", "scope is invalid or not granted to the app"},
+	{auth.ErrInvalidRedirectURI, codes.InvalidArgument, "INVALID_REDIRECT_URI", "redirect uri must be an absolute uri without a fragment"},
+	{auth.ErrEmailNotVerified, codes.FailedPrecondition, "EMAIL_NOT_VERIFIED", "email is not verified"},
+	{auth.ErrNotMember, codes.PermissionDenied, "NOT_APP_MEMBER", "user is not a member of the app"},
+	{auth.ErrPermissionDenied, codes.PermissionDenied, "PERMISSION_DENIED", "permission denied"},
+	{storage.ErrMemberNotFound, codes.NotFound, "MEMBER_NOT_FOUND", "user is not a member of the app"},
+	{storage.ErrRoleNotFound, codes.NotFound, "ROLE_NOT_FOUND", "role not found"},
+	{auth.ErrKeyRotationDisabled, codes.FailedPrecondition, "KEY_ROTATION_DISABLED", "signing keys are loaded from disk"},
+	{storage.ErrUserExists, codes.AlreadyExists, "USER_EXISTS", "user already exists"},
+	{storage.ErrAppExists, codes.AlreadyExists, "APP_EXISTS", "app already exists"},
+	{storage.ErrUserNotFound, codes.NotFound, "USER_NOT_FOUND", "user not found"},
+	{storage.ErrAppNotFound, codes.NotFound, "APP_NOT_FOUND", "app not found"},
+}
+
+// ToStatus converts an error returned by the service layer into a gRPC
+// status with ErrorInfo details. Unknown errors become Internal without
+// exposing their text. Errors that may be retried later carry RetryInfo.
+func ToStatus(err error) error {
+	var details []protoadapt.MessageV1
+	var retryErr *auth.RetryError
+	if errors.As(err, &retryErr) {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryErr.RetryAfter)})
+	}
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return statusWithReason(m.code, m.reason, m.message, details...)
+		}
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+func statusWithReason(code codes.Code, reason string, message string, details ...protoadapt.MessageV1) error {
+	st := status.New(code, message)
+	detailed, err := st.WithDetails(append([]protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	}}, details...)...)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
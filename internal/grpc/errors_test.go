@@ -0,0 +1,127 @@
+package server
+
+import (
+	auth "auth/internal/services"
+	"auth/internal/storage"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    codes.Code
+		wantReason  string
+		wantMessage string
+	}{
+		{
+			name:       "invalid credentials",
+			err:        fmt.Errorf("auth.Login: %w", auth.ErrInvalidCredentials),
+			wantCode:   codes.Unauthenticated,
+			wantReason: "INVALID_CREDENTIALS",
+		},
+		{
+			name:       "expired token",
+			err:        fmt.Errorf("auth.ValidateToken: %w", auth.ErrExpiredToken),
+			wantCode:   codes.Unauthenticated,
+			wantReason: "TOKEN_EXPIRED",
+		},
+		{
+			name:       "permission denied",
+			err:        fmt.Errorf("auth.CreateApp: %w", auth.ErrPermissionDenied),
+			wantCode:   codes.PermissionDenied,
+			wantReason: "PERMISSION_DENIED",
+		},
+		{
+			name:       "user exists",
+			err:        fmt.Errorf("storage.sqlite.SaveUser: %w", storage.ErrUserExists),
+			wantCode:   codes.AlreadyExists,
+			wantReason: "USER_EXISTS",
+		},
+		{
+			name:       "app not found",
+			err:        fmt.Errorf("auth.GetApp: %w", storage.ErrAppNotFound),
+			wantCode:   codes.NotFound,
+			wantReason: "APP_NOT_FOUND",
+		},
+		{
+			name:        "unknown error",
+			err:         errors.New("database is locked"),
+			wantCode:    codes.Internal,
+			wantMessage: "internal error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(ToStatus(tt.err))
+			if st.Code() != tt.wantCode {
+				t.Errorf("ToStatus() code = %s, want %s", st.Code(), tt.wantCode)
+			}
+			if tt.wantMessage != "" && st.Message() != tt.wantMessage {
+				t.Errorf("ToStatus() message = %q, want %q", st.Message(), tt.wantMessage)
+			}
+			if reason := errorReason(st); reason != tt.wantReason {
+				t.Errorf("ToStatus() reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// errorReason returns the reason of the ErrorInfo details of the status.
+func errorReason(st *status.Status) string {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+func TestToStatusRetryInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{
+			name:       "locked out",
+			err:        fmt.Errorf("auth.Login: %w", &auth.RetryError{Err: auth.ErrTooManyAttempts, RetryAfter: time.Minute}),
+			wantCode:   codes.ResourceExhausted,
+			wantReason: "TOO_MANY_ATTEMPTS",
+		},
+		{
+			name:       "failure delaying the next attempt",
+			err:        fmt.Errorf("auth.Login: %w", &auth.RetryError{Err: auth.ErrInvalidCredentials, RetryAfter: time.Minute}),
+			wantCode:   codes.Unauthenticated,
+			wantReason: "INVALID_CREDENTIALS",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(ToStatus(tt.err))
+			if st.Code() != tt.wantCode {
+				t.Errorf("ToStatus() code = %s, want %s", st.Code(), tt.wantCode)
+			}
+			if reason := errorReason(st); reason != tt.wantReason {
+				t.Errorf("ToStatus() reason = %q, want %q", reason, tt.wantReason)
+			}
+			var retryDelay time.Duration
+			for _, detail := range st.Details() {
+				if info, ok := detail.(*errdetails.RetryInfo); ok {
+					retryDelay = info.GetRetryDelay().AsDuration()
+				}
+			}
+			if retryDelay != time.Minute {
+				t.Errorf("ToStatus() retry delay = %s, want %s", retryDelay, time.Minute)
+			}
+		})
+	}
+}
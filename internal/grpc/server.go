@@ -1,12 +1,18 @@
 package server
 
 import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
 	authv1 "auth/protos/gen/go"
 	"context"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -16,10 +22,51 @@ const (
 )
 
 type Auth interface {
-	Login(ctx context.Context, email string, password string, appID int) (token string, err error)
+	Login(ctx context.Context, email string, password string, appID int, clientIP string) (models.LoginResult, error)
 	RegisterNewUser(ctx context.Context, email string, password string) (userID int64, err error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
-	CreateApp(ctx context.Context, email string, password string, app_name string, app_secret string) (int64, error)
+	CreateApp(ctx context.Context, app models.App) (int64, error)
+	ValidateToken(ctx context.Context, token string) (jwt.Claims, error)
+	Refresh(ctx context.Context, refreshToken string) (models.TokenPair, error)
+	Logout(ctx context.Context, token string, refreshToken string, everywhere bool) error
+	RevokeToken(ctx context.Context, token string) error
+	JWKS(ctx context.Context, appID int) (jwt.JWKS, error)
+	RotateSigningKeys(ctx context.Context, appID int, revokePrevious bool) (string, error)
+	SendVerificationEmail(ctx context.Context, email string) error
+	VerifyEmail(ctx context.Context, token string) (int64, error)
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, newPassword string) error
+	ChangePassword(ctx context.Context, currentPassword string, newPassword string, clientIP string) error
+	ChangeEmail(ctx context.Context, password string, newEmail string, clientIP string) error
+	ListApps(ctx context.Context) ([]models.App, error)
+	GetApp(ctx context.Context, appID int) (models.App, error)
+	UpdateApp(ctx context.Context, appID int, update models.AppUpdate) (models.App, error)
+	DeleteApp(ctx context.Context, appID int) error
+	RotateAppSecret(ctx context.Context, appID int, gracePeriod *time.Duration) (string, error)
+	AssignRole(ctx context.Context, userID int64, role string, appID int) error
+	RevokeRole(ctx context.Context, userID int64, role string, appID int) error
+	ListRoles(ctx context.Context, userID int64) ([]models.UserRole, error)
+	CheckPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error)
+	InviteMember(ctx context.Context, appID int, email string) error
+	AcceptInvitation(ctx context.Context, token string) (int, error)
+	AddMember(ctx context.Context, appID int, userID int64) error
+	RemoveMember(ctx context.Context, appID int, userID int64) error
+	UnlockUser(ctx context.Context, userID int64) error
+	EnrollTOTP(ctx context.Context) (secret string, uri string, err error)
+	ConfirmTOTP(ctx context.Context, code string) error
+	VerifyMFA(ctx context.Context, mfaToken string, code string, clientIP string) (models.TokenPair, error)
+	GenerateRecoveryCodes(ctx context.Context) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context) ([]string, error)
+	LoginWithRecoveryCode(ctx context.Context, mfaToken string, code string, clientIP string) (string, error)
+	BeginPasskeyRegistration(ctx context.Context, appID int) (sessionToken string, options string, err error)
+	FinishPasskeyRegistration(ctx context.Context, sessionToken string, credential string) error
+	BeginPasskeyLogin(ctx context.Context, appID int, email string) (sessionToken string, options string, err error)
+	FinishPasskeyLogin(ctx context.Context, sessionToken string, credential string, clientIP string) (models.TokenPair, error)
+	StartPasswordlessLogin(ctx context.Context, email string, appID int, method string) error
+	CompletePasswordlessLogin(ctx context.Context, token string, email string, appID int, code string, clientIP string) (models.LoginResult, error)
+	IssueClientToken(ctx context.Context, appID int, secret string, scopes []string) (models.ClientToken, error)
+	ApproveDevice(ctx context.Context, userCode string, approve bool) (int, error)
+	IntrospectToken(ctx context.Context, appID int, secret string, token string, tokenTypeHint string) (models.TokenIntrospection, error)
 }
 
 type serverAPI struct {
@@ -36,11 +83,14 @@ func (s *serverAPI) Login(ctx context.Context, req *authv1.LoginRequest) (*authv
 		return nil, err
 	}
 	// service layer
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
+	res, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()), ClientIP(ctx))
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, ToStatus(err)
 	}
-	return &authv1.LoginResponse{Token: token}, nil
+	if res.MFAToken != "" {
+		return &authv1.LoginResponse{MfaRequired: true, MfaToken: res.MFAToken}, nil
+	}
+	return &authv1.LoginResponse{Token: res.Tokens.AccessToken, RefreshToken: res.Tokens.RefreshToken}, nil
 }
 
 func (s *serverAPI) Register(ctx context.Context, req *authv1.RegisterRequest) (*authv1.RegisterResponse, error) {
@@ -50,7 +100,7 @@ func (s *serverAPI) Register(ctx context.Context, req *authv1.RegisterRequest) (
 	// service layer
 	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword())
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, ToStatus(err)
 	}
 	return &authv1.RegisterResponse{UserId: userID}, nil
 }
@@ -62,7 +112,7 @@ func (s *serverAPI) IsAdmin(ctx context.Context, req *authv1.IsAdminRequest) (*a
 	// service layer
 	isAdmin, err := s.auth.IsAdmin(ctx, req.GetUserId())
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, ToStatus(err)
 	}
 	return &authv1.IsAdminResponse{IsAdmin: isAdmin}, nil
 }
@@ -72,13 +122,620 @@ func (s *serverAPI) CreateApp(ctx context.Context, req *authv1.CreateAppRequest)
 		return nil, err
 	}
 	// service layer
-	appID, err := s.auth.CreateApp(ctx, req.GetEmail(), req.GetPassword(), req.GetAppName(), req.GetAppSecret())
+	appID, err := s.auth.CreateApp(ctx, models.App{
+		Name:                     req.GetAppName(),
+		Secret:                   req.GetAppSecret(),
+		RequireEmailVerification: req.GetRequireEmailVerification(),
+		AllowSelfRegistration:    req.AllowSelfRegistration == nil || req.GetAllowSelfRegistration(),
+		WebAuthn:                 webAuthnFromProto(req.GetWebauthn()),
+		RedirectURIs:             req.GetRedirectUris(),
+		Scopes:                   req.GetScopes(),
+	})
 	if err != nil {
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, ToStatus(err)
 	}
 	return &authv1.CreateAppResponse{AppId: appID}, nil
 }
 
+func (s *serverAPI) ValidateToken(ctx context.Context, req *authv1.ValidateTokenRequest) (*authv1.ValidateTokenResponse, error) {
+	if err := validateValidateToken(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	claims, err := s.auth.ValidateToken(ctx, req.GetToken())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.ValidateTokenResponse{
+		UserId:      claims.UID,
+		Email:       claims.Email,
+		AppId:       int32(claims.AppID),
+		ExpiresAt:   claims.ExpiresAt.Unix(),
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+		ClientId:    int32(claims.ClientID),
+		Scopes:      claims.Scopes,
+	}, nil
+}
+
+func (s *serverAPI) Refresh(ctx context.Context, req *authv1.RefreshRequest) (*authv1.RefreshResponse, error) {
+	if err := validateRefresh(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	tokens, err := s.auth.Refresh(ctx, req.GetRefreshToken())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RefreshResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+func (s *serverAPI) Logout(ctx context.Context, req *authv1.LogoutRequest) (*authv1.LogoutResponse, error) {
+	if err := validateLogout(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	err := s.auth.Logout(ctx, req.GetToken(), req.GetRefreshToken(), req.GetEverywhere())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.LogoutResponse{}, nil
+}
+
+func (s *serverAPI) RevokeToken(ctx context.Context, req *authv1.RevokeTokenRequest) (*authv1.RevokeTokenResponse, error) {
+	if err := validateRevokeToken(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.RevokeToken(ctx, req.GetToken()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RevokeTokenResponse{}, nil
+}
+
+func (s *serverAPI) GetJWKS(ctx context.Context, req *authv1.GetJWKSRequest) (*authv1.GetJWKSResponse, error) {
+	// service layer
+	jwks, err := s.auth.JWKS(ctx, int(req.GetAppId()))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+
+	keys := make([]*authv1.JWK, 0, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		keys = append(keys, &authv1.JWK{
+			Kty: k.Kty,
+			Use: k.Use,
+			Kid: k.Kid,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+			Y:   k.Y,
+		})
+	}
+	return &authv1.GetJWKSResponse{Keys: keys}, nil
+}
+
+func (s *serverAPI) RotateSigningKeys(ctx context.Context, req *authv1.RotateSigningKeysRequest) (*authv1.RotateSigningKeysResponse, error) {
+	if err := validateRotateSigningKeys(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	kid, err := s.auth.RotateSigningKeys(ctx, int(req.GetAppId()), req.GetRevokePrevious())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RotateSigningKeysResponse{Kid: kid}, nil
+}
+
+func (s *serverAPI) SendVerificationEmail(ctx context.Context, req *authv1.SendVerificationEmailRequest) (*authv1.SendVerificationEmailResponse, error) {
+	if err := validateSendVerificationEmail(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.SendVerificationEmail(ctx, req.GetEmail()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.SendVerificationEmailResponse{}, nil
+}
+
+func (s *serverAPI) VerifyEmail(ctx context.Context, req *authv1.VerifyEmailRequest) (*authv1.VerifyEmailResponse, error) {
+	if err := validateVerifyEmail(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	userID, err := s.auth.VerifyEmail(ctx, req.GetToken())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.VerifyEmailResponse{UserId: userID}, nil
+}
+
+func (s *serverAPI) RequestPasswordReset(ctx context.Context, req *authv1.RequestPasswordResetRequest) (*authv1.RequestPasswordResetResponse, error) {
+	if err := validateRequestPasswordReset(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.RequestPasswordReset(ctx, req.GetEmail()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RequestPasswordResetResponse{}, nil
+}
+
+func (s *serverAPI) ResetPassword(ctx context.Context, req *authv1.ResetPasswordRequest) (*authv1.ResetPasswordResponse, error) {
+	if err := validateResetPassword(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.ResetPassword(ctx, req.GetToken(), req.GetNewPassword()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.ResetPasswordResponse{}, nil
+}
+
+func (s *serverAPI) ChangePassword(ctx context.Context, req *authv1.ChangePasswordRequest) (*authv1.ChangePasswordResponse, error) {
+	if err := validateChangePassword(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	err := s.auth.ChangePassword(ctx, req.GetCurrentPassword(), req.GetNewPassword(), ClientIP(ctx))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.ChangePasswordResponse{}, nil
+}
+
+func (s *serverAPI) ChangeEmail(ctx context.Context, req *authv1.ChangeEmailRequest) (*authv1.ChangeEmailResponse, error) {
+	if err := validateChangeEmail(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	err := s.auth.ChangeEmail(ctx, req.GetPassword(), req.GetNewEmail(), ClientIP(ctx))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.ChangeEmailResponse{}, nil
+}
+
+func (s *serverAPI) ListApps(ctx context.Context, req *authv1.ListAppsRequest) (*authv1.ListAppsResponse, error) {
+	// service layer
+	apps, err := s.auth.ListApps(ctx)
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+
+	resp := &authv1.ListAppsResponse{Apps: make([]*authv1.App, 0, len(apps))}
+	for _, app := range apps {
+		resp.Apps = append(resp.Apps, appToProto(app))
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) GetApp(ctx context.Context, req *authv1.GetAppRequest) (*authv1.GetAppResponse, error) {
+	if err := validateGetApp(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	app, err := s.auth.GetApp(ctx, int(req.GetAppId()))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.GetAppResponse{App: appToProto(app)}, nil
+}
+
+func (s *serverAPI) UpdateApp(ctx context.Context, req *authv1.UpdateAppRequest) (*authv1.UpdateAppResponse, error) {
+	if err := validateUpdateApp(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	app, err := s.auth.UpdateApp(ctx, int(req.GetAppId()), models.AppUpdate{
+		Name:                     req.Name,
+		RequireEmailVerification: req.RequireEmailVerification,
+		AllowSelfRegistration:    req.AllowSelfRegistration,
+		WebAuthn:                 webAuthnUpdate(req.GetWebauthn()),
+		RedirectURIs:             redirectURIsUpdate(req.GetRedirectUris()),
+		Scopes:                   scopesUpdate(req.GetScopes()),
+	})
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.UpdateAppResponse{App: appToProto(app)}, nil
+}
+
+func (s *serverAPI) DeleteApp(ctx context.Context, req *authv1.DeleteAppRequest) (*authv1.DeleteAppResponse, error) {
+	if err := validateDeleteApp(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.DeleteApp(ctx, int(req.GetAppId())); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.DeleteAppResponse{}, nil
+}
+
+func (s *serverAPI) RotateAppSecret(ctx context.Context, req *authv1.RotateAppSecretRequest) (*authv1.RotateAppSecretResponse, error) {
+	if err := validateRotateAppSecret(req); err != nil {
+		return nil, err
+	}
+	var gracePeriod *time.Duration
+	if req.GracePeriodSeconds != nil {
+		d := time.Duration(req.GetGracePeriodSeconds()) * time.Second
+		gracePeriod = &d
+	}
+	// service layer
+	secret, err := s.auth.RotateAppSecret(ctx, int(req.GetAppId()), gracePeriod)
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RotateAppSecretResponse{Secret: secret}, nil
+}
+
+func (s *serverAPI) AssignRole(ctx context.Context, req *authv1.AssignRoleRequest) (*authv1.AssignRoleResponse, error) {
+	if err := validateAssignRole(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	err := s.auth.AssignRole(ctx, req.GetUserId(), req.GetRole(), int(req.GetAppId()))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.AssignRoleResponse{}, nil
+}
+
+func (s *serverAPI) RevokeRole(ctx context.Context, req *authv1.RevokeRoleRequest) (*authv1.RevokeRoleResponse, error) {
+	if err := validateRevokeRole(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	err := s.auth.RevokeRole(ctx, req.GetUserId(), req.GetRole(), int(req.GetAppId()))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RevokeRoleResponse{}, nil
+}
+
+func (s *serverAPI) ListRoles(ctx context.Context, req *authv1.ListRolesRequest) (*authv1.ListRolesResponse, error) {
+	// service layer
+	roles, err := s.auth.ListRoles(ctx, req.GetUserId())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+
+	resp := &authv1.ListRolesResponse{Roles: make([]*authv1.Role, 0, len(roles))}
+	for _, r := range roles {
+		resp.Roles = append(resp.Roles, &authv1.Role{
+			Name:        r.Role.Name,
+			Description: r.Role.Description,
+			Permissions: r.Role.Permissions,
+			AppId:       int32(r.AppID),
+		})
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) CheckPermission(ctx context.Context, req *authv1.CheckPermissionRequest) (*authv1.CheckPermissionResponse, error) {
+	if err := validateCheckPermission(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	has, err := s.auth.CheckPermission(ctx, req.GetUserId(), int(req.GetAppId()), req.GetPermission())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.CheckPermissionResponse{HasPermission: has}, nil
+}
+
+func (s *serverAPI) InviteMember(ctx context.Context, req *authv1.InviteMemberRequest) (*authv1.InviteMemberResponse, error) {
+	if err := validateInviteMember(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.InviteMember(ctx, int(req.GetAppId()), req.GetEmail()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.InviteMemberResponse{}, nil
+}
+
+func (s *serverAPI) AcceptInvitation(ctx context.Context, req *authv1.AcceptInvitationRequest) (*authv1.AcceptInvitationResponse, error) {
+	if err := validateAcceptInvitation(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	appID, err := s.auth.AcceptInvitation(ctx, req.GetToken())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.AcceptInvitationResponse{AppId: int32(appID)}, nil
+}
+
+func (s *serverAPI) AddMember(ctx context.Context, req *authv1.AddMemberRequest) (*authv1.AddMemberResponse, error) {
+	if err := validateMember(req.GetAppId(), req.GetUserId()); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.AddMember(ctx, int(req.GetAppId()), req.GetUserId()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.AddMemberResponse{}, nil
+}
+
+func (s *serverAPI) RemoveMember(ctx context.Context, req *authv1.RemoveMemberRequest) (*authv1.RemoveMemberResponse, error) {
+	if err := validateMember(req.GetAppId(), req.GetUserId()); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.RemoveMember(ctx, int(req.GetAppId()), req.GetUserId()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RemoveMemberResponse{}, nil
+}
+
+func (s *serverAPI) UnlockUser(ctx context.Context, req *authv1.UnlockUserRequest) (*authv1.UnlockUserResponse, error) {
+	if req.GetUserId() == emptyIntValue {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	// service layer
+	if err := s.auth.UnlockUser(ctx, req.GetUserId()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.UnlockUserResponse{}, nil
+}
+
+func (s *serverAPI) EnrollTOTP(ctx context.Context, req *authv1.EnrollTOTPRequest) (*authv1.EnrollTOTPResponse, error) {
+	// service layer
+	secret, uri, err := s.auth.EnrollTOTP(ctx)
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.EnrollTOTPResponse{Secret: secret, Uri: uri}, nil
+}
+
+func (s *serverAPI) ConfirmTOTP(ctx context.Context, req *authv1.ConfirmTOTPRequest) (*authv1.ConfirmTOTPResponse, error) {
+	if err := validateConfirmTOTP(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.ConfirmTOTP(ctx, req.GetCode()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.ConfirmTOTPResponse{}, nil
+}
+
+func (s *serverAPI) VerifyMFA(ctx context.Context, req *authv1.VerifyMFARequest) (*authv1.VerifyMFAResponse, error) {
+	if err := validateVerifyMFA(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	tokens, err := s.auth.VerifyMFA(ctx, req.GetMfaToken(), req.GetCode(), ClientIP(ctx))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.VerifyMFAResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+func (s *serverAPI) GenerateRecoveryCodes(ctx context.Context, req *authv1.GenerateRecoveryCodesRequest) (*authv1.GenerateRecoveryCodesResponse, error) {
+	// service layer
+	codes, err := s.auth.GenerateRecoveryCodes(ctx)
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.GenerateRecoveryCodesResponse{Codes: codes}, nil
+}
+
+func (s *serverAPI) RegenerateRecoveryCodes(ctx context.Context, req *authv1.RegenerateRecoveryCodesRequest) (*authv1.RegenerateRecoveryCodesResponse, error) {
+	// service layer
+	codes, err := s.auth.RegenerateRecoveryCodes(ctx)
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.RegenerateRecoveryCodesResponse{Codes: codes}, nil
+}
+
+func (s *serverAPI) LoginWithRecoveryCode(ctx context.Context, req *authv1.LoginWithRecoveryCodeRequest) (*authv1.LoginWithRecoveryCodeResponse, error) {
+	if err := validateLoginWithRecoveryCode(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	token, err := s.auth.LoginWithRecoveryCode(ctx, req.GetMfaToken(), req.GetCode(), ClientIP(ctx))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.LoginWithRecoveryCodeResponse{PasswordResetToken: token}, nil
+}
+
+func (s *serverAPI) BeginPasskeyRegistration(ctx context.Context, req *authv1.BeginPasskeyRegistrationRequest) (*authv1.BeginPasskeyRegistrationResponse, error) {
+	if req.GetAppId() == emptyIntValue {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	// service layer
+	token, options, err := s.auth.BeginPasskeyRegistration(ctx, int(req.GetAppId()))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.BeginPasskeyRegistrationResponse{SessionToken: token, Options: options}, nil
+}
+
+func (s *serverAPI) FinishPasskeyRegistration(ctx context.Context, req *authv1.FinishPasskeyRegistrationRequest) (*authv1.FinishPasskeyRegistrationResponse, error) {
+	if err := validatePasskeyCeremony(req.GetSessionToken(), req.GetCredential()); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.FinishPasskeyRegistration(ctx, req.GetSessionToken(), req.GetCredential()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.FinishPasskeyRegistrationResponse{}, nil
+}
+
+func (s *serverAPI) BeginPasskeyLogin(ctx context.Context, req *authv1.BeginPasskeyLoginRequest) (*authv1.BeginPasskeyLoginResponse, error) {
+	if req.GetAppId() == emptyIntValue {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	// service layer
+	token, options, err := s.auth.BeginPasskeyLogin(ctx, int(req.GetAppId()), req.GetEmail())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.BeginPasskeyLoginResponse{SessionToken: token, Options: options}, nil
+}
+
+func (s *serverAPI) FinishPasskeyLogin(ctx context.Context, req *authv1.FinishPasskeyLoginRequest) (*authv1.FinishPasskeyLoginResponse, error) {
+	if err := validatePasskeyCeremony(req.GetSessionToken(), req.GetCredential()); err != nil {
+		return nil, err
+	}
+	// service layer
+	tokens, err := s.auth.FinishPasskeyLogin(ctx, req.GetSessionToken(), req.GetCredential(), ClientIP(ctx))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.FinishPasskeyLoginResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+}
+
+func (s *serverAPI) IssueClientToken(ctx context.Context, req *authv1.IssueClientTokenRequest) (*authv1.IssueClientTokenResponse, error) {
+	if err := validateIssueClientToken(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	token, err := s.auth.IssueClientToken(ctx, int(req.GetAppId()), req.GetAppSecret(), req.GetScopes())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.IssueClientTokenResponse{
+		Token:     token.AccessToken,
+		ExpiresAt: token.ExpiresAt.Unix(),
+		Scopes:    token.Scopes,
+	}, nil
+}
+
+func (s *serverAPI) ApproveDevice(ctx context.Context, req *authv1.ApproveDeviceRequest) (*authv1.ApproveDeviceResponse, error) {
+	if req.GetUserCode() == emptyStringValue {
+		return nil, status.Error(codes.InvalidArgument, "user_code is required")
+	}
+	// service layer
+	appID, err := s.auth.ApproveDevice(ctx, req.GetUserCode(), !req.GetDeny())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.ApproveDeviceResponse{AppId: int32(appID)}, nil
+}
+
+func (s *serverAPI) IntrospectToken(ctx context.Context, req *authv1.IntrospectTokenRequest) (*authv1.IntrospectTokenResponse, error) {
+	if err := validateIntrospectToken(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	info, err := s.auth.IntrospectToken(ctx, int(req.GetAppId()), req.GetAppSecret(), req.GetToken(), req.GetTokenTypeHint())
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	if !info.Active {
+		return &authv1.IntrospectTokenResponse{}, nil
+	}
+	resp := &authv1.IntrospectTokenResponse{
+		Active:    true,
+		TokenType: info.TokenType,
+		Sub:       info.Subject,
+		ClientId:  int32(info.ClientID),
+		Scope:     strings.Join(info.Scopes, " "),
+		Exp:       info.ExpiresAt.Unix(),
+	}
+	if !info.IssuedAt.IsZero() {
+		resp.Iat = info.IssuedAt.Unix()
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) StartPasswordlessLogin(ctx context.Context, req *authv1.StartPasswordlessLoginRequest) (*authv1.StartPasswordlessLoginResponse, error) {
+	if err := validateStartPasswordlessLogin(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	if err := s.auth.StartPasswordlessLogin(ctx, req.GetEmail(), int(req.GetAppId()), req.GetMethod()); err != nil {
+		return nil, ToStatus(err)
+	}
+	return &authv1.StartPasswordlessLoginResponse{}, nil
+}
+
+func (s *serverAPI) CompletePasswordlessLogin(ctx context.Context, req *authv1.CompletePasswordlessLoginRequest) (*authv1.CompletePasswordlessLoginResponse, error) {
+	if err := validateCompletePasswordlessLogin(req); err != nil {
+		return nil, err
+	}
+	// service layer
+	res, err := s.auth.CompletePasswordlessLogin(ctx, req.GetToken(), req.GetEmail(), int(req.GetAppId()), req.GetCode(), ClientIP(ctx))
+	if err != nil {
+		return nil, ToStatus(err)
+	}
+	if res.MFAToken != "" {
+		return &authv1.CompletePasswordlessLoginResponse{MfaRequired: true, MfaToken: res.MFAToken}, nil
+	}
+	return &authv1.CompletePasswordlessLoginResponse{Token: res.Tokens.AccessToken, RefreshToken: res.Tokens.RefreshToken}, nil
+}
+
+// ClientIP returns the address of the calling peer without the port, or ""
+// if it isn't known.
+func ClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func appToProto(app models.App) *authv1.App {
+	var previousExpiresAt int64
+	if !app.PreviousSecretExpiresAt.IsZero() {
+		previousExpiresAt = app.PreviousSecretExpiresAt.Unix()
+	}
+	return &authv1.App{
+		Id:                       int32(app.ID),
+		Name:                     app.Name,
+		RequireEmailVerification: app.RequireEmailVerification,
+		PreviousSecretExpiresAt:  previousExpiresAt,
+		AllowSelfRegistration:    app.AllowSelfRegistration,
+		Webauthn: &authv1.WebAuthnConfig{
+			RpId:    app.WebAuthn.RPID,
+			Origins: app.WebAuthn.Origins,
+		},
+		RedirectUris: app.RedirectURIs,
+		Scopes:       app.Scopes,
+	}
+}
+
+func webAuthnFromProto(cfg *authv1.WebAuthnConfig) models.WebAuthnConfig {
+	return models.WebAuthnConfig{RPID: cfg.GetRpId(), Origins: cfg.GetOrigins()}
+}
+
+// webAuthnUpdate returns nil when the relying party isn't changed.
+func webAuthnUpdate(cfg *authv1.WebAuthnConfig) *models.WebAuthnConfig {
+	if cfg == nil {
+		return nil
+	}
+	update := webAuthnFromProto(cfg)
+	return &update
+}
+
+// redirectURIsUpdate returns nil when the redirect URIs aren't changed.
+func redirectURIsUpdate(uris *authv1.RedirectURIs) *[]string {
+	if uris == nil {
+		return nil
+	}
+	update := uris.GetUris()
+	return &update
+}
+
+// scopesUpdate returns nil when the scopes aren't changed.
+func scopesUpdate(scopes *authv1.Scopes) *[]string {
+	if scopes == nil {
+		return nil
+	}
+	update := scopes.GetScopes()
+	return &update
+}
+
 // Validators
 func validateCreate(req *authv1.CreateAppRequest) error {
 	if err := validateRequest(req.GetAppName(), emptyStringValue); err != nil {
@@ -87,6 +744,13 @@ func validateCreate(req *authv1.CreateAppRequest) error {
 	if err := validateRequest(req.GetAppSecret(), emptyStringValue); err != nil {
 		return err
 	}
+	return nil
+}
+
+func validateLogin(req *authv1.LoginRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
 	if err := validateRequest(req.GetEmail(), emptyStringValue); err != nil {
 		return err
 	}
@@ -96,36 +760,291 @@ func validateCreate(req *authv1.CreateAppRequest) error {
 	return nil
 }
 
-func validateLogin(req *authv1.LoginRequest) error {
-	if err := validateRequest(req.GetAppId(), emptyIntValue); err != nil {
+func validateRegister(req *authv1.RegisterRequest) error {
+	if err := validateRequest(req.GetEmail(), emptyStringValue); err != nil {
+		return err
+	}
+	if err := validateRequest(req.GetPassword(), emptyStringValue); err != nil {
 		return err
 	}
+	return nil
+}
+
+func validateIsAdmin(req *authv1.IsAdminRequest) error {
+	if err := validateRequest(req.GetUserId(), emptyIntValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateValidateToken(req *authv1.ValidateTokenRequest) error {
+	if err := validateRequest(req.GetToken(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateRefresh(req *authv1.RefreshRequest) error {
+	if err := validateRequest(req.GetRefreshToken(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateLogout(req *authv1.LogoutRequest) error {
+	if err := validateRequest(req.GetToken(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateRevokeToken(req *authv1.RevokeTokenRequest) error {
+	if err := validateRequest(req.GetToken(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateRotateSigningKeys(req *authv1.RotateSigningKeysRequest) error {
+	// validateRequest compares through interface{}, where int32 never
+	// equals the untyped zero
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	return nil
+}
+
+func validateSendVerificationEmail(req *authv1.SendVerificationEmailRequest) error {
 	if err := validateRequest(req.GetEmail(), emptyStringValue); err != nil {
 		return err
 	}
-	if err := validateRequest(req.GetPassword(), emptyStringValue); err != nil {
+	return nil
+}
+
+func validateVerifyEmail(req *authv1.VerifyEmailRequest) error {
+	if err := validateRequest(req.GetToken(), emptyStringValue); err != nil {
 		return err
 	}
 	return nil
 }
 
-func validateRegister(req *authv1.RegisterRequest) error {
+func validateRequestPasswordReset(req *authv1.RequestPasswordResetRequest) error {
 	if err := validateRequest(req.GetEmail(), emptyStringValue); err != nil {
 		return err
 	}
+	return nil
+}
+
+func validateResetPassword(req *authv1.ResetPasswordRequest) error {
+	if err := validateRequest(req.GetToken(), emptyStringValue); err != nil {
+		return err
+	}
+	if err := validateRequest(req.GetNewPassword(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateChangePassword(req *authv1.ChangePasswordRequest) error {
+	if err := validateRequest(req.GetCurrentPassword(), emptyStringValue); err != nil {
+		return err
+	}
+	if err := validateRequest(req.GetNewPassword(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateChangeEmail(req *authv1.ChangeEmailRequest) error {
 	if err := validateRequest(req.GetPassword(), emptyStringValue); err != nil {
 		return err
 	}
+	if err := validateRequest(req.GetNewEmail(), emptyStringValue); err != nil {
+		return err
+	}
 	return nil
 }
 
-func validateIsAdmin(req *authv1.IsAdminRequest) error {
-	if err := validateRequest(req.GetUserId(), emptyIntValue); err != nil {
+func validateGetApp(req *authv1.GetAppRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	return nil
+}
+
+func validateUpdateApp(req *authv1.UpdateAppRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.Name != nil && req.GetName() == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "name can't be empty")
+	}
+	return nil
+}
+
+func validateDeleteApp(req *authv1.DeleteAppRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	return nil
+}
+
+func validateRotateAppSecret(req *authv1.RotateAppSecretRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.GetGracePeriodSeconds() < 0 {
+		return status.Error(codes.InvalidArgument, "grace_period_seconds can't be negative")
+	}
+	return nil
+}
+
+func validateAssignRole(req *authv1.AssignRoleRequest) error {
+	if req.GetUserId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if err := validateRequest(req.GetRole(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateRevokeRole(req *authv1.RevokeRoleRequest) error {
+	if req.GetUserId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if err := validateRequest(req.GetRole(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateCheckPermission(req *authv1.CheckPermissionRequest) error {
+	if req.GetUserId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if err := validateRequest(req.GetPermission(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateInviteMember(req *authv1.InviteMemberRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if err := validateRequest(req.GetEmail(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateAcceptInvitation(req *authv1.AcceptInvitationRequest) error {
+	if err := validateRequest(req.GetToken(), emptyStringValue); err != nil {
 		return err
 	}
 	return nil
 }
 
+func validateConfirmTOTP(req *authv1.ConfirmTOTPRequest) error {
+	if err := validateRequest(req.GetCode(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateVerifyMFA(req *authv1.VerifyMFARequest) error {
+	if err := validateRequest(req.GetMfaToken(), emptyStringValue); err != nil {
+		return err
+	}
+	if err := validateRequest(req.GetCode(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateLoginWithRecoveryCode(req *authv1.LoginWithRecoveryCodeRequest) error {
+	if err := validateRequest(req.GetMfaToken(), emptyStringValue); err != nil {
+		return err
+	}
+	if err := validateRequest(req.GetCode(), emptyStringValue); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validatePasskeyCeremony(sessionToken string, credential string) error {
+	if sessionToken == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "session_token is required")
+	}
+	if credential == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "credential is required")
+	}
+	return nil
+}
+
+func validateIssueClientToken(req *authv1.IssueClientTokenRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.GetAppSecret() == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "app_secret is required")
+	}
+	return nil
+}
+
+func validateIntrospectToken(req *authv1.IntrospectTokenRequest) error {
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.GetAppSecret() == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "app_secret is required")
+	}
+	if req.GetToken() == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	return nil
+}
+
+func validateStartPasswordlessLogin(req *authv1.StartPasswordlessLoginRequest) error {
+	if req.GetEmail() == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "email is required")
+	}
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.GetMethod() != models.PasswordlessLink && req.GetMethod() != models.PasswordlessCode {
+		return status.Error(codes.InvalidArgument, "method must be link or code")
+	}
+	return nil
+}
+
+func validateCompletePasswordlessLogin(req *authv1.CompletePasswordlessLoginRequest) error {
+	if req.GetToken() != emptyStringValue {
+		return nil
+	}
+	if req.GetEmail() == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "token or email is required")
+	}
+	if req.GetAppId() == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if req.GetCode() == emptyStringValue {
+		return status.Error(codes.InvalidArgument, "code is required")
+	}
+	return nil
+}
+
+func validateMember(appID int32, userID int64) error {
+	if appID == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if userID == emptyIntValue {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	return nil
+}
+
 func validateRequest(input interface{}, emptyValue interface{}) error {
 	if input == emptyValue {
 		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s is required", input))
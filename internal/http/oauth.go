@@ -0,0 +1,575 @@
+package httpserver
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	auth "auth/internal/services"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OAuth 2.0 error codes, RFC 6749 sections 4.1.2.1 and 5.2
+const (
+	oauthInvalidRequest          = "invalid_request"
+	oauthInvalidClient           = "invalid_client"
+	oauthInvalidGrant            = "invalid_grant"
+	oauthInvalidScope            = "invalid_scope"
+	oauthUnsupportedGrantType    = "unsupported_grant_type"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	oauthAccessDenied            = "access_denied"
+	oauthServerError             = "server_error"
+	oauthUnauthorizedClient      = "unauthorized_client"
+	// device authorization grant, RFC 8628 section 3.5
+	oauthAuthorizationPending = "authorization_pending"
+	oauthSlowDown             = "slow_down"
+	oauthExpiredToken         = "expired_token"
+	// bearer token error, RFC 6750 section 3.1
+	oauthInvalidToken = "invalid_token"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in to {{.AppName}}</title>
+</head>
+<body>
+<h1>Log in to {{.AppName}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/authorize">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+{{if .MFARequired}}<p><label>Authenticator code <input name="mfa_code" inputmode="numeric" autocomplete="one-time-code" required></label></p>{{end}}
+<p><button type="submit">Log in</button></p>
+</form>
+</body>
+</html>
+`))
+
+type loginForm struct {
+	AppName             string
+	ClientID            int
+	RedirectURI         string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Scope               string
+	Nonce               string
+	Email               string
+	MFARequired         bool
+	Error               string
+	CSRFToken           string
+}
+
+// csrfCookie holds the token the login form has to post back, so that a
+// form posted from another site is refused.
+const csrfCookie = "authorize_csrf"
+
+// Authorize is the authorization endpoint of the authorization_code grant.
+// GET shows the login form, the form is posted back here and redirects to
+// the client with the code.
+func (s *serverAPI) Authorize(w http.ResponseWriter, r *http.Request) {
+	const op = "httpserver.Authorize"
+
+	log := s.log.With(slog.String("op", op))
+
+	// the login form must not be framed by another site
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	clientID, err := strconv.Atoi(r.Form.Get("client_id"))
+	if err != nil || clientID <= 0 {
+		writeError(w, http.StatusBadRequest, "client_id must be a positive integer")
+		return
+	}
+	req := models.AuthorizationRequest{
+		AppID:               clientID,
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Scopes:              strings.Fields(r.Form.Get("scope")),
+		Nonce:               r.Form.Get("nonce"),
+	}
+	state := r.Form.Get("state")
+
+	// service layer
+	app, err := s.auth.CheckAuthorizationRequest(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			// never redirect to an unverified client
+			writeError(w, http.StatusBadRequest, "unknown client")
+		case errors.Is(err, auth.ErrRedirectURINotRegistered):
+			writeError(w, http.StatusBadRequest, "redirect_uri is not registered for the client")
+		case errors.Is(err, auth.ErrPKCERequired):
+			redirectError(w, r, req.RedirectURI, state, oauthInvalidRequest, "code_challenge with the S256 method is required")
+		default:
+			log.Error("failed to check authorization request", slog.String("error", err.Error()))
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+	if r.Form.Get("response_type") != "code" {
+		redirectError(w, r, req.RedirectURI, state, oauthUnsupportedResponseType, "response_type must be code")
+		return
+	}
+
+	form := loginForm{
+		AppName:             app.Name,
+		ClientID:            app.ID,
+		RedirectURI:         req.RedirectURI,
+		State:               state,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Scope:               r.Form.Get("scope"),
+		Nonce:               req.Nonce,
+	}
+	form.CSRFToken, err = csrfToken(w, r)
+	if err != nil {
+		log.Error("failed to generate csrf token", slog.String("error", err.Error()))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if r.Method == http.MethodGet {
+		renderLogin(w, http.StatusOK, form)
+		return
+	}
+
+	posted := r.PostForm.Get("csrf_token")
+	if posted == "" || subtle.ConstantTimeCompare([]byte(posted), []byte(form.CSRFToken)) != 1 {
+		log.Warn("csrf token mismatch")
+		form.Error = "The form has expired, please try again."
+		renderLogin(w, http.StatusForbidden, form)
+		return
+	}
+
+	form.Email = r.PostForm.Get("email")
+	mfaCode := r.PostForm.Get("mfa_code")
+	form.MFARequired = mfaCode != ""
+	// service layer
+	code, err := s.auth.Authorize(r.Context(), req, form.Email, r.PostForm.Get("password"), mfaCode, clientIP(r))
+	if err != nil {
+		var retry *auth.RetryError
+		switch {
+		case errors.Is(err, auth.ErrMFARequired):
+			form.MFARequired = true
+			form.Error = "Enter the code from your authenticator app."
+			renderLogin(w, http.StatusUnauthorized, form)
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			form.Error = "Invalid email or password."
+			renderLogin(w, http.StatusUnauthorized, form)
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			form.Error = "Invalid authenticator code."
+			renderLogin(w, http.StatusUnauthorized, form)
+		case errors.As(err, &retry):
+			w.Header().Set("Retry-After", strconv.Itoa(int((retry.RetryAfter+time.Second-1)/time.Second)))
+			form.Error = "Too many failed attempts, try again later."
+			renderLogin(w, http.StatusTooManyRequests, form)
+		case errors.Is(err, auth.ErrTooManyAttempts):
+			form.Error = "Too many failed attempts, try again later."
+			renderLogin(w, http.StatusTooManyRequests, form)
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			form.Error = "Verify your email before logging in."
+			renderLogin(w, http.StatusForbidden, form)
+		case errors.Is(err, auth.ErrNotMember):
+			redirectError(w, r, req.RedirectURI, state, oauthAccessDenied, "user is not a member of the app")
+		default:
+			log.Error("failed to authorize", slog.String("error", err.Error()))
+			redirectError(w, r, req.RedirectURI, state, oauthServerError, "")
+		}
+		return
+	}
+
+	redirect(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {state}})
+}
+
+// Token is the token endpoint. It exchanges authorization codes for
+// tokens and issues tokens to apps by the client_credentials grant.
+// Clients authenticate with HTTP Basic or the client_secret parameter,
+// public clients exchanging codes send only client_id.
+func (s *serverAPI) Token(w http.ResponseWriter, r *http.Request) {
+	const op = "httpserver.Token"
+
+	log := s.log.With(slog.String("op", op))
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "invalid form")
+		return
+	}
+
+	rawClientID, clientSecret, basic := clientCredentials(r)
+	clientID, err := strconv.Atoi(rawClientID)
+	if err != nil || clientID <= 0 {
+		writeInvalidClient(w, basic)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		s.exchangeCode(w, r, log, clientID, clientSecret, basic)
+	case "client_credentials":
+		s.issueClientToken(w, r, log, clientID, clientSecret, basic)
+	case deviceCodeGrantType:
+		s.pollDevice(w, r, log, clientID, clientSecret, basic)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType,
+			"grant_type must be authorization_code, client_credentials or "+deviceCodeGrantType)
+	}
+}
+
+// DeviceAuthorization is the device authorization endpoint, it starts the
+// device grant and returns the codes the device shows and polls with.
+func (s *serverAPI) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	const op = "httpserver.DeviceAuthorization"
+
+	log := s.log.With(slog.String("op", op))
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "invalid form")
+		return
+	}
+
+	rawClientID, clientSecret, basic := clientCredentials(r)
+	clientID, err := strconv.Atoi(rawClientID)
+	if err != nil || clientID <= 0 {
+		writeInvalidClient(w, basic)
+		return
+	}
+
+	// service layer
+	device, err := s.auth.AuthorizeDevice(r.Context(), clientID, clientSecret)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			writeInvalidClient(w, basic)
+		case errors.Is(err, auth.ErrDeviceFlowDisabled):
+			writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "device authorization is not enabled")
+		default:
+			log.Error("failed to authorize device", slog.String("error", err.Error()))
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		}
+		return
+	}
+
+	writeTokens(w, map[string]interface{}{
+		"device_code":               device.DeviceCode,
+		"user_code":                 device.UserCode,
+		"verification_uri":          device.VerificationURI,
+		"verification_uri_complete": device.VerificationURIComplete,
+		"expires_in":                expiresIn(device.ExpiresAt),
+		"interval":                  int64(device.PollInterval / time.Second),
+	})
+}
+
+// Introspect is the token introspection endpoint of RFC 7662, apps
+// authenticate like on the token endpoint.
+func (s *serverAPI) Introspect(w http.ResponseWriter, r *http.Request) {
+	const op = "httpserver.Introspect"
+
+	log := s.log.With(slog.String("op", op))
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "invalid form")
+		return
+	}
+
+	rawClientID, clientSecret, basic := clientCredentials(r)
+	clientID, err := strconv.Atoi(rawClientID)
+	if err != nil || clientID <= 0 {
+		writeInvalidClient(w, basic)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "token is required")
+		return
+	}
+
+	// service layer
+	info, err := s.auth.IntrospectToken(r.Context(), clientID, clientSecret, token, r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			writeInvalidClient(w, basic)
+			return
+		}
+		log.Error("failed to introspect token", slog.String("error", err.Error()))
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+
+	if !info.Active {
+		writeTokens(w, map[string]interface{}{"active": false})
+		return
+	}
+	body := map[string]interface{}{
+		"active":     true,
+		"token_type": info.TokenType,
+		"sub":        info.Subject,
+		"client_id":  strconv.Itoa(info.ClientID),
+		"exp":        info.ExpiresAt.Unix(),
+	}
+	if len(info.Scopes) > 0 {
+		body["scope"] = strings.Join(info.Scopes, " ")
+	}
+	if !info.IssuedAt.IsZero() {
+		body["iat"] = info.IssuedAt.Unix()
+	}
+	writeTokens(w, body)
+}
+
+// exchangeCode handles the authorization_code grant.
+func (s *serverAPI) exchangeCode(w http.ResponseWriter, r *http.Request, log *slog.Logger, clientID int, clientSecret string, basic bool) {
+	exchange := models.CodeExchange{
+		AppID:        clientID,
+		ClientSecret: clientSecret,
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+	}
+	if exchange.Code == "" || exchange.RedirectURI == "" || exchange.CodeVerifier == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "code, redirect_uri and code_verifier are required")
+		return
+	}
+
+	// service layer
+	tokens, err := s.auth.ExchangeAuthorizationCode(r.Context(), exchange)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			writeInvalidClient(w, basic)
+		case errors.Is(err, auth.ErrInvalidGrant):
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "authorization code is invalid, expired or was issued to another client")
+		default:
+			log.Error("failed to exchange authorization code", slog.String("error", err.Error()))
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		}
+		return
+	}
+
+	body := map[string]interface{}{
+		"access_token":  tokens.AccessToken,
+		"token_type":    "Bearer",
+		"expires_in":    expiresIn(tokens.ExpiresAt),
+		"refresh_token": tokens.RefreshToken,
+	}
+	if tokens.IDToken != "" {
+		body["id_token"] = tokens.IDToken
+	}
+	writeTokens(w, body)
+}
+
+// issueClientToken handles the client_credentials grant.
+func (s *serverAPI) issueClientToken(w http.ResponseWriter, r *http.Request, log *slog.Logger, clientID int, clientSecret string, basic bool) {
+	// service layer
+	token, err := s.auth.IssueClientToken(r.Context(), clientID, clientSecret, strings.Fields(r.PostForm.Get("scope")))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			writeInvalidClient(w, basic)
+		case errors.Is(err, auth.ErrInvalidScope):
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "scope is invalid or not granted to the client")
+		default:
+			log.Error("failed to issue client token", slog.String("error", err.Error()))
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		}
+		return
+	}
+
+	writeTokens(w, map[string]interface{}{
+		"access_token": token.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   expiresIn(token.ExpiresAt),
+		"scope":        strings.Join(token.Scopes, " "),
+	})
+}
+
+// pollDevice handles the device_code grant.
+func (s *serverAPI) pollDevice(w http.ResponseWriter, r *http.Request, log *slog.Logger, clientID int, clientSecret string, basic bool) {
+	deviceCode := r.PostForm.Get("device_code")
+	if deviceCode == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "device_code is required")
+		return
+	}
+
+	// service layer
+	tokens, err := s.auth.PollDevice(r.Context(), clientID, clientSecret, deviceCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			writeInvalidClient(w, basic)
+		case errors.Is(err, auth.ErrAuthorizationPending):
+			writeOAuthError(w, http.StatusBadRequest, oauthAuthorizationPending, "")
+		case errors.Is(err, auth.ErrSlowDown):
+			writeOAuthError(w, http.StatusBadRequest, oauthSlowDown, "")
+		case errors.Is(err, auth.ErrAccessDenied):
+			writeOAuthError(w, http.StatusBadRequest, oauthAccessDenied, "the user denied the device")
+		case errors.Is(err, auth.ErrDeviceCodeExpired):
+			writeOAuthError(w, http.StatusBadRequest, oauthExpiredToken, "device code expired")
+		case errors.Is(err, auth.ErrInvalidGrant):
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "device code is invalid, used or was issued to another client")
+		default:
+			log.Error("failed to poll device authorization", slog.String("error", err.Error()))
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		}
+		return
+	}
+
+	writeTokens(w, map[string]interface{}{
+		"access_token":  tokens.AccessToken,
+		"token_type":    "Bearer",
+		"expires_in":    expiresIn(tokens.ExpiresAt),
+		"refresh_token": tokens.RefreshToken,
+	})
+}
+
+func writeTokens(w http.ResponseWriter, body map[string]interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, body)
+}
+
+// expiresIn returns the seconds left until expiresAt.
+func expiresIn(expiresAt time.Time) int64 {
+	return int64(time.Until(expiresAt).Round(time.Second) / time.Second)
+}
+
+// clientCredentials returns the client id and secret of a token request
+// and whether they came in the Authorization header.
+func clientCredentials(r *http.Request) (string, string, bool) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		// both are form encoded before they are put in the header
+		if unescaped, err := url.QueryUnescape(id); err == nil {
+			id = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(secret); err == nil {
+			secret = unescaped
+		}
+		return id, secret, true
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+}
+
+func renderLogin(w http.ResponseWriter, code int, form loginForm) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = loginPage.Execute(w, form)
+}
+
+// csrfToken returns the token of the login form, the one in the cookie if
+// the browser sent it, otherwise a new one that is set in the cookie.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	token, err := securetoken.New()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/authorize",
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// redirect sends the user agent back to the client with params added to
+// the redirect uri.
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+	query := u.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI string, state string, code string, description string) {
+	redirect(w, r, redirectURI, url.Values{
+		"error":             {code},
+		"error_description": {description},
+		"state":             {state},
+	})
+}
+
+func writeOAuthError(w http.ResponseWriter, code int, oauthCode string, description string) {
+	body := map[string]string{"error": oauthCode}
+	if description != "" {
+		body["error_description"] = description
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, body)
+}
+
+func writeInvalidClient(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+	writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+}
+
+// clientIP returns the address of the user agent without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"auth/internal/models"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeAuth accepts every authorization request and login. Methods the
+// tests don't use panic.
+type fakeAuth struct {
+	Auth
+}
+
+func (fakeAuth) CheckAuthorizationRequest(_ context.Context, req models.AuthorizationRequest) (models.App, error) {
+	return models.App{ID: req.AppID, Name: "app"}, nil
+}
+
+func (fakeAuth) Authorize(_ context.Context, req models.AuthorizationRequest, email string, password string, mfaCode string, clientIP string) (string, error) {
+	return "code", nil
+}
+
+func TestAuthorizeCSRF(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux, slog.New(slog.NewTextHandler(io.Discard, nil)), fakeAuth{})
+
+	params := url.Values{
+		"client_id":             {"1"},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"response_type":         {"code"},
+		"code_challenge":        {"challenge"},
+		"code_challenge_method": {"S256"},
+		"state":                 {"xyz"},
+	}
+	get := httptest.NewRecorder()
+	mux.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil))
+	if get.Code != http.StatusOK {
+		t.Fatalf("GET /authorize status = %d, want %d", get.Code, http.StatusOK)
+	}
+	var cookie *http.Cookie
+	for _, c := range get.Result().Cookies() {
+		if c.Name == csrfCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" {
+		t.Fatal("GET /authorize set no csrf cookie")
+	}
+	if !strings.Contains(get.Body.String(), cookie.Value) {
+		t.Error("login form doesn't carry the csrf token")
+	}
+
+	tests := []struct {
+		name       string
+		cookie     *http.Cookie
+		csrfToken  string
+		wantStatus int
+	}{
+		{name: "token of the cookie", cookie: cookie, csrfToken: cookie.Value, wantStatus: http.StatusSeeOther},
+		{name: "no token", cookie: cookie, wantStatus: http.StatusForbidden},
+		{name: "other token", cookie: cookie, csrfToken: "other", wantStatus: http.StatusForbidden},
+		{name: "no cookie", csrfToken: cookie.Value, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"email": {"user@example.com"}, "password": {"secret"}}
+			for key, values := range params {
+				form[key] = values
+			}
+			if tt.csrfToken != "" {
+				form.Set("csrf_token", tt.csrfToken)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rec := httptest.NewRecorder()
+
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("POST /authorize status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code != http.StatusSeeOther {
+				return
+			}
+			location, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("parse location: %v", err)
+			}
+			if got := location.Query(); got.Get("code") != "code" || got.Get("state") != "xyz" {
+				t.Errorf("redirected to %s, want the code and state", location)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package httpserver
+
+import (
+	auth "auth/internal/services"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// providerMetadata is the OpenID Connect discovery document.
+type providerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document.
+func (s *serverAPI) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// service layer
+	provider, err := s.auth.OpenIDProvider(r.Context())
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCDisabled) {
+			writeError(w, http.StatusNotFound, "openid connect is not enabled")
+			return
+		}
+		s.log.Error("failed to describe openid provider", slog.String("error", err.Error()))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	issuer := strings.TrimSuffix(provider.Issuer, "/")
+	metadata := providerMetadata{
+		Issuer:                            provider.Issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		ScopesSupported:                   provider.Scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{provider.SigningAlgorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified"},
+	}
+	if provider.DeviceAuthorization {
+		metadata.DeviceAuthorizationEndpoint = issuer + "/device_authorization"
+		metadata.GrantTypesSupported = append(metadata.GrantTypesSupported, deviceCodeGrantType)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// UserInfo returns claims of the user the bearer access token was issued
+// to.
+func (s *serverAPI) UserInfo(w http.ResponseWriter, r *http.Request) {
+	const op = "httpserver.UserInfo"
+
+	log := s.log.With(slog.String("op", op))
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "access token is required")
+		return
+	}
+
+	// service layer
+	info, err := s.auth.UserInfo(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrExpiredToken):
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeOAuthError(w, http.StatusUnauthorized, oauthInvalidToken, "access token is invalid or expired")
+		case errors.Is(err, auth.ErrOIDCDisabled):
+			writeError(w, http.StatusNotFound, "openid connect is not enabled")
+		default:
+			log.Error("failed to get user info", slog.String("error", err.Error()))
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, userInfoResponse{
+		Subject:       strconv.FormatInt(info.UserID, 10),
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+	})
+}
+
+// userInfoResponse holds the claims of the UserInfo response, the email
+// claims are left out unless the email scope was granted.
+type userInfoResponse struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// bearerToken returns the token of the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type Auth interface {
+	JWKS(ctx context.Context, appID int) (jwt.JWKS, error)
+	CheckAuthorizationRequest(ctx context.Context, req models.AuthorizationRequest) (models.App, error)
+	Authorize(ctx context.Context, req models.AuthorizationRequest, email string, password string, mfaCode string, clientIP string) (string, error)
+	ExchangeAuthorizationCode(ctx context.Context, exchange models.CodeExchange) (models.TokenPair, error)
+	IssueClientToken(ctx context.Context, appID int, secret string, scopes []string) (models.ClientToken, error)
+	AuthorizeDevice(ctx context.Context, appID int, secret string) (models.DeviceAuthorization, error)
+	PollDevice(ctx context.Context, appID int, secret string, deviceCode string) (models.TokenPair, error)
+	OpenIDProvider(ctx context.Context) (models.OpenIDProvider, error)
+	UserInfo(ctx context.Context, token string) (models.UserInfo, error)
+	IntrospectToken(ctx context.Context, appID int, secret string, token string, tokenTypeHint string) (models.TokenIntrospection, error)
+}
+
+type serverAPI struct {
+	log  *slog.Logger
+	auth Auth
+}
+
+func Register(mux *http.ServeMux, log *slog.Logger, auth Auth) {
+	s := &serverAPI{log: log, auth: auth}
+
+	mux.HandleFunc("/.well-known/jwks.json", s.JWKS)
+	mux.HandleFunc("/authorize", s.Authorize)
+	mux.HandleFunc("/token", s.Token)
+	mux.HandleFunc("/device_authorization", s.DeviceAuthorization)
+	mux.HandleFunc("/.well-known/openid-configuration", s.OpenIDConfiguration)
+	mux.HandleFunc("/userinfo", s.UserInfo)
+	mux.HandleFunc("/introspect", s.Introspect)
+}
+
+// JWKS serves public keys tokens are verified with, optionally only
+// those of the app given by the app_id query parameter.
+func (s *serverAPI) JWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var appID int
+	if raw := r.URL.Query().Get("app_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			writeError(w, http.StatusBadRequest, "app_id must be a positive integer")
+			return
+		}
+		appID = id
+	}
+	// service layer
+	jwks, err := s.auth.JWKS(r.Context(), appID)
+	if err != nil {
+		s.log.Error("failed to list public keys", slog.String("error", err.Error()))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, jwks)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
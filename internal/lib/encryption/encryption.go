@@ -0,0 +1,58 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the size of keys in bytes, AES-256 is used.
+const KeySize = 32
+
+var ErrDecrypt = errors.New("failed to decrypt")
+
+// Cipher encrypts data kept at rest with AES-GCM. The random nonce is
+// prepended to every ciphertext.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func New(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt seals the plaintext. additionalData, e.g. the id of the owner,
+// isn't stored but must be the same to decrypt, so a ciphertext can't be
+// moved to another record.
+func (c *Cipher) Encrypt(plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, sealed := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,56 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	c, err := New(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	other, err := New(bytes.Repeat([]byte{2}, KeySize))
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	plaintext := []byte("secret")
+	ciphertext, err := c.Encrypt(plaintext, []byte("user 1"))
+	if err != nil {
+		t.Fatalf("Encrypt(): %v", err)
+	}
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name           string
+		cipher         *Cipher
+		ciphertext     []byte
+		additionalData []byte
+		wantErr        error
+	}{
+		{name: "same data", cipher: c, ciphertext: ciphertext, additionalData: []byte("user 1")},
+		{name: "other additional data", cipher: c, ciphertext: ciphertext, additionalData: []byte("user 2"), wantErr: ErrDecrypt},
+		{name: "other key", cipher: other, ciphertext: ciphertext, additionalData: []byte("user 1"), wantErr: ErrDecrypt},
+		{name: "tampered", cipher: c, ciphertext: tampered, additionalData: []byte("user 1"), wantErr: ErrDecrypt},
+		{name: "truncated", cipher: c, ciphertext: ciphertext[:4], additionalData: []byte("user 1"), wantErr: ErrDecrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cipher.Decrypt(tt.ciphertext, tt.additionalData)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestNewKeySize(t *testing.T) {
+	if _, err := New(make([]byte, KeySize-1)); err == nil {
+		t.Error("New() accepted a short key")
+	}
+}
@@ -1,8 +1,13 @@
 package jwt
 
 import (
+	"auth/internal/lib/securetoken"
 	"auth/internal/models"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,35 +15,259 @@ import (
 
 var (
 	ErrExpiredToken = errors.New("token was expired")
+	ErrInvalidToken = errors.New("invalid token")
 )
 
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// Claims is the verified payload of a token issued by NewToken.
+type Claims struct {
+	ID        string
+	UID       int64
+	Email     string
+	AppID     int
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Roles and Permissions the user held in the app when the token was
+	// issued
+	Roles       []string
+	Permissions []string
+	// ClientID is set instead of UID and Email in tokens issued to the
+	// app itself by NewClientToken
+	ClientID int
+	// Scopes granted to the app, or the OpenID Connect scopes granted by
+	// the user
+	Scopes []string
+}
+
+// IsClient reports whether the token was issued to an app rather than to
+// a user.
+func (c Claims) IsClient() bool {
+	return c.ClientID != 0
+}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
-	claims["app_id"] = app.ID
+// TokenClaims is the payload of access tokens. The registered claims let
+// generic JWT middleware check them: iss is the configured issuer, aud the
+// app id, sub the user id, or the app id in tokens issued to the app
+// itself.
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	UID   int64  `json:"uid,omitempty"`
+	Email string `json:"email,omitempty"`
+	AppID int    `json:"app_id"`
+	// Roles and Permissions the user held in the app
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// ClientID is set in tokens issued by NewClientToken
+	ClientID int `json:"client_id,omitempty"`
+	// Scope holds the granted scopes separated by spaces
+	Scope string `json:"scope,omitempty"`
+}
+
+// idTokenClaims is the payload of OpenID Connect ID tokens.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	AuthTime      int64  `json:"auth_time"`
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// ParseOptions tighten the checks of ParseToken.
+type ParseOptions struct {
+	// Issuer has to match the iss claim when set
+	Issuer string
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat
+	Leeway time.Duration
+}
+
+// KeyResolver returns the keys to verify a token with, given the kid
+// header of the token and its app_id claim. The token is valid if any of
+// them verifies it.
+type KeyResolver func(kid string, appID int) ([]SigningKey, error)
+
+// NewToken issues a token for the user in the app signed with the key,
+// granting the OpenID Connect scopes if any. The kid header is set unless
+// the key has no id.
+func NewToken(issuer string, user models.User, app models.App, access models.Access, scopes []string, key SigningKey, duration time.Duration) (string, error) {
+	registered, err := registeredClaims(issuer, strconv.FormatInt(user.ID, 10), app, duration)
+	if err != nil {
+		return "", err
+	}
+	return sign(TokenClaims{
+		RegisteredClaims: registered,
+		UID:              user.ID,
+		Email:            user.Email,
+		AppID:            app.ID,
+		Roles:            access.Roles,
+		Permissions:      access.Permissions,
+		Scope:            strings.Join(scopes, " "),
+	}, key)
+}
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+// NewClientToken issues a token whose subject is the app, granting the
+// scopes. It carries no user claims.
+func NewClientToken(issuer string, app models.App, scopes []string, key SigningKey, duration time.Duration) (string, error) {
+	registered, err := registeredClaims(issuer, strconv.Itoa(app.ID), app, duration)
 	if err != nil {
 		return "", err
 	}
-	return tokenString, nil
+	return sign(TokenClaims{
+		RegisteredClaims: registered,
+		AppID:            app.ID,
+		ClientID:         app.ID,
+		Scope:            strings.Join(scopes, " "),
+	}, key)
+}
+
+// NewIDToken issues an OpenID Connect ID token of the user for the app
+// acting as the relying party. The email claims are added if the email
+// scope was granted. ID tokens carry no app_id claim, so ParseToken never
+// accepts them as access tokens.
+func NewIDToken(issuer string, user models.User, app models.App, nonce string, authTime time.Time, scopes []string, key SigningKey, duration time.Duration) (string, error) {
+	now := time.Now()
+	claims := idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Audience:  jwt.ClaimStrings{strconv.Itoa(app.ID)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+		AuthTime: authTime.Unix(),
+		Nonce:    nonce,
+	}
+	for _, scope := range scopes {
+		if scope == models.ScopeEmail {
+			claims.Email = user.Email
+			claims.EmailVerified = &user.EmailVerified
+		}
+	}
+	return sign(claims, key)
+}
+
+// ParseToken verifies signature, expiry and registered claims of the token
+// with the keys returned by resolve and returns the decoded claims. Errors
+// returned by resolve are passed through unchanged.
+func ParseToken(tokenString string, resolve KeyResolver, opts ParseOptions) (Claims, error) {
+	var resolveErr error
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgES256, AlgEdDSA}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(opts.Leeway),
+	}
+	if opts.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(opts.Issuer))
+	}
+
+	// claims are decoded before the key is looked up
+	tokenClaims := &TokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, tokenClaims, func(token *jwt.Token) (interface{}, error) {
+		if tokenClaims.AppID == 0 {
+			return nil, fmt.Errorf("%w: app_id claim is missing", ErrInvalidToken)
+		}
+		kid, _ := token.Header["kid"].(string)
+		keys, err := resolve(kid, tokenClaims.AppID)
+		if err != nil {
+			resolveErr = err
+			return nil, err
+		}
+
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			// the algorithm is bound to the key, never taken from the token
+			if token.Method.Alg() != key.Algorithm {
+				return nil, fmt.Errorf("%w: unexpected signing method %s", ErrInvalidToken, token.Method.Alg())
+			}
+			verificationKey, err := key.verificationKey()
+			if err != nil {
+				return nil, err
+			}
+			set.Keys = append(set.Keys, verificationKey)
+		}
+		return set, nil
+	}, parserOptions...)
+	if resolveErr != nil {
+		return Claims{}, resolveErr
+	}
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return Claims{}, ErrExpiredToken
+		}
+		return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+
+	return tokenClaims.verified()
+}
+
+// verified checks the claims the parser leaves optional and converts them.
+func (c *TokenClaims) verified() (Claims, error) {
+	if c.ID == "" {
+		return Claims{}, fmt.Errorf("%w: jti claim is missing", ErrInvalidToken)
+	}
+	if c.IssuedAt == nil {
+		return Claims{}, fmt.Errorf("%w: iat claim is missing", ErrInvalidToken)
+	}
+	if !slices.Contains(c.Audience, strconv.Itoa(c.AppID)) {
+		return Claims{}, fmt.Errorf("%w: token is not issued for app %d", ErrInvalidToken, c.AppID)
+	}
+
+	claims := Claims{
+		ID:          c.ID,
+		AppID:       c.AppID,
+		IssuedAt:    c.IssuedAt.Time,
+		ExpiresAt:   c.ExpiresAt.Time,
+		Roles:       c.Roles,
+		Permissions: c.Permissions,
+		Scopes:      strings.Fields(c.Scope),
+	}
+	if c.ClientID != 0 {
+		if c.Subject != strconv.Itoa(c.ClientID) {
+			return Claims{}, fmt.Errorf("%w: sub claim doesn't match client_id", ErrInvalidToken)
+		}
+		claims.ClientID = c.ClientID
+		return claims, nil
+	}
+	if c.UID == 0 || c.Subject != strconv.FormatInt(c.UID, 10) {
+		return Claims{}, fmt.Errorf("%w: sub claim doesn't match uid", ErrInvalidToken)
+	}
+	if c.Email == "" {
+		return Claims{}, fmt.Errorf("%w: email claim is missing", ErrInvalidToken)
+	}
+	claims.UID = c.UID
+	claims.Email = c.Email
+	return claims, nil
 }
 
-func verifyToken(tokenString string, app models.App) error {
-	claims := jwt.MapClaims{}
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.Secret), nil
-	})
+// registeredClaims returns the registered claims of a token for the app,
+// valid from now for duration.
+func registeredClaims(issuer string, subject string, app models.App, duration time.Duration) (jwt.RegisteredClaims, error) {
+	jti, err := securetoken.New()
 	if err != nil {
-		return err
+		return jwt.RegisteredClaims{}, err
+	}
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    issuer,
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{strconv.Itoa(app.ID)},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+	}, nil
+}
+
+// sign signs the claims with the key, setting the kid header unless the
+// key has no id.
+func sign(claims jwt.Claims, key SigningKey) (string, error) {
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return "", err
 	}
-	expirationUnixTime := claims["exp"].(int64)
-	if expirationUnixTime < time.Now().Unix() {
-		return ErrExpiredToken
+	token := jwt.NewWithClaims(method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
 	}
-	return nil
+	return token.SignedString(key.Key)
 }
@@ -0,0 +1,228 @@
+package jwt
+
+import (
+	"auth/internal/models"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "https://auth.example.com"
+
+// userClaims returns valid claims of a token of testUser in testApp.
+func userClaims(now time.Time) TokenClaims {
+	return TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    testIssuer,
+			Subject:   strconv.FormatInt(testUser.ID, 10),
+			Audience:  jwt.ClaimStrings{strconv.Itoa(testApp.ID)},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		UID:   testUser.ID,
+		Email: testUser.Email,
+		AppID: testApp.ID,
+		Scope: "openid email",
+	}
+}
+
+func TestParseTokenClaims(t *testing.T) {
+	key, err := GenerateSigningKey(AlgHS256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(): %v", err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		modify  func(c *TokenClaims)
+		opts    ParseOptions
+		wantErr error
+	}{
+		{name: "valid", modify: func(c *TokenClaims) {}, opts: ParseOptions{Issuer: testIssuer}},
+		{
+			name:    "other issuer",
+			modify:  func(c *TokenClaims) { c.Issuer = "https://evil.example.net" },
+			opts:    ParseOptions{Issuer: testIssuer},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:   "issuer not checked",
+			modify: func(c *TokenClaims) { c.Issuer = "https://evil.example.net" },
+		},
+		{
+			name:    "audience of another app",
+			modify:  func(c *TokenClaims) { c.Audience = jwt.ClaimStrings{"8"} },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no audience",
+			modify:  func(c *TokenClaims) { c.Audience = nil },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no app_id",
+			modify:  func(c *TokenClaims) { c.AppID = 0 },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "sub of another user",
+			modify:  func(c *TokenClaims) { c.Subject = "43" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no uid",
+			modify:  func(c *TokenClaims) { c.UID = 0; c.Subject = "0" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no email",
+			modify:  func(c *TokenClaims) { c.Email = "" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no jti",
+			modify:  func(c *TokenClaims) { c.ID = "" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no iat",
+			modify:  func(c *TokenClaims) { c.IssuedAt = nil },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no exp",
+			modify:  func(c *TokenClaims) { c.ExpiresAt = nil },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "issued in the future",
+			modify:  func(c *TokenClaims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute)) },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "not valid yet",
+			modify:  func(c *TokenClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:   "not valid yet within leeway",
+			modify: func(c *TokenClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Second)) },
+			opts:   ParseOptions{Leeway: 30 * time.Second},
+		},
+		{
+			name:    "expired",
+			modify:  func(c *TokenClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) },
+			opts:    ParseOptions{Leeway: 30 * time.Second},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name:   "expired within leeway",
+			modify: func(c *TokenClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second)) },
+			opts:   ParseOptions{Leeway: 30 * time.Second},
+		},
+		{
+			name:    "client_id with sub of a user",
+			modify:  func(c *TokenClaims) { c.ClientID = testApp.ID },
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := userClaims(now)
+			tt.modify(&claims)
+			token, err := sign(claims, key)
+			if err != nil {
+				t.Fatalf("sign(): %v", err)
+			}
+
+			got, err := ParseToken(token, resolveTo(key), tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got.ID != claims.ID || got.UID != testUser.ID || got.Email != testUser.Email || got.AppID != testApp.ID {
+				t.Errorf("ParseToken() = %+v, want the claims of user %d in app %d", got, testUser.ID, testApp.ID)
+			}
+			if want := []string{"openid", "email"}; !reflect.DeepEqual(got.Scopes, want) {
+				t.Errorf("ParseToken() scopes = %v, want %v", got.Scopes, want)
+			}
+		})
+	}
+}
+
+func TestParseClientToken(t *testing.T) {
+	key, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(): %v", err)
+	}
+	token, err := NewClientToken(testIssuer, testApp, []string{"orders:read"}, key, time.Hour)
+	if err != nil {
+		t.Fatalf("NewClientToken(): %v", err)
+	}
+
+	claims, err := ParseToken(token, resolveTo(key), ParseOptions{Issuer: testIssuer})
+	if err != nil {
+		t.Fatalf("ParseToken(): %v", err)
+	}
+	if !claims.IsClient() || claims.ClientID != testApp.ID || claims.UID != 0 || claims.Email != "" {
+		t.Errorf("ParseToken() = %+v, want a client token of app %d", claims, testApp.ID)
+	}
+	if want := []string{"orders:read"}; !reflect.DeepEqual(claims.Scopes, want) {
+		t.Errorf("ParseToken() scopes = %v, want %v", claims.Scopes, want)
+	}
+
+	// sub has to name the client
+	forged := userClaims(time.Now())
+	forged.ClientID = testApp.ID
+	forged.UID, forged.Email = 0, ""
+	forged.Subject = "8"
+	forgedToken, err := sign(forged, key)
+	if err != nil {
+		t.Fatalf("sign(): %v", err)
+	}
+	if _, err := ParseToken(forgedToken, resolveTo(key), ParseOptions{}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseToken() with sub of another client error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestParseTokenRejectsIDToken(t *testing.T) {
+	key, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(): %v", err)
+	}
+	token, err := NewIDToken(testIssuer, testUser, testApp, "nonce", time.Now(), []string{"openid", "email"}, key, time.Hour)
+	if err != nil {
+		t.Fatalf("NewIDToken(): %v", err)
+	}
+	if _, err := ParseToken(token, resolveTo(key), ParseOptions{Issuer: testIssuer}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ParseToken() of id token error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestParseTokenResolveError(t *testing.T) {
+	key, err := GenerateSigningKey(AlgHS256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(): %v", err)
+	}
+	token, err := NewToken(testIssuer, testUser, testApp, models.Access{}, nil, key, time.Hour)
+	if err != nil {
+		t.Fatalf("NewToken(): %v", err)
+	}
+	errUnknownApp := errors.New("unknown app")
+
+	_, err = ParseToken(token, func(kid string, appID int) ([]SigningKey, error) {
+		return nil, errUnknownApp
+	}, ParseOptions{})
+	if err != errUnknownApp {
+		t.Errorf("ParseToken() error = %v, want %v passed through", err, errUnknownApp)
+	}
+}
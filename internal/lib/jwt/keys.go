@@ -0,0 +1,299 @@
+package jwt
+
+import (
+	"auth/internal/lib/securetoken"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	rsaKeyBits    = 2048
+	hmacKeyLength = 32
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// SigningKey is a key tokens are signed and verified with. Key holds the
+// shared secret as []byte for HS256 and the private key otherwise.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Key       crypto.PrivateKey
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// IsAsymmetric reports whether alg verifies tokens with a public key.
+func IsAsymmetric(alg string) bool {
+	return alg == AlgRS256 || alg == AlgES256 || alg == AlgEdDSA
+}
+
+// GenerateSigningKey creates a new private key for the algorithm. The key
+// id is the RFC 7638 thumbprint of its public part, or random for HS256.
+func GenerateSigningKey(alg string) (SigningKey, error) {
+	var key crypto.PrivateKey
+	var err error
+
+	switch alg {
+	case AlgHS256:
+		return generateSecret()
+	case AlgRS256:
+		key, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return SigningKey{}, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	return newSigningKey(alg, key)
+}
+
+// ParsePrivateKey restores a key saved with MarshalPrivateKey.
+func ParsePrivateKey(id string, alg string, der []byte) (SigningKey, error) {
+	if alg == AlgHS256 {
+		if len(der) == 0 {
+			return SigningKey{}, jwt.ErrInvalidKey
+		}
+		return SigningKey{ID: id, Algorithm: alg, Key: der}, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	if got := algorithmOf(key); got != alg {
+		return SigningKey{}, fmt.Errorf("%w: key of %s stored as %s", ErrUnsupportedAlgorithm, got, alg)
+	}
+	return SigningKey{ID: id, Algorithm: alg, Key: key}, nil
+}
+
+// LoadSigningKeyFile reads a PEM encoded private key from disk. The
+// algorithm is derived from the key type.
+func LoadSigningKeyFile(path string) (SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var key crypto.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	alg := algorithmOf(key)
+	if alg == "" {
+		return SigningKey{}, fmt.Errorf("%s: %w", path, ErrUnsupportedAlgorithm)
+	}
+	return newSigningKey(alg, key)
+}
+
+// MarshalPrivateKey encodes the private key as PKCS #8 DER. HS256 secrets
+// are returned as is.
+func (k SigningKey) MarshalPrivateKey() ([]byte, error) {
+	if secret, ok := k.Key.([]byte); ok {
+		return secret, nil
+	}
+	return x509.MarshalPKCS8PrivateKey(k.Key)
+}
+
+// JWK returns the public part of the key. Symmetric keys have no public
+// part and ok is false for them.
+func (k SigningKey) JWK() (jwk JWK, ok bool) {
+	signer, isSigner := k.Key.(crypto.Signer)
+	if !isSigner || !IsAsymmetric(k.Algorithm) {
+		return JWK{}, false
+	}
+
+	jwk = publicJWK(signer.Public())
+	jwk.Use = "sig"
+	jwk.Kid = k.ID
+	jwk.Alg = k.Algorithm
+	return jwk, jwk.Kty != ""
+}
+
+// verificationKey returns the key jwt.Parse expects for the algorithm.
+func (k SigningKey) verificationKey() (interface{}, error) {
+	if k.Algorithm == AlgHS256 {
+		secret, ok := k.Key.([]byte)
+		if !ok {
+			return nil, jwt.ErrInvalidKeyType
+		}
+		return secret, nil
+	}
+	signer, ok := k.Key.(crypto.Signer)
+	if !ok {
+		return nil, jwt.ErrInvalidKeyType
+	}
+	return signer.Public(), nil
+}
+
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case AlgHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgES256:
+		return jwt.SigningMethodES256, nil
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+}
+
+func generateSecret() (SigningKey, error) {
+	secret := make([]byte, hmacKeyLength)
+	if _, err := rand.Read(secret); err != nil {
+		return SigningKey{}, err
+	}
+	kid, err := securetoken.New()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{ID: kid, Algorithm: AlgHS256, Key: secret}, nil
+}
+
+func newSigningKey(alg string, key crypto.PrivateKey) (SigningKey, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return SigningKey{}, ErrUnsupportedAlgorithm
+	}
+	kid, err := thumbprint(publicJWK(signer.Public()))
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{ID: kid, Algorithm: alg, Key: key}, nil
+}
+
+func algorithmOf(key crypto.PrivateKey) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return AlgRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve == elliptic.P256() {
+			return AlgES256
+		}
+	case ed25519.PrivateKey:
+		return AlgEdDSA
+	}
+	return ""
+}
+
+func publicJWK(pub crypto.PublicKey) JWK {
+	enc := base64.RawURLEncoding
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   enc.EncodeToString(k.N.Bytes()),
+			E:   enc.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   enc.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   enc.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   enc.EncodeToString(k),
+		}
+	}
+	return JWK{}
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a public key. Only the
+// required members take part in it, in lexicographic order.
+func thumbprint(jwk JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", ErrUnsupportedAlgorithm
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
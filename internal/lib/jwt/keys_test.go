@@ -0,0 +1,153 @@
+package jwt
+
+import (
+	"auth/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	testUser = models.User{ID: 42, Email: "user@example.com"}
+	testApp  = models.App{ID: 7}
+)
+
+// resolveTo returns a resolver that always returns the keys.
+func resolveTo(keys ...SigningKey) KeyResolver {
+	return func(kid string, appID int) ([]SigningKey, error) {
+		return keys, nil
+	}
+}
+
+func TestSigningKeys(t *testing.T) {
+	tests := []struct {
+		alg     string
+		wantKty string
+	}{
+		{alg: AlgHS256},
+		{alg: AlgRS256, wantKty: "RSA"},
+		{alg: AlgES256, wantKty: "EC"},
+		{alg: AlgEdDSA, wantKty: "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			key, err := GenerateSigningKey(tt.alg)
+			if err != nil {
+				t.Fatalf("GenerateSigningKey(): %v", err)
+			}
+			if key.ID == "" {
+				t.Error("GenerateSigningKey() returned a key without id")
+			}
+
+			der, err := key.MarshalPrivateKey()
+			if err != nil {
+				t.Fatalf("MarshalPrivateKey(): %v", err)
+			}
+			restored, err := ParsePrivateKey(key.ID, tt.alg, der)
+			if err != nil {
+				t.Fatalf("ParsePrivateKey(): %v", err)
+			}
+
+			// tokens signed with the key verify with the restored one
+			token, err := NewToken("", testUser, testApp, models.Access{}, nil, key, time.Hour)
+			if err != nil {
+				t.Fatalf("NewToken(): %v", err)
+			}
+			claims, err := ParseToken(token, resolveTo(restored), ParseOptions{})
+			if err != nil {
+				t.Fatalf("ParseToken(): %v", err)
+			}
+			if claims.UID != testUser.ID {
+				t.Errorf("ParseToken() uid = %d, want %d", claims.UID, testUser.ID)
+			}
+
+			jwk, ok := key.JWK()
+			if ok != (tt.wantKty != "") {
+				t.Fatalf("JWK() ok = %v, want %v", ok, tt.wantKty != "")
+			}
+			if !ok {
+				return
+			}
+			if jwk.Kty != tt.wantKty || jwk.Kid != key.ID || jwk.Alg != tt.alg || jwk.Use != "sig" {
+				t.Errorf("JWK() = %+v, want kty %s, kid %s, alg %s", jwk, tt.wantKty, key.ID, tt.alg)
+			}
+		})
+	}
+}
+
+func TestGenerateSigningKeyUnsupported(t *testing.T) {
+	if _, err := GenerateSigningKey("none"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("GenerateSigningKey() error = %v, want %v", err, ErrUnsupportedAlgorithm)
+	}
+}
+
+func TestParsePrivateKeyAlgorithmMismatch(t *testing.T) {
+	key, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(): %v", err)
+	}
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey(): %v", err)
+	}
+	if _, err := ParsePrivateKey(key.ID, AlgRS256, der); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("ParsePrivateKey() error = %v, want %v", err, ErrUnsupportedAlgorithm)
+	}
+}
+
+func TestParseTokenAlgorithmBoundToKey(t *testing.T) {
+	keys := make(map[string]SigningKey)
+	for _, alg := range []string{AlgHS256, AlgRS256, AlgES256, AlgEdDSA} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatalf("GenerateSigningKey(%s): %v", alg, err)
+		}
+		keys[alg] = key
+	}
+	// an HS256 secret equal to the encoded public key must not verify
+	// tokens of the asymmetric key
+	jwk, _ := keys[AlgRS256].JWK()
+	confused := SigningKey{ID: keys[AlgRS256].ID, Algorithm: AlgHS256, Key: []byte(jwk.N)}
+
+	tests := []struct {
+		name     string
+		signWith SigningKey
+		resolved SigningKey
+	}{
+		{name: "HS256 token, RS256 key", signWith: confused, resolved: keys[AlgRS256]},
+		{name: "RS256 token, ES256 key", signWith: keys[AlgRS256], resolved: keys[AlgES256]},
+		{name: "EdDSA token, HS256 key", signWith: keys[AlgEdDSA], resolved: keys[AlgHS256]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := NewToken("", testUser, testApp, models.Access{}, nil, tt.signWith, time.Hour)
+			if err != nil {
+				t.Fatalf("NewToken(): %v", err)
+			}
+			if _, err := ParseToken(token, resolveTo(tt.resolved), ParseOptions{}); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("ParseToken() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// the example of RFC 7638, section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECP" +
+			"ebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY" +
+			"368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0f" +
+			"M4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: AlgRS256,
+		Kid: "2011-04-29",
+	}
+	got, err := thumbprint(jwk)
+	if err != nil {
+		t.Fatalf("thumbprint(): %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint() = %s, want %s", got, want)
+	}
+}
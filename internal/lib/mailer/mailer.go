@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Writer "sends" mail by writing it to w. It is meant for local runs and
+// tests, where messages are read from stdout or a file.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+// NewWriter returns a mailer writing messages to w.
+func NewWriter(w io.Writer, from string) *Writer {
+	return &Writer{w: w, from: from}
+}
+
+// NewFile returns a mailer appending messages to the file at path.
+func NewFile(path string, from string) (*Writer, error) {
+	const op = "mailer.NewFile"
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return NewWriter(f, from), nil
+}
+
+func (m *Writer) Send(_ context.Context, to string, subject string, body string) error {
+	const op = "mailer.Writer.Send"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "Date: %s\nFrom: %s\nTo: %s\nSubject: %s\n\n%s\n.\n",
+		time.Now().Format(time.RFC1123Z), m.from, to, subject, body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// maxLength is the longest password bcrypt takes into account, in bytes.
+const maxLength = 72
+
+var ErrWeakPassword = errors.New("password doesn't meet the policy")
+
+// Policy lists requirements new passwords must meet.
+type Policy struct {
+	MinLength int
+}
+
+// Check returns ErrWeakPassword describing the first requirement the
+// password fails.
+func (p Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: shorter than %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > maxLength {
+		return fmt.Errorf("%w: longer than %d bytes", ErrWeakPassword, maxLength)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{MinLength: 8}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{name: "long enough", password: "12345678"},
+		{name: "too short", password: "1234567", wantErr: ErrWeakPassword},
+		{name: "counted in characters", password: "пароль12"},
+		{name: "longest bcrypt takes", password: strings.Repeat("a", 72)},
+		{name: "longer than bcrypt takes", password: strings.Repeat("a", 73), wantErr: ErrWeakPassword},
+		{name: "multibyte over the limit", password: strings.Repeat("я", 37), wantErr: ErrWeakPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := policy.Check(tt.password); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
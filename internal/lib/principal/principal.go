@@ -0,0 +1,21 @@
+package principal
+
+import (
+	"auth/internal/lib/jwt"
+	"context"
+)
+
+type contextKey struct{}
+
+// WithClaims returns a copy of ctx carrying claims of the access token the
+// request was authenticated with.
+func WithClaims(ctx context.Context, claims jwt.Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns claims stored by WithClaims. ok is false for
+// requests made without an access token.
+func FromContext(ctx context.Context) (claims jwt.Claims, ok bool) {
+	claims, ok = ctx.Value(contextKey{}).(jwt.Claims)
+	return claims, ok
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled are dropped.
+const sweepInterval = time.Minute
+
+// Limit allows Rate events per second on average and bursts of up to
+// Burst events. A zero Rate or Burst means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a token bucket per key in memory. It is safe for
+// concurrent use.
+type Limiter struct {
+	limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(limit Limit) *Limiter {
+	return &Limiter{limit: limit, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty
+// it returns false and how long until a token is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	return AllowAll(now, Key{Limiter: l, Key: key})
+}
+
+// Key names the bucket of Key in Limiter.
+type Key struct {
+	Limiter *Limiter
+	Key     string
+}
+
+// AllowAll takes a token from the bucket of every key only if none of them
+// is empty, otherwise it takes nothing and returns the longest wait. The
+// limiters are locked in the order of the keys, callers have to keep that
+// order the same and name each limiter once.
+func AllowAll(now time.Time, keys ...Key) (bool, time.Duration) {
+	buckets := make([]*bucket, 0, len(keys))
+	var wait time.Duration
+	for _, k := range keys {
+		l := k.Limiter
+		if l.limit.unlimited() {
+			continue
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		b := l.bucket(k.Key, now)
+		if b.tokens < 1 {
+			if w := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+			continue
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// bucket returns the refilled bucket of the key. l.mu must be held.
+func (l *Limiter) bucket(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+	return b
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * l.limit.Rate
+		if burst := float64(l.limit.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+		b.updated = now
+	}
+}
+
+// sweep drops full buckets, a new bucket is the same as a full one.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	l := New(Limit{Rate: 1, Burst: 2})
+
+	// the calls run in order against the same limiter
+	calls := []struct {
+		name     string
+		key      string
+		at       time.Duration
+		want     bool
+		wantWait time.Duration
+	}{
+		{name: "burst", key: "a", want: true},
+		{name: "burst exhausted", key: "a", want: true},
+		{name: "empty bucket", key: "a", want: false, wantWait: time.Second},
+		{name: "other key", key: "b", want: true},
+		{name: "partly refilled", key: "a", at: 500 * time.Millisecond, want: false, wantWait: 500 * time.Millisecond},
+		{name: "refilled", key: "a", at: time.Second, want: true},
+		{name: "refill capped at burst", key: "b", at: time.Hour, want: true},
+		{name: "burst after cap", key: "b", at: time.Hour, want: true},
+		{name: "empty after cap", key: "b", at: time.Hour, want: false, wantWait: time.Second},
+	}
+	for _, c := range calls {
+		ok, wait := l.Allow(c.key, start.Add(c.at))
+		if ok != c.want || wait != c.wantWait {
+			t.Errorf("%s: Allow() = %v, %s, want %v, %s", c.name, ok, wait, c.want, c.wantWait)
+		}
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+	}{
+		{name: "zero limit", limit: Limit{}},
+		{name: "zero rate", limit: Limit{Burst: 1}},
+		{name: "zero burst", limit: Limit{Rate: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.limit)
+			now := time.Now()
+			for i := 0; i < 100; i++ {
+				if ok, _ := l.Allow("key", now); !ok {
+					t.Fatalf("Allow() = false after %d calls", i)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowAllTakesNothingOnReject(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	perIP := New(Limit{Rate: 1, Burst: 1})
+	perApp := New(Limit{Rate: 1, Burst: 3})
+
+	// the address is out of tokens, the app isn't
+	if ok, _ := perIP.Allow("ip", now); !ok {
+		t.Fatal("Allow() = false for a full bucket")
+	}
+	for i := 0; i < 5; i++ {
+		if ok, _ := AllowAll(now, Key{Limiter: perApp, Key: "app"}, Key{Limiter: perIP, Key: "ip"}); ok {
+			t.Fatal("AllowAll() = true with an empty bucket")
+		}
+	}
+
+	// the rejected calls took no tokens of the app
+	for i := 0; i < 3; i++ {
+		if ok, _ := perApp.Allow("app", now); !ok {
+			t.Fatalf("Allow() of the app = false after %d calls", i)
+		}
+	}
+}
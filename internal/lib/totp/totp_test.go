@@ -0,0 +1,74 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors of RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// RFC 6238, appendix B, cut to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		if got := Code(rfcSecret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", code: Code(rfcSecret, current), wantStep: current, wantOK: true},
+		{name: "previous step", code: Code(rfcSecret, current-1), wantStep: current - 1, wantOK: true},
+		{name: "next step", code: Code(rfcSecret, current+1), wantStep: current + 1, wantOK: true},
+		{name: "two steps ago", code: Code(rfcSecret, current-2)},
+		{name: "two steps ahead", code: Code(rfcSecret, current+2)},
+		{name: "wrong code", code: "000000"},
+		{name: "empty code", code: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("auth", "user@example.com", rfcSecret)
+
+	for _, want := range []string{
+		"otpauth://totp/auth:user@example.com?",
+		"secret=" + EncodeSecret(rfcSecret),
+		"issuer=auth",
+		"digits=6",
+		"period=30",
+	} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("URI() = %s, want it to contain %s", uri, want)
+		}
+	}
+}
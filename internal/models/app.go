@@ -1,7 +1,70 @@
 package models
 
+import "time"
+
 type App struct {
-	ID     string
-	Name   string
-	Secret string
+	ID   int
+	Name string
+	// Secret is the plaintext secret an app is created with, it is only
+	// stored hashed
+	Secret     string
+	SecretHash []byte
+	// RequireEmailVerification refuses login to users with unverified email
+	RequireEmailVerification bool
+	// AllowSelfRegistration lets any user join the app on first login,
+	// otherwise users have to be added or invited
+	AllowSelfRegistration bool
+	// PreviousSecretHash is the secret replaced by the last rotation, it
+	// is still accepted until PreviousSecretExpiresAt
+	PreviousSecretHash      []byte
+	PreviousSecretExpiresAt time.Time
+	// WebAuthn is the relying party passkeys of the app are bound to
+	WebAuthn WebAuthnConfig
+	// RedirectURIs are the URIs OAuth authorization responses may be sent
+	// to, compared exactly
+	RedirectURIs []string
+	// Scopes the app may be granted by the client_credentials grant
+	Scopes []string
+}
+
+// WebAuthnConfig describes the relying party of an app. Passkeys are
+// disabled while RPID is empty.
+type WebAuthnConfig struct {
+	// RPID is the domain passkeys are registered for
+	RPID string
+	// Origins the app is served from, e.g. https://login.example.com
+	Origins []string
+}
+
+// AllowsRedirectURI reports whether uri is registered for the app.
+func (a App) AllowsRedirectURI(uri string) bool {
+	for _, registered := range a.RedirectURIs {
+		if uri == registered {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretHashes returns the hashed secrets the app currently accepts, the
+// current one first.
+func (a App) SecretHashes(now time.Time) [][]byte {
+	var hashes [][]byte
+	if a.SecretHash != nil {
+		hashes = append(hashes, a.SecretHash)
+	}
+	if a.PreviousSecretHash != nil && now.Before(a.PreviousSecretExpiresAt) {
+		hashes = append(hashes, a.PreviousSecretHash)
+	}
+	return hashes
+}
+
+// AppUpdate lists changes to an app, nil fields are left as is.
+type AppUpdate struct {
+	Name                     *string
+	RequireEmailVerification *bool
+	AllowSelfRegistration    *bool
+	WebAuthn                 *WebAuthnConfig
+	RedirectURIs             *[]string
+	Scopes                   *[]string
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginFailures counts failed logins of a user or from an address.
+type LoginFailures struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	// BlockedUntil is when the next login attempt is allowed
+	BlockedUntil time.Time
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AppInvitation lets the owner of the email join the app once.
+type AppInvitation struct {
+	ID        int64
+	TokenHash []byte
+	AppID     int
+	Email     string
+	ExpiresAt time.Time
+	Used      bool
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// TOTP is the authenticator app secret of a user. Secret is encrypted.
+type TOTP struct {
+	UserID       int64
+	Secret       []byte
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// MFAChallenge is issued after the password of a user with MFA enabled is
+// checked and is completed with the second factor.
+type MFAChallenge struct {
+	ID        int64
+	TokenHash []byte
+	UserID    int64
+	AppID     int
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// RecoveryCode is a single-use code that lets a user back in without the
+// password and authenticator app.
+type RecoveryCode struct {
+	ID       int64
+	UserID   int64
+	CodeHash []byte
+}
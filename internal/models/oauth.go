@@ -0,0 +1,106 @@
+package models
+
+import "time"
+
+// PKCEMethodS256 is the only code challenge method accepted, the plain
+// method would expose the verifier in the authorization request.
+const PKCEMethodS256 = "S256"
+
+// OpenID Connect scopes. Users have no profile claims, so the profile
+// scope isn't supported.
+const (
+	ScopeOpenID = "openid"
+	ScopeEmail  = "email"
+)
+
+// UserInfo are the claims about a user an access token grants.
+type UserInfo struct {
+	UserID int64
+	// Email and EmailVerified are set when the email scope was granted
+	Email         string
+	EmailVerified *bool
+}
+
+// OpenIDProvider describes the service as an OpenID Connect provider.
+type OpenIDProvider struct {
+	Issuer           string
+	SigningAlgorithm string
+	Scopes           []string
+	// DeviceAuthorization is set when the device grant is enabled
+	DeviceAuthorization bool
+}
+
+// AuthorizationRequest is an authorization_code grant requested by an
+// OAuth client, the app.
+type AuthorizationRequest struct {
+	AppID       int
+	RedirectURI string
+	// CodeChallenge is the PKCE challenge derived from the verifier the
+	// client presents when exchanging the code
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Scopes are the requested OpenID Connect scopes, others are ignored
+	Scopes []string
+	// Nonce is passed through to the ID token
+	Nonce string
+}
+
+// AuthorizationCode is issued to the client after the user logged in and
+// is exchanged for tokens once.
+type AuthorizationCode struct {
+	ID            int64
+	CodeHash      []byte
+	AppID         int
+	UserID        int64
+	RedirectURI   string
+	CodeChallenge string
+	FamilyID      string
+	ExpiresAt     time.Time
+	Used          bool
+	Scopes        []string
+	Nonce         string
+	// AuthTime is when the user logged in
+	AuthTime time.Time
+}
+
+// CodeExchange is a token request of the authorization_code grant.
+type CodeExchange struct {
+	AppID int
+	// ClientSecret is optional, the code is bound to the client by PKCE
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+}
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeUsed     = "used"
+)
+
+// DeviceCode is a pending device authorization grant, the device polls
+// with the device code while the user approves the user code.
+type DeviceCode struct {
+	ID             int64
+	DeviceCodeHash []byte
+	UserCodeHash   []byte
+	AppID          int
+	UserID         int64
+	Status         string
+	PollInterval   time.Duration
+	LastPolledAt   time.Time
+	ExpiresAt      time.Time
+}
+
+// DeviceAuthorization is returned to the device to start the grant.
+type DeviceAuthorization struct {
+	DeviceCode string
+	// UserCode is typed in by the user at VerificationURI
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresAt               time.Time
+	PollInterval            time.Duration
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential of a user, bound to the relying party
+// of the apps it was registered with.
+type Passkey struct {
+	ID              int64
+	UserID          int64
+	RPID            string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	// UserHandle is the random id of the user the authenticator knows
+	UserHandle []byte
+	// SignCount is the last signature counter reported by the
+	// authenticator, a counter that doesn't grow hints at a cloned key
+	SignCount      uint32
+	Transports     []string
+	BackupEligible bool
+	BackupState    bool
+	CreatedAt      time.Time
+	LastUsedAt     time.Time
+}
+
+// WebAuthn ceremonies.
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+// WebAuthnSession keeps the state of a ceremony between its begin and
+// finish calls.
+type WebAuthnSession struct {
+	ID        int64
+	TokenHash []byte
+	// UserID is 0 for a login that doesn't name the user
+	UserID    int64
+	AppID     int
+	Ceremony  string
+	Data      []byte
+	ExpiresAt time.Time
+	Used      bool
+}
@@ -0,0 +1,23 @@
+package models
+
+// Role is a named set of permissions.
+type Role struct {
+	ID          int64
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// UserRole is a role granted to a user in an app, or in every app if
+// AppID is zero.
+type UserRole struct {
+	UserID int64
+	Role   Role
+	AppID  int
+}
+
+// Access lists roles and permissions a user holds in an app.
+type Access struct {
+	Roles       []string
+	Permissions []string
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Signing key lifecycle: a pending key is published ahead of use, the
+// active key signs new tokens, a retiring key only verifies tokens issued
+// before rotation until they expire, a revoked key verifies nothing.
+const (
+	SigningKeyPending  = "pending"
+	SigningKeyActive   = "active"
+	SigningKeyRetiring = "retiring"
+	SigningKeyRevoked  = "revoked"
+)
+
+type SigningKey struct {
+	ID          string
+	AppID       int
+	Algorithm   string
+	PrivateKey  []byte
+	Status      string
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+	// ExpiresAt is when a retiring key gets revoked
+	ExpiresAt time.Time
+}
@@ -0,0 +1,99 @@
+package models
+
+import "time"
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// ExpiresAt is when the access token expires
+	ExpiresAt time.Time
+	// IDToken is issued beside the tokens when the openid scope was
+	// granted
+	IDToken string
+}
+
+// Token types of RFC 7662 introspection.
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// TokenIntrospection is what an app learns about a token presented to it,
+// only Active is set for tokens that are not.
+type TokenIntrospection struct {
+	Active    bool
+	TokenType string
+	// Subject is the user, or the app for tokens issued to the app itself
+	Subject  string
+	ClientID int
+	Scopes   []string
+	// IssuedAt is zero for refresh tokens
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// ClientToken is an access token issued to an app by the
+// client_credentials grant.
+type ClientToken struct {
+	AccessToken string
+	Scopes      []string
+	ExpiresAt   time.Time
+}
+
+// LoginResult holds the tokens of a login or, when the user has MFA
+// enabled, the token of the challenge to complete with the second factor.
+type LoginResult struct {
+	Tokens   TokenPair
+	MFAToken string
+}
+
+// VerificationToken confirms that the user owns Email.
+type VerificationToken struct {
+	ID        int64
+	TokenHash []byte
+	UserID    int64
+	Email     string
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// PasswordResetToken allows to set a new password once.
+type PasswordResetToken struct {
+	ID        int64
+	TokenHash []byte
+	UserID    int64
+	ExpiresAt time.Time
+	Used      bool
+}
+
+type RefreshToken struct {
+	ID        int64
+	TokenHash []byte
+	FamilyID  string
+	UserID    int64
+	AppID     int
+	ExpiresAt time.Time
+	Rotated   bool
+	Revoked   bool
+	// Scopes granted by the authorization code the family was issued for
+	Scopes []string
+}
+
+// Passwordless login methods.
+const (
+	PasswordlessLink = "link"
+	PasswordlessCode = "code"
+)
+
+// PasswordlessChallenge is a login link or code mailed to the user.
+type PasswordlessChallenge struct {
+	ID         int64
+	UserID     int64
+	AppID      int
+	Method     string
+	SecretHash []byte
+	ExpiresAt  time.Time
+	// Attempts counts wrong codes entered for the challenge
+	Attempts int
+	Used     bool
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ListApps returns all registered apps. Secrets are not included. Listing
+// requires the apps:manage permission in every app, the rest of app
+// management requires it in the app concerned.
+func (a *Auth) ListApps(ctx context.Context) ([]models.App, error) {
+	const op = "auth.ListApps"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if err := a.authorize(ctx, PermManageApps, 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("listing apps")
+
+	apps, err := a.appProvider.Apps(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	for i := range apps {
+		apps[i] = withoutSecrets(apps[i])
+	}
+	return apps, nil
+}
+
+// GetApp returns the app without its secrets.
+func (a *Auth) GetApp(ctx context.Context, appID int) (models.App, error) {
+	const op = "auth.GetApp"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	if err := a.authorize(ctx, PermManageApps, appID); err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("getting app")
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return withoutSecrets(app), nil
+}
+
+// UpdateApp applies the update to the app and returns the result.
+func (a *Auth) UpdateApp(ctx context.Context, appID int, update models.AppUpdate) (models.App, error) {
+	const op = "auth.UpdateApp"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	if err := a.authorize(ctx, PermManageApps, appID); err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("updating app")
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if update.Name != nil {
+		app.Name = *update.Name
+	}
+	if update.RequireEmailVerification != nil {
+		app.RequireEmailVerification = *update.RequireEmailVerification
+	}
+	if update.AllowSelfRegistration != nil {
+		app.AllowSelfRegistration = *update.AllowSelfRegistration
+	}
+	if update.WebAuthn != nil {
+		app.WebAuthn = *update.WebAuthn
+	}
+	if update.RedirectURIs != nil {
+		if err := validateRedirectURIs(*update.RedirectURIs); err != nil {
+			return models.App{}, fmt.Errorf("%s: %w", op, err)
+		}
+		app.RedirectURIs = *update.RedirectURIs
+	}
+	if update.Scopes != nil {
+		if err := validateScopes(*update.Scopes); err != nil {
+			return models.App{}, fmt.Errorf("%s: %w", op, err)
+		}
+		app.Scopes = *update.Scopes
+	}
+
+	if err := a.appProvider.UpdateApp(ctx, app); err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("app updated")
+	return withoutSecrets(app), nil
+}
+
+// DeleteApp deletes the app. Its signing keys are dropped, so tokens
+// issued for the app stop validating at once.
+func (a *Auth) DeleteApp(ctx context.Context, appID int) error {
+	const op = "auth.DeleteApp"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	if err := a.authorize(ctx, PermManageApps, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("deleting app")
+
+	if err := a.appProvider.DeleteApp(ctx, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("app deleted")
+	return nil
+}
+
+// RotateAppSecret generates a new secret for the app and returns it. The
+// replaced secret is accepted for gracePeriod, or for the configured
+// period if gracePeriod is nil. Only the last replaced secret is kept, so
+// rotating again ends the grace period of the one before.
+//
+// Secrets authenticate the app as an OAuth client, they don't sign or
+// verify tokens: those use the signing keys of the app, so tokens issued
+// before a rotation stay valid whatever the grace period.
+func (a *Auth) RotateAppSecret(ctx context.Context, appID int, gracePeriod *time.Duration) (string, error) {
+	const op = "auth.RotateAppSecret"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	if err := a.authorize(ctx, PermManageApps, appID); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	grace := a.appSecretGracePeriod
+	if gracePeriod != nil {
+		grace = *gracePeriod
+	}
+	log.Info("rotating app secret", slog.Duration("grace_period", grace))
+
+	secret, err := securetoken.New()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	secretHash, err := hashAppSecret(secret)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.appProvider.RotateAppSecret(ctx, appID, secretHash, time.Now().Add(grace)); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("app secret rotated")
+	return secret, nil
+}
+
+func withoutSecrets(app models.App) models.App {
+	app.Secret = ""
+	app.SecretHash = nil
+	app.PreviousSecretHash = nil
+	return app
+}
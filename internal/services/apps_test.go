@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAppManagementRequiresPermission(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	env.registerUser(t, "user@example.com")
+	ctx := env.userContext(t, "user@example.com", app.ID)
+	name := "renamed"
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{name: "create", call: func(ctx context.Context) error {
+			_, err := env.auth.CreateApp(ctx, models.App{Name: "new", Secret: testAppSecret})
+			return err
+		}},
+		{name: "list", call: func(ctx context.Context) error {
+			_, err := env.auth.ListApps(ctx)
+			return err
+		}},
+		{name: "get", call: func(ctx context.Context) error {
+			_, err := env.auth.GetApp(ctx, app.ID)
+			return err
+		}},
+		{name: "update", call: func(ctx context.Context) error {
+			_, err := env.auth.UpdateApp(ctx, app.ID, models.AppUpdate{Name: &name})
+			return err
+		}},
+		{name: "delete", call: func(ctx context.Context) error {
+			return env.auth.DeleteApp(ctx, app.ID)
+		}},
+		{name: "rotate secret", call: func(ctx context.Context) error {
+			_, err := env.auth.RotateAppSecret(ctx, app.ID, nil)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(ctx); !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("error = %v, want %v", err, ErrPermissionDenied)
+			}
+			if err := tt.call(context.Background()); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("error without access token = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestCreateApp(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	ctx := env.adminContext(t, app.ID)
+
+	tests := []struct {
+		name    string
+		app     models.App
+		wantErr error
+	}{
+		{name: "new app", app: models.App{Name: "new", Secret: "new-secret"}},
+		{name: "name taken", app: models.App{Name: app.Name, Secret: "new-secret"}, wantErr: storage.ErrAppExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := env.auth.CreateApp(ctx, tt.app)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateApp() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			// the secret is only stored hashed
+			created, err := env.storage.App(context.Background(), int(id))
+			if err != nil {
+				t.Fatalf("app: %v", err)
+			}
+			if created.Secret != "" {
+				t.Error("secret is stored in plaintext")
+			}
+			if _, err := env.auth.clientApp(context.Background(), env.auth.log, int(id), tt.app.Secret); err != nil {
+				t.Errorf("secret of the created app is rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateApp(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	ctx := env.adminContext(t, app.ID)
+	name := "renamed"
+	closed := false
+
+	updated, err := env.auth.UpdateApp(ctx, app.ID, models.AppUpdate{Name: &name, AllowSelfRegistration: &closed})
+	if err != nil {
+		t.Fatalf("UpdateApp(): %v", err)
+	}
+	got, err := env.auth.GetApp(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("GetApp(): %v", err)
+	}
+
+	for _, a := range []models.App{updated, got} {
+		if a.Name != name || a.AllowSelfRegistration {
+			t.Errorf("app = %+v, want renamed app closed to self registration", a)
+		}
+		if a.SecretHash != nil || a.PreviousSecretHash != nil {
+			t.Error("secret hashes are returned")
+		}
+	}
+}
+
+func TestDeleteApp(t *testing.T) {
+	fileKey, err := jwt.GenerateSigningKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(): %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+	}{
+		{name: "stored signing keys"},
+		{
+			// the file key outlives the app
+			name:      "key loaded from disk",
+			configure: func(cfg *Config) { cfg.SigningKey = &fileKey },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			admin := env.openApp(t)
+			ctx := env.adminContext(t, admin.ID)
+			usr := env.registerUser(t, "user@example.com")
+			tokens := env.login(t, usr.Email, app.ID)
+
+			if err := env.auth.DeleteApp(ctx, app.ID); err != nil {
+				t.Fatalf("DeleteApp(): %v", err)
+			}
+			if _, err := env.auth.GetApp(ctx, app.ID); !errors.Is(err, storage.ErrAppNotFound) {
+				t.Errorf("GetApp() of deleted app error = %v, want %v", err, storage.ErrAppNotFound)
+			}
+			if _, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("ValidateToken() of token of deleted app error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestDeleteAppPendingLogins(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		// start saves a pending login in the app and returns the lookup
+		// of it
+		start func(t *testing.T, env *testEnv, usr models.User, appID int) func() error
+	}{
+		{
+			name: "device code",
+			start: func(t *testing.T, env *testEnv, usr models.User, appID int) func() error {
+				hash := []byte("device-code")
+				_, err := env.storage.SaveDeviceCode(context.Background(), models.DeviceCode{
+					DeviceCodeHash: hash, UserCodeHash: []byte("user-code"), AppID: appID,
+					Status: models.DeviceCodePending, PollInterval: time.Second, ExpiresAt: expiresAt,
+				})
+				if err != nil {
+					t.Fatalf("save device code: %v", err)
+				}
+				return func() error {
+					_, err := env.storage.DeviceCode(context.Background(), hash)
+					return err
+				}
+			},
+		},
+		{
+			name: "passwordless challenge",
+			start: func(t *testing.T, env *testEnv, usr models.User, appID int) func() error {
+				hash := []byte("passwordless")
+				_, err := env.storage.SavePasswordlessChallenge(context.Background(), models.PasswordlessChallenge{
+					UserID: usr.ID, AppID: appID, Method: models.PasswordlessLink, SecretHash: hash, ExpiresAt: expiresAt,
+				})
+				if err != nil {
+					t.Fatalf("save passwordless challenge: %v", err)
+				}
+				return func() error {
+					_, err := env.storage.PasswordlessChallengeBySecret(context.Background(), hash)
+					return err
+				}
+			},
+		},
+		{
+			name: "mfa challenge",
+			start: func(t *testing.T, env *testEnv, usr models.User, appID int) func() error {
+				hash := []byte("mfa")
+				_, err := env.storage.SaveMFAChallenge(context.Background(), models.MFAChallenge{
+					TokenHash: hash, UserID: usr.ID, AppID: appID, ExpiresAt: expiresAt,
+				})
+				if err != nil {
+					t.Fatalf("save mfa challenge: %v", err)
+				}
+				return func() error {
+					_, err := env.storage.MFAChallenge(context.Background(), hash)
+					return err
+				}
+			},
+		},
+		{
+			name: "webauthn session",
+			start: func(t *testing.T, env *testEnv, usr models.User, appID int) func() error {
+				hash := []byte("webauthn")
+				_, err := env.storage.SaveWebAuthnSession(context.Background(), models.WebAuthnSession{
+					TokenHash: hash, AppID: appID, Ceremony: models.CeremonyLogin, Data: []byte("{}"), ExpiresAt: expiresAt,
+				})
+				if err != nil {
+					t.Fatalf("save webauthn session: %v", err)
+				}
+				return func() error {
+					_, err := env.storage.WebAuthnSession(context.Background(), hash)
+					return err
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			ctx := env.adminContext(t, env.openApp(t).ID)
+			usr := env.registerUser(t, "user@example.com")
+			lookup := tt.start(t, env, usr, app.ID)
+			if err := lookup(); err != nil {
+				t.Fatalf("lookup before delete: %v", err)
+			}
+
+			if err := env.auth.DeleteApp(ctx, app.ID); err != nil {
+				t.Fatalf("DeleteApp(): %v", err)
+			}
+			if err := lookup(); !errors.Is(err, storage.ErrTokenNotFound) {
+				t.Errorf("lookup after delete error = %v, want %v", err, storage.ErrTokenNotFound)
+			}
+		})
+	}
+}
+
+func TestRotateAppSecret(t *testing.T) {
+	zero := time.Duration(0)
+
+	tests := []struct {
+		name          string
+		gracePeriod   *time.Duration
+		wantOldSecret error
+	}{
+		{name: "configured grace period"},
+		{name: "no grace period", gracePeriod: &zero, wantOldSecret: ErrInvalidClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			ctx := env.adminContext(t, app.ID)
+
+			usr := env.registerUser(t, "user@example.com")
+			issued := env.login(t, usr.Email, app.ID)
+
+			secret, err := env.auth.RotateAppSecret(ctx, app.ID, tt.gracePeriod)
+			if err != nil {
+				t.Fatalf("RotateAppSecret(): %v", err)
+			}
+			if _, err := env.auth.ValidateToken(context.Background(), issued.AccessToken); err != nil {
+				t.Errorf("ValidateToken() of token issued before rotation: %v", err)
+			}
+			if _, err := env.auth.clientApp(context.Background(), env.auth.log, app.ID, secret); err != nil {
+				t.Errorf("new secret rejected: %v", err)
+			}
+			if _, err := env.auth.clientApp(context.Background(), env.auth.log, app.ID, testAppSecret); !errors.Is(err, tt.wantOldSecret) {
+				t.Errorf("old secret error = %v, want %v", err, tt.wantOldSecret)
+			}
+		})
+	}
+}
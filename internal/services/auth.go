@@ -7,105 +7,346 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
+	"auth/internal/lib/encryption"
 	"auth/internal/lib/jwt"
+	"auth/internal/lib/password"
+	"auth/internal/lib/securetoken"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrNotAdminRights     = errors.New("user doesn't have admin rights")
+	ErrPermissionDenied   = errors.New("user doesn't have the permission")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrExpiredToken       = errors.New("token was expired")
+	ErrWeakPassword       = errors.New("password doesn't meet the policy")
 )
 
 type Auth struct {
-	log         *slog.Logger
-	tokenTTL    time.Duration
-	usrSaver    UserSaver
-	usrProvider UserProvider
-	appProvider AppProvider
+	log                    *slog.Logger
+	tokenTTL               time.Duration
+	tokenLeeway            time.Duration
+	refreshTokenTTL        time.Duration
+	signingAlg             string
+	fileKey                *jwt.SigningKey
+	rotationPeriod         time.Duration
+	prepublishPeriod       time.Duration
+	verificationTTL        time.Duration
+	passwordResetTTL       time.Duration
+	passwordPolicy         password.Policy
+	appSecretGracePeriod   time.Duration
+	invitationTTL          time.Duration
+	throttling             LoginThrottling
+	mfaIssuer              string
+	mfaChallengeTTL        time.Duration
+	mfaCipher              *encryption.Cipher
+	webAuthnSessionTTL     time.Duration
+	passwordless           Passwordless
+	authorizationCodeTTL   time.Duration
+	issuer                 string
+	deviceFlow             DeviceFlow
+	usrSaver               UserSaver
+	usrProvider            UserProvider
+	appProvider            AppProvider
+	refreshTokens          RefreshTokenStorage
+	revocations            RevocationStorage
+	roles                  RoleStorage
+	members                MembershipStorage
+	signingKeys            SigningKeyStorage
+	verificationTokens     VerificationTokenStorage
+	passwordResets         PasswordResetStorage
+	loginFailures          LoginFailureStorage
+	mfa                    MFAStorage
+	recoveryCodes          RecoveryCodeStorage
+	passkeys               PasskeyStorage
+	passwordlessChallenges PasswordlessStorage
+	authorizationCodes     AuthorizationCodeStorage
+	deviceCodes            DeviceCodeStorage
+	mailer                 Mailer
+	// keyMu serializes changes of signing key lifecycle
+	keyMu sync.Mutex
+	// pending counts emails being sent in the background
+	pending sync.WaitGroup
+}
+
+// Config holds token issuance settings of the Auth service.
+type Config struct {
+	TokenTTL         time.Duration
+	RefreshTokenTTL  time.Duration
+	SigningAlgorithm string
+	// SigningKey, when set, signs tokens of every app instead of the
+	// per-app keys kept in storage.
+	SigningKey *jwt.SigningKey
+	// KeyRotationPeriod is how long a stored key signs tokens, the next
+	// key is published in JWKS KeyPrepublishPeriod before it takes over.
+	KeyRotationPeriod   time.Duration
+	KeyPrepublishPeriod time.Duration
+	// EmailVerificationTTL is how long a verification token is valid
+	EmailVerificationTTL time.Duration
+	// PasswordResetTTL is how long a password reset token is valid
+	PasswordResetTTL time.Duration
+	// PasswordPolicy is checked whenever a password is set
+	PasswordPolicy password.Policy
+	// AppSecretGracePeriod is how long a rotated app secret is still
+	// accepted by default
+	AppSecretGracePeriod time.Duration
+	// InvitationTTL is how long an invitation to join an app is valid
+	InvitationTTL time.Duration
+	// LoginThrottling slows down and locks out repeated failed logins
+	LoginThrottling LoginThrottling
+	// MFAIssuer names the service in authenticator apps
+	MFAIssuer string
+	// MFAChallengeTTL is how long the second factor may be provided after
+	// the password
+	MFAChallengeTTL time.Duration
+	// MFACipher encrypts authenticator app secrets at rest, MFA can't be
+	// enrolled without it
+	MFACipher *encryption.Cipher
+	// WebAuthnSessionTTL is how long a passkey ceremony may take
+	WebAuthnSessionTTL time.Duration
+	// Passwordless configures login with mailed links and codes
+	Passwordless Passwordless
+	// AuthorizationCodeTTL is how long an OAuth authorization code can be
+	// exchanged for tokens
+	AuthorizationCodeTTL time.Duration
+	// DeviceFlow configures the OAuth device authorization grant
+	DeviceFlow DeviceFlow
+	// Issuer is the iss claim of every token. OpenID Connect is disabled
+	// without it or when tokens are signed with HS256, relying parties
+	// couldn't verify ID tokens.
+	Issuer string
+	// TokenLeeway is the clock skew tolerated when validating tokens
+	TokenLeeway time.Duration
 }
 
 type Storage interface {
 	UserProvider
 	UserSaver
 	AppProvider
+	RefreshTokenStorage
+	RevocationStorage
+	RoleStorage
+	MembershipStorage
+	SigningKeyStorage
+	VerificationTokenStorage
+	PasswordResetStorage
+	LoginFailureStorage
+	MFAStorage
+	RecoveryCodeStorage
+	PasskeyStorage
+	PasswordlessStorage
+	AuthorizationCodeStorage
+	DeviceCodeStorage
 }
 
 type UserSaver interface {
 	SaveUser(ctx context.Context, email string, passHash []byte) (int64, error)
+	UpdatePassHash(ctx context.Context, userID int64, passHash []byte) error
+	UpdateEmail(ctx context.Context, userID int64, email string) error
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
 }
 
 type AppProvider interface {
 	App(ctx context.Context, appID int) (models.App, error)
-	CreateApp(ctx context.Context, app_name string, app_secret string) (int64, error)
+	CreateApp(ctx context.Context, app models.App) (int64, error)
+	Apps(ctx context.Context) ([]models.App, error)
+	UpdateApp(ctx context.Context, app models.App) error
+	DeleteApp(ctx context.Context, appID int) error
+	RotateAppSecret(ctx context.Context, appID int, secretHash []byte, previousExpiresAt time.Time) error
+}
+
+type RefreshTokenStorage interface {
+	SaveRefreshToken(ctx context.Context, token models.RefreshToken) (int64, error)
+	RefreshToken(ctx context.Context, tokenHash []byte) (models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, tokenID int64, next models.RefreshToken) (int64, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int64) error
+}
+
+type RevocationStorage interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID int64, revokedBefore time.Time, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error)
+	PruneRevocations(ctx context.Context, now time.Time) error
 }
 
 // New returns Auth service
-func New(log *slog.Logger, tokenTTL time.Duration, storage Storage) *Auth {
+func New(log *slog.Logger, cfg Config, storage Storage, mailer Mailer) *Auth {
 
 	return &Auth{log: log,
-		tokenTTL:    tokenTTL,
-		usrSaver:    storage,
-		usrProvider: storage,
-		appProvider: storage}
+		tokenTTL:               cfg.TokenTTL,
+		refreshTokenTTL:        cfg.RefreshTokenTTL,
+		signingAlg:             cfg.SigningAlgorithm,
+		fileKey:                cfg.SigningKey,
+		rotationPeriod:         cfg.KeyRotationPeriod,
+		prepublishPeriod:       cfg.KeyPrepublishPeriod,
+		verificationTTL:        cfg.EmailVerificationTTL,
+		passwordResetTTL:       cfg.PasswordResetTTL,
+		passwordPolicy:         cfg.PasswordPolicy,
+		appSecretGracePeriod:   cfg.AppSecretGracePeriod,
+		invitationTTL:          cfg.InvitationTTL,
+		throttling:             cfg.LoginThrottling,
+		mfaIssuer:              cfg.MFAIssuer,
+		mfaChallengeTTL:        cfg.MFAChallengeTTL,
+		mfaCipher:              cfg.MFACipher,
+		webAuthnSessionTTL:     cfg.WebAuthnSessionTTL,
+		passwordless:           cfg.Passwordless,
+		authorizationCodeTTL:   cfg.AuthorizationCodeTTL,
+		issuer:                 cfg.Issuer,
+		tokenLeeway:            cfg.TokenLeeway,
+		deviceFlow:             cfg.DeviceFlow,
+		usrSaver:               storage,
+		usrProvider:            storage,
+		appProvider:            storage,
+		refreshTokens:          storage,
+		revocations:            storage,
+		roles:                  storage,
+		members:                storage,
+		signingKeys:            storage,
+		verificationTokens:     storage,
+		passwordResets:         storage,
+		loginFailures:          storage,
+		mfa:                    storage,
+		recoveryCodes:          storage,
+		passkeys:               storage,
+		passwordlessChallenges: storage,
+		authorizationCodes:     storage,
+		deviceCodes:            storage,
+		mailer:                 mailer}
 }
 
-func (a *Auth) Login(ctx context.Context, email string, password string, appID int) (string, error) {
+// Login checks the credentials and issues a token pair. Failed attempts are
+// counted by email and by clientIP, when it is known, and throttled. Users
+// with MFA enabled get an MFA challenge token instead, the tokens are
+// issued by VerifyMFA.
+func (a *Auth) Login(ctx context.Context, email string, password string, appID int, clientIP string) (models.LoginResult, error) {
 	const op = "auth.Login"
 
+	email = normalizeEmail(email)
 	log := a.log.With(
 		slog.String("op", op),
 	)
 	log.Info("attempting to login user")
 
+	usr, err := a.checkPassword(ctx, log, email, password, loginKeys(email, clientIP), time.Now())
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	mfaEnabled, err := a.mfaEnabled(ctx, usr.ID)
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if mfaEnabled {
+		// failures are kept until the second factor is checked as well
+		mfaToken, err := a.newMFAChallenge(ctx, usr.ID, appID)
+		if err != nil {
+			log.Error("failed to create mfa challenge", slog.String("error", err.Error()))
+			return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("mfa required", slog.Int64("user_id", usr.ID))
+		return models.LoginResult{MFAToken: mfaToken}, nil
+	}
+
+	if err := a.loginFailures.ResetLoginFailures(ctx, userLoginKey(email)); err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	tokens, err := a.completeLogin(ctx, log, usr, appID)
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return models.LoginResult{Tokens: tokens}, nil
+}
+
+// checkPassword returns the user the email and password belong to. Wrong
+// credentials are counted against keys for login throttling.
+func (a *Auth) checkPassword(ctx context.Context, log *slog.Logger, email string, password string, keys []string, now time.Time) (models.User, error) {
+	if err := a.checkLoginAllowed(ctx, keys, now); err != nil {
+		log.Warn("login throttled", slog.String("error", err.Error()))
+		return models.User{}, err
+	}
+
 	usr, err := a.usrProvider.User(ctx, email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found")
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+			log.Warn("user not found")
+			return models.User{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidCredentials)
 		}
-		return "", fmt.Errorf("%s: %w", op, err)
+		return models.User{}, err
 	}
 
 	// check password
 	err = bcrypt.CompareHashAndPassword(usr.PassHash, []byte(password))
 	if err != nil {
-		a.log.Info("invalid credentials", err)
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		log.Info("invalid credentials", slog.String("error", err.Error()))
+		return models.User{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidCredentials)
 	}
+	return usr, nil
+}
+
+// admitUser makes sure the app lets the user in, joining the app if it
+// allows self registration.
+func (a *Auth) admitUser(ctx context.Context, log *slog.Logger, usr models.User, app models.App) error {
+	if err := a.joinApp(ctx, usr, app); err != nil {
+		return err
+	}
+	if app.RequireEmailVerification && !usr.EmailVerified {
+		log.Info("email not verified", slog.Int64("user_id", usr.ID))
+		return ErrEmailNotVerified
+	}
+	return nil
+}
 
+// completeLogin issues tokens to a user who has proven its identity, once
+// the app lets the user in.
+func (a *Auth) completeLogin(ctx context.Context, log *slog.Logger, usr models.User, appID int) (models.TokenPair, error) {
 	app, err := a.appProvider.App(ctx, appID)
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		return models.TokenPair{}, err
+	}
+	if err := a.admitUser(ctx, log, usr, app); err != nil {
+		return models.TokenPair{}, err
 	}
-	log.Info("user logged in successfully")
 
-	// generate new token
-	token, err := jwt.NewToken(usr, app, a.tokenTTL)
+	// generate new token pair in a new refresh token family
+	familyID, err := securetoken.New()
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	tokens, err := a.issueTokens(ctx, usr, app, familyID, 0, nil)
 	if err != nil {
-		a.log.Info("failed to generate token", err)
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return models.TokenPair{}, err
 	}
-	return token, nil
+	log.Info("user logged in successfully")
+	return tokens, nil
 }
 
 func (a *Auth) RegisterNewUser(ctx context.Context, email string, password string) (int64, error) {
 	const op = "auth.RegisterNewUser"
 
+	email = normalizeEmail(email)
 	log := a.log.With(
 		slog.String("op", op),
 	)
 	log.Info("registering new user")
 	// Generate hash from service
-	pHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	pHash, err := a.hashPassword(password)
 	if err != nil {
+		if errors.Is(err, ErrWeakPassword) {
+			log.Info("weak password", slog.String("error", err.Error()))
+			return 0, err
+		}
 		log.Error("failed to generate password hash", slog.String("op", err.Error()))
 		return 0, err
 	}
@@ -116,9 +357,21 @@ func (a *Auth) RegisterNewUser(ctx context.Context, email string, password strin
 		return 0, err
 	}
 	log.Info("user registered")
+
+	// the account is usable without verification in apps that allow it,
+	// so a failed delivery is only logged
+	if err := a.sendVerificationEmail(ctx, models.User{ID: id, Email: email}); err != nil {
+		log.Error("failed to send verification email", slog.String("error", err.Error()))
+	}
 	return id, nil
 }
 
+// normalizeEmail returns the form emails are stored and looked up in, so
+// they match whatever case the user types.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	const op = "auth.IsAdmin"
 
@@ -136,44 +389,82 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	return isAdmin, nil
 }
 
-func (a *Auth) CreateApp(ctx context.Context, email string, password string, app_name string, app_secret string) (int64, error) {
+// CreateApp registers a new app. It requires the apps:manage permission
+// in every app.
+func (a *Auth) CreateApp(ctx context.Context, app models.App) (int64, error) {
 	const op = "auth.CreateApp"
 
 	log := a.log.With(
 		slog.String("op", op),
-		slog.String("app_name", app_name),
+		slog.String("app_name", app.Name),
 	)
-	// check profile
-	usr, err := a.usrProvider.User(ctx, email)
-	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found")
-			return 0, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
-		}
+	if err := a.authorize(ctx, PermManageApps, 0); err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-
-	// check password
-	err = bcrypt.CompareHashAndPassword(usr.PassHash, []byte(password))
-	if err != nil {
-		a.log.Info("invalid credentials", err)
-		return 0, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	if err := validateRedirectURIs(app.RedirectURIs); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	// check admin rights
-	isAdmin, err := a.IsAdmin(ctx, usr.ID)
-	if err != nil {
-		return 0, err
+	if err := validateScopes(app.Scopes); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	if !isAdmin {
-		log.Warn("user not a admin")
-		return 0, fmt.Errorf("%s: %w", op, ErrNotAdminRights)
+	secretHash, err := hashAppSecret(app.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
+	app.Secret = ""
+	app.SecretHash = secretHash
 	// create new app
 	log.Info("registering new app")
-	appID, err := a.appProvider.CreateApp(ctx, app_name, app_secret)
+	appID, err := a.appProvider.CreateApp(ctx, app)
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	log.Info("app created")
 	return appID, nil
 }
+
+// ValidateToken verifies the token against the key it was signed with,
+// makes sure its app still exists and it has not been revoked and returns
+// its claims.
+func (a *Auth) ValidateToken(ctx context.Context, token string) (jwt.Claims, error) {
+	const op = "auth.ValidateToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("validating token")
+
+	claims, err := a.parseToken(ctx, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, jwt.ErrExpiredToken):
+			log.Info("token expired")
+			return jwt.Claims{}, fmt.Errorf("%s: %w", op, ErrExpiredToken)
+		case errors.Is(err, jwt.ErrInvalidToken), errors.Is(err, storage.ErrAppNotFound):
+			log.Info("invalid token", slog.String("error", err.Error()))
+			return jwt.Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return jwt.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the file key isn't bound to an app, tokens of deleted apps are
+	// rejected here
+	if _, err := a.appProvider.App(ctx, claims.AppID); err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found", slog.Int("app_id", claims.AppID))
+			return jwt.Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return jwt.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	revoked, err := a.revocations.IsTokenRevoked(ctx, claims.ID, claims.UID, claims.IssuedAt)
+	if err != nil {
+		return jwt.Claims{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if revoked {
+		log.Info("token revoked", slog.String("jti", claims.ID))
+		return jwt.Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	log.Info("token is valid", slog.Int64("user_id", claims.UID), slog.Int("app_id", claims.AppID))
+	return claims, nil
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"auth/internal/lib/encryption"
+	"auth/internal/lib/jwt"
+	"auth/internal/lib/password"
+	"auth/internal/lib/principal"
+	"auth/internal/models"
+	"auth/internal/storage/sqlite"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const (
+	testIssuer    = "https://auth.example.com"
+	testPassword  = "correct-horse"
+	testAppSecret = "app-secret"
+)
+
+// testMail is an email delivered through testMailer.
+type testMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// testMailer keeps the emails sent instead of delivering them.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []testMail
+}
+
+func (m *testMailer) Send(_ context.Context, to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, testMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// to returns the emails sent to the address.
+func (m *testMailer) to(address string) []testMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var mails []testMail
+	for _, mail := range m.sent {
+		if mail.To == address {
+			mails = append(mails, mail)
+		}
+	}
+	return mails
+}
+
+// lastSecret returns the token, code or link of the last email sent to the
+// address, the paragraph following the first one.
+func (m *testMailer) lastSecret(t *testing.T, address string) string {
+	t.Helper()
+
+	mails := m.to(address)
+	if len(mails) == 0 {
+		t.Fatalf("no email sent to %s", address)
+	}
+	paragraphs := strings.Split(mails[len(mails)-1].Body, "\n\n")
+	if len(paragraphs) < 2 {
+		t.Fatalf("no secret in email to %s", address)
+	}
+	return paragraphs[1]
+}
+
+// testEnv is an Auth service backed by a freshly migrated database.
+type testEnv struct {
+	auth    *Auth
+	storage *sqlite.Storage
+	mailer  *testMailer
+	apps    int
+}
+
+// newTestEnv migrates a database in a temporary directory and returns the
+// service on top of it. configure may change the defaults.
+func newTestEnv(t *testing.T, configure ...func(cfg *Config)) *testEnv {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "auth.db")
+	m, err := migrate.New("file://../../cmd/migrator/migrations", "sqlite3://"+path)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	m.Close()
+
+	storage, err := sqlite.New(path)
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+
+	cipher, err := encryption.New([]byte(strings.Repeat("k", encryption.KeySize)))
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	cfg := Config{
+		TokenTTL:             time.Hour,
+		RefreshTokenTTL:      24 * time.Hour,
+		SigningAlgorithm:     jwt.AlgES256,
+		KeyRotationPeriod:    30 * 24 * time.Hour,
+		KeyPrepublishPeriod:  24 * time.Hour,
+		EmailVerificationTTL: time.Hour,
+		PasswordResetTTL:     time.Hour,
+		PasswordPolicy:       password.Policy{MinLength: 8},
+		AppSecretGracePeriod: time.Hour,
+		InvitationTTL:        time.Hour,
+		MFAIssuer:            "auth",
+		MFAChallengeTTL:      5 * time.Minute,
+		MFACipher:            cipher,
+		WebAuthnSessionTTL:   5 * time.Minute,
+		Passwordless: Passwordless{
+			TTL:         10 * time.Minute,
+			MaxAttempts: 3,
+			LinkURL:     "https://app.example.com/login",
+		},
+		AuthorizationCodeTTL: time.Minute,
+		DeviceFlow: DeviceFlow{
+			TTL:             10 * time.Minute,
+			PollInterval:    5 * time.Second,
+			VerificationURI: "https://app.example.com/device",
+		},
+		Issuer: testIssuer,
+	}
+	for _, c := range configure {
+		c(&cfg)
+	}
+
+	mailer := &testMailer{}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a := New(log, cfg, storage, mailer)
+	t.Cleanup(a.Wait)
+	return &testEnv{auth: a, storage: storage, mailer: mailer}
+}
+
+// createApp saves the app with testAppSecret.
+func (e *testEnv) createApp(t *testing.T, app models.App) models.App {
+	t.Helper()
+
+	if app.Name == "" {
+		e.apps++
+		app.Name = fmt.Sprintf("app-%d", e.apps)
+	}
+	hash, err := hashAppSecret(testAppSecret)
+	if err != nil {
+		t.Fatalf("hash secret: %v", err)
+	}
+	app.SecretHash = hash
+	id, err := e.storage.CreateApp(context.Background(), app)
+	if err != nil {
+		t.Fatalf("create app: %v", err)
+	}
+	app.ID = int(id)
+	return app
+}
+
+// openApp creates an app any user may log in to.
+func (e *testEnv) openApp(t *testing.T) models.App {
+	t.Helper()
+	return e.createApp(t, models.App{AllowSelfRegistration: true})
+}
+
+// registerUser saves a user with testPassword.
+func (e *testEnv) registerUser(t *testing.T, email string) models.User {
+	t.Helper()
+
+	id, err := e.auth.RegisterNewUser(context.Background(), email, testPassword)
+	if err != nil {
+		t.Fatalf("register %s: %v", email, err)
+	}
+	usr, err := e.storage.UserByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("user %d: %v", id, err)
+	}
+	return usr
+}
+
+// login logs the user in with testPassword.
+func (e *testEnv) login(t *testing.T, email string, appID int) models.TokenPair {
+	t.Helper()
+
+	res, err := e.auth.Login(context.Background(), email, testPassword, appID, "")
+	if err != nil {
+		t.Fatalf("login %s: %v", email, err)
+	}
+	return res.Tokens
+}
+
+// userContext logs the user in and returns the context of a request
+// authenticated with the access token.
+func (e *testEnv) userContext(t *testing.T, email string, appID int) context.Context {
+	t.Helper()
+
+	tokens := e.login(t, email, appID)
+	claims, err := e.auth.ValidateToken(context.Background(), tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("validate token: %v", err)
+	}
+	return principal.WithClaims(context.Background(), claims)
+}
+
+// adminContext registers an admin and returns the context of its request.
+func (e *testEnv) adminContext(t *testing.T, appID int) context.Context {
+	t.Helper()
+
+	admin := e.registerUser(t, "admin@example.com")
+	if err := e.storage.AssignRole(context.Background(), admin.ID, "admin", 0); err != nil {
+		t.Fatalf("assign admin role: %v", err)
+	}
+	return e.userContext(t, admin.Email, appID)
+}
+
+// signToken signs a token for the user with the current key of the app.
+func (e *testEnv) signToken(t *testing.T, usr models.User, app models.App, ttl time.Duration) string {
+	t.Helper()
+
+	key, err := e.auth.signingKey(context.Background(), app)
+	if err != nil {
+		t.Fatalf("signing key: %v", err)
+	}
+	token, err := jwt.NewToken(testIssuer, usr, app, models.Access{}, nil, key, ttl)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func TestValidateToken(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	other := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	tokens := env.login(t, usr.Email, app.ID)
+
+	otherKey, err := env.auth.signingKey(context.Background(), other)
+	if err != nil {
+		t.Fatalf("signing key: %v", err)
+	}
+	// the kid of the other app's key, claims of this app
+	foreign, err := jwt.NewToken(testIssuer, usr, app, models.Access{}, nil, otherKey, time.Hour)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "valid", token: tokens.AccessToken},
+		{name: "expired", token: env.signToken(t, usr, app, -time.Minute), wantErr: ErrExpiredToken},
+		{name: "malformed", token: "not-a-token", wantErr: ErrInvalidToken},
+		{name: "tampered signature", token: tokens.AccessToken[:len(tokens.AccessToken)-4] + "AAAA", wantErr: ErrInvalidToken},
+		{name: "key of another app", token: foreign, wantErr: ErrInvalidToken},
+		{name: "refresh token", token: tokens.RefreshToken, wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := env.auth.ValidateToken(context.Background(), tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if claims.UID != usr.ID || claims.AppID != app.ID || claims.Email != usr.Email {
+				t.Errorf("ValidateToken() claims = %+v, want user %d in app %d", claims, usr.ID, app.ID)
+			}
+		})
+	}
+}
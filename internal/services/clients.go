@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidScope = errors.New("scope is invalid or not granted to the app")
+
+// IssueClientToken issues a token to the app itself, for calls between
+// services made without a user. The app authenticates with its secret.
+// Without requested scopes every scope of the app is granted.
+func (a *Auth) IssueClientToken(ctx context.Context, appID int, secret string, scopes []string) (models.ClientToken, error) {
+	const op = "auth.IssueClientToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+	log.Info("issuing client token")
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found")
+			return models.ClientToken{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+		return models.ClientToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.authenticateClient(log, app, secret); err != nil {
+		return models.ClientToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	granted, err := grantScopes(app, scopes)
+	if err != nil {
+		log.Info("scope not granted", slog.String("scopes", strings.Join(scopes, " ")))
+		return models.ClientToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := a.signingKey(ctx, app)
+	if err != nil {
+		return models.ClientToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	expiresAt := time.Now().Add(a.tokenTTL)
+	token, err := jwt.NewClientToken(a.issuer, app, granted, key, a.tokenTTL)
+	if err != nil {
+		log.Error("failed to issue client token", slog.String("error", err.Error()))
+		return models.ClientToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("client token issued")
+	return models.ClientToken{AccessToken: token, Scopes: granted, ExpiresAt: expiresAt}, nil
+}
+
+// clientApp returns the app of an OAuth client. The secret is optional,
+// public clients can't keep one, but it has to match when given.
+func (a *Auth) clientApp(ctx context.Context, log *slog.Logger, appID int, secret string) (models.App, error) {
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found")
+			return models.App{}, ErrInvalidClient
+		}
+		return models.App{}, err
+	}
+	if secret != "" {
+		if err := a.authenticateClient(log, app, secret); err != nil {
+			return models.App{}, err
+		}
+	}
+	return app, nil
+}
+
+// authenticateClient checks the secret of the app against its hashes.
+func (a *Auth) authenticateClient(log *slog.Logger, app models.App, secret string) error {
+	if secret == "" {
+		log.Info("client secret missing")
+		return ErrInvalidClient
+	}
+	now := time.Now()
+	for _, hash := range app.SecretHashes(now) {
+		if bcrypt.CompareHashAndPassword(hash, []byte(secret)) == nil {
+			return nil
+		}
+	}
+	log.Info("invalid client secret")
+	return ErrInvalidClient
+}
+
+func hashAppSecret(secret string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+}
+
+// grantScopes returns the requested scopes if the app has all of them, or
+// every scope of the app if none are requested.
+func grantScopes(app models.App, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return app.Scopes, nil
+	}
+	granted := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !containsString(app.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		if !containsString(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted, nil
+}
+
+// validateScopes makes sure every scope is a valid scope token of RFC 6749.
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if scope == "" {
+			return fmt.Errorf("%w: empty scope", ErrInvalidScope)
+		}
+		for _, r := range scope {
+			if r < 0x21 || r > 0x7e || r == '"' || r == '\\' {
+				return fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
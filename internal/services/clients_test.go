@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIssueClientToken(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.createApp(t, models.App{Scopes: []string{"orders:read", "orders:write"}})
+
+	tests := []struct {
+		name       string
+		appID      int
+		secret     string
+		scopes     []string
+		wantErr    error
+		wantScopes []string
+	}{
+		{
+			name:       "every scope of the app",
+			appID:      app.ID,
+			secret:     testAppSecret,
+			wantScopes: []string{"orders:read", "orders:write"},
+		},
+		{
+			name:       "requested scopes",
+			appID:      app.ID,
+			secret:     testAppSecret,
+			scopes:     []string{"orders:read", "orders:read"},
+			wantScopes: []string{"orders:read"},
+		},
+		{
+			name:    "scope of another app",
+			appID:   app.ID,
+			secret:  testAppSecret,
+			scopes:  []string{"orders:read", "users:write"},
+			wantErr: ErrInvalidScope,
+		},
+		{name: "wrong secret", appID: app.ID, secret: "wrong", wantErr: ErrInvalidClient},
+		{name: "no secret", appID: app.ID, wantErr: ErrInvalidClient},
+		{name: "unknown app", appID: app.ID + 100, secret: testAppSecret, wantErr: ErrInvalidClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := env.auth.IssueClientToken(context.Background(), tt.appID, tt.secret, tt.scopes)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IssueClientToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(token.Scopes, tt.wantScopes) {
+				t.Errorf("IssueClientToken() scopes = %v, want %v", token.Scopes, tt.wantScopes)
+			}
+			claims, err := env.auth.ValidateToken(context.Background(), token.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken(): %v", err)
+			}
+			if !claims.IsClient() || claims.ClientID != app.ID || claims.UID != 0 {
+				t.Errorf("claims = %+v, want a client token of app %d", claims, app.ID)
+			}
+			if !reflect.DeepEqual(claims.Scopes, tt.wantScopes) {
+				t.Errorf("claims scopes = %v, want %v", claims.Scopes, tt.wantScopes)
+			}
+		})
+	}
+}
+
+func TestIssueClientTokenAfterSecretRotation(t *testing.T) {
+	grace := time.Hour
+	noGrace := time.Duration(0)
+
+	tests := []struct {
+		name          string
+		gracePeriod   *time.Duration
+		wantOldSecret error
+	}{
+		{name: "within grace period", gracePeriod: &grace},
+		{name: "without grace period", gracePeriod: &noGrace, wantOldSecret: ErrInvalidClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			secret, err := env.auth.RotateAppSecret(env.adminContext(t, app.ID), app.ID, tt.gracePeriod)
+			if err != nil {
+				t.Fatalf("RotateAppSecret(): %v", err)
+			}
+
+			if _, err := env.auth.IssueClientToken(context.Background(), app.ID, secret, nil); err != nil {
+				t.Errorf("IssueClientToken() with new secret: %v", err)
+			}
+			if _, err := env.auth.IssueClientToken(context.Background(), app.ID, testAppSecret, nil); !errors.Is(err, tt.wantOldSecret) {
+				t.Errorf("IssueClientToken() with old secret error = %v, want %v", err, tt.wantOldSecret)
+			}
+		})
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		wantErr error
+	}{
+		{name: "valid scopes", scopes: []string{"orders:read", "https://api.example.com/orders"}},
+		{name: "empty scope", scopes: []string{""}, wantErr: ErrInvalidScope},
+		{name: "space", scopes: []string{"orders read"}, wantErr: ErrInvalidScope},
+		{name: "quote", scopes: []string{`orders"`}, wantErr: ErrInvalidScope},
+		{name: "backslash", scopes: []string{`orders\read`}, wantErr: ErrInvalidScope},
+		{name: "non ascii", scopes: []string{"заказы"}, wantErr: ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateScopes(tt.scopes); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateScopes() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
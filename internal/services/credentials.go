@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"auth/internal/lib/password"
+	"auth/internal/lib/principal"
+	"auth/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePassword sets a new password for the caller. The current password
+// has to be confirmed, wrong ones are throttled the same way as on login.
+// Every session of the user, including the one of the
+// access token, is signed out.
+func (a *Auth) ChangePassword(ctx context.Context, currentPassword string, newPassword string, clientIP string) error {
+	const op = "auth.ChangePassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	usr, err := a.reauthenticate(ctx, log, currentPassword, clientIP)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", usr.ID))
+	log.Info("changing password")
+
+	pHash, err := a.hashPassword(newPassword)
+	if err != nil {
+		if errors.Is(err, ErrWeakPassword) {
+			log.Info("weak password", slog.String("error", err.Error()))
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.usrSaver.UpdatePassHash(ctx, usr.ID, pHash); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeUserSessions(ctx, usr.ID); err != nil {
+		log.Error("failed to revoke sessions", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("password changed")
+	return nil
+}
+
+// ChangeEmail moves the account of the caller to a new email address. The
+// current password has to be confirmed, wrong ones are throttled the same
+// way as on login. The new address is unverified
+// until the user follows the link mailed to it, and tokens carrying the
+// old address are revoked.
+func (a *Auth) ChangeEmail(ctx context.Context, password string, newEmail string, clientIP string) error {
+	const op = "auth.ChangeEmail"
+
+	newEmail = normalizeEmail(newEmail)
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	usr, err := a.reauthenticate(ctx, log, password, clientIP)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", usr.ID))
+	log.Info("changing email")
+
+	if err := a.usrSaver.UpdateEmail(ctx, usr.ID, newEmail); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeUserSessions(ctx, usr.ID); err != nil {
+		log.Error("failed to revoke sessions", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("email changed")
+
+	// the change has been made, so a failed delivery is only logged and
+	// the user may ask for another email
+	usr.Email = newEmail
+	if err := a.sendVerificationEmail(ctx, usr); err != nil {
+		log.Error("failed to send verification email", slog.String("error", err.Error()))
+	}
+	return nil
+}
+
+// reauthenticate returns the caller after checking that the password is
+// theirs. Wrong passwords are counted for login throttling of the caller
+// and the client IP.
+func (a *Auth) reauthenticate(ctx context.Context, log *slog.Logger, password string, clientIP string) (models.User, error) {
+	claims, ok := principal.FromContext(ctx)
+	if !ok {
+		return models.User{}, ErrInvalidToken
+	}
+	usr, err := a.usrProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	keys := loginKeys(usr.Email, clientIP)
+	now := time.Now()
+	if err := a.checkLoginAllowed(ctx, keys, now); err != nil {
+		log.Warn("reauthentication throttled", slog.String("error", err.Error()))
+		return models.User{}, err
+	}
+
+	// check password
+	if err := bcrypt.CompareHashAndPassword(usr.PassHash, []byte(password)); err != nil {
+		log.Info("invalid credentials", slog.String("error", err.Error()))
+		return models.User{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidCredentials)
+	}
+	if err := a.loginFailures.ResetLoginFailures(ctx, userLoginKey(usr.Email)); err != nil {
+		return models.User{}, err
+	}
+	return usr, nil
+}
+
+// hashPassword checks the password against the policy and hashes it.
+func (a *Auth) hashPassword(pass string) ([]byte, error) {
+	if err := a.passwordPolicy.Check(pass); err != nil {
+		if errors.Is(err, password.ErrWeakPassword) {
+			return nil, fmt.Errorf("%w: %s", ErrWeakPassword, err.Error())
+		}
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestChangePassword(t *testing.T) {
+	tests := []struct {
+		name            string
+		anonymous       bool
+		currentPassword string
+		newPassword     string
+		wantErr         error
+	}{
+		{name: "confirmed", currentPassword: testPassword, newPassword: newPassword},
+		{name: "wrong current password", currentPassword: "wrong-password", newPassword: newPassword, wantErr: ErrInvalidCredentials},
+		{name: "weak password", currentPassword: testPassword, newPassword: "short", wantErr: ErrWeakPassword},
+		{name: "no access token", anonymous: true, currentPassword: testPassword, newPassword: newPassword, wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			session := env.login(t, usr.Email, app.ID)
+			ctx := env.userContext(t, usr.Email, app.ID)
+			if tt.anonymous {
+				ctx = context.Background()
+			}
+
+			err := env.auth.ChangePassword(ctx, tt.currentPassword, tt.newPassword, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangePassword() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); err != nil {
+					t.Errorf("Login() with the unchanged password: %v", err)
+				}
+				return
+			}
+			if _, err := env.auth.Login(context.Background(), usr.Email, tt.newPassword, app.ID, ""); err != nil {
+				t.Errorf("Login() with the new password: %v", err)
+			}
+			if _, err := env.auth.Refresh(context.Background(), session.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Refresh() of a session before the change error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	const changed = "changed@example.com"
+
+	tests := []struct {
+		name     string
+		password string
+		newEmail string
+		wantErr  error
+	}{
+		{name: "confirmed", password: testPassword, newEmail: changed},
+		{name: "wrong password", password: "wrong-password", newEmail: changed, wantErr: ErrInvalidCredentials},
+		{name: "email taken", password: testPassword, newEmail: "taken@example.com", wantErr: storage.ErrUserExists},
+		{name: "email taken in another case", password: testPassword, newEmail: "Taken@Example.com", wantErr: storage.ErrUserExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			env.registerUser(t, "taken@example.com")
+			if _, err := env.auth.VerifyEmail(context.Background(), env.mailer.lastSecret(t, usr.Email)); err != nil {
+				t.Fatalf("VerifyEmail(): %v", err)
+			}
+			ctx := env.userContext(t, usr.Email, app.ID)
+
+			err := env.auth.ChangeEmail(ctx, tt.password, tt.newEmail, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangeEmail() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			changedUser, err := env.storage.UserByID(context.Background(), usr.ID)
+			if err != nil {
+				t.Fatalf("user: %v", err)
+			}
+			if changedUser.Email != tt.newEmail || changedUser.EmailVerified {
+				t.Errorf("user = %s verified %v, want unverified %s", changedUser.Email, changedUser.EmailVerified, tt.newEmail)
+			}
+			if _, err := env.auth.VerifyEmail(context.Background(), env.mailer.lastSecret(t, tt.newEmail)); err != nil {
+				t.Errorf("VerifyEmail() of the new address: %v", err)
+			}
+			if _, err := env.auth.Login(context.Background(), tt.newEmail, testPassword, app.ID, ""); err != nil {
+				t.Errorf("Login() with the new email: %v", err)
+			}
+		})
+	}
+}
+
+func TestReauthenticateLockout(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(env *testEnv, ctx context.Context, password string) error
+		// email and password of the user after the change
+		email    string
+		password string
+	}{
+		{
+			name: "change password",
+			change: func(env *testEnv, ctx context.Context, password string) error {
+				return env.auth.ChangePassword(ctx, password, newPassword, "203.0.113.1")
+			},
+			email:    "user@example.com",
+			password: newPassword,
+		},
+		{
+			name: "change email",
+			change: func(env *testEnv, ctx context.Context, password string) error {
+				return env.auth.ChangeEmail(ctx, password, "changed@example.com", "203.0.113.1")
+			},
+			email:    "changed@example.com",
+			password: testPassword,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, lockout)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			ctx := env.userContext(t, usr.Email, app.ID)
+
+			for i := 0; i < env.auth.throttling.MaxFailures-1; i++ {
+				if err := tt.change(env, ctx, "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("error = %v, want %v", err, ErrInvalidCredentials)
+				}
+			}
+			// a success resets the failures, so they have to add up again
+			if err := tt.change(env, ctx, testPassword); err != nil {
+				t.Fatalf("change after failures: %v", err)
+			}
+			for i := 0; i < env.auth.throttling.MaxFailures; i++ {
+				if err := tt.change(env, ctx, "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("error = %v, want %v", err, ErrInvalidCredentials)
+				}
+			}
+
+			if err := tt.change(env, ctx, tt.password); !errors.Is(err, ErrTooManyAttempts) {
+				t.Errorf("error with correct password while locked out = %v, want %v", err, ErrTooManyAttempts)
+			}
+			if _, err := env.auth.Login(context.Background(), tt.email, tt.password, app.ID, ""); !errors.Is(err, ErrTooManyAttempts) {
+				t.Errorf("Login() error = %v, want %v", err, ErrTooManyAttempts)
+			}
+		})
+	}
+}
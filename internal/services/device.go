@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"auth/internal/lib/principal"
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// userCodeAlphabet has no vowels, so codes don't spell words, and no
+	// characters that are easily confused
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// slowDownStep is added to the poll interval of a device polling too
+	// often
+	slowDownStep = 5 * time.Second
+)
+
+var (
+	ErrDeviceFlowDisabled   = errors.New("device authorization is not configured")
+	ErrAuthorizationPending = errors.New("device authorization is pending")
+	ErrSlowDown             = errors.New("device polls too often")
+	ErrAccessDenied         = errors.New("user denied the device authorization")
+	ErrDeviceCodeExpired    = errors.New("device code expired")
+	ErrInvalidUserCode      = errors.New("invalid user code")
+)
+
+// DeviceFlow configures the device authorization grant of RFC 8628.
+type DeviceFlow struct {
+	// TTL is how long the user has to approve the device
+	TTL time.Duration
+	// PollInterval is how long the device waits between polls at first
+	PollInterval time.Duration
+	// VerificationURI is the page users enter user codes at, it calls
+	// ApproveDevice. The grant is disabled without it.
+	VerificationURI string
+}
+
+type DeviceCodeStorage interface {
+	SaveDeviceCode(ctx context.Context, code models.DeviceCode) (int64, error)
+	DeviceCode(ctx context.Context, deviceCodeHash []byte) (models.DeviceCode, error)
+	DeviceCodeByUserCode(ctx context.Context, userCodeHash []byte) (models.DeviceCode, error)
+	DecideDeviceCode(ctx context.Context, codeID int64, userID int64, status string) error
+	RecordDevicePoll(ctx context.Context, codeID int64, polledAt time.Time, interval time.Duration) error
+	UseDeviceCode(ctx context.Context, codeID int64) error
+}
+
+// AuthorizeDevice starts the device authorization grant for the app. The
+// device shows the user code and polls PollDevice with the device code
+// until the user approves it. The secret is optional, as in the
+// authorization_code grant.
+func (a *Auth) AuthorizeDevice(ctx context.Context, appID int, secret string) (models.DeviceAuthorization, error) {
+	const op = "auth.AuthorizeDevice"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+	log.Info("authorizing device")
+
+	if a.deviceFlow.VerificationURI == "" {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, ErrDeviceFlowDisabled)
+	}
+	app, err := a.clientApp(ctx, log, appID, secret)
+	if err != nil {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deviceCode, err := securetoken.New()
+	if err != nil {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+	expiresAt := time.Now().Add(a.deviceFlow.TTL)
+
+	// user codes are short, a new one is drawn on the rare collision
+	var userCode string
+	for attempt := 0; ; attempt++ {
+		userCode, err = newUserCode()
+		if err != nil {
+			return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+		}
+		_, err = a.deviceCodes.SaveDeviceCode(ctx, models.DeviceCode{
+			DeviceCodeHash: securetoken.Hash(deviceCode),
+			UserCodeHash:   securetoken.Hash(normalizeUserCode(userCode)),
+			AppID:          app.ID,
+			PollInterval:   a.deviceFlow.PollInterval,
+			ExpiresAt:      expiresAt,
+		})
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, storage.ErrUserCodeExists) || attempt == 2 {
+			return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	complete, err := url.Parse(a.deviceFlow.VerificationURI)
+	if err != nil {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+	query := complete.Query()
+	query.Set("user_code", userCode)
+	complete.RawQuery = query.Encode()
+
+	log.Info("device authorization started")
+	return models.DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         a.deviceFlow.VerificationURI,
+		VerificationURIComplete: complete.String(),
+		ExpiresAt:               expiresAt,
+		PollInterval:            a.deviceFlow.PollInterval,
+	}, nil
+}
+
+// ApproveDevice records the decision of the caller on the device showing
+// the user code. An approved device logs in to its app as the caller.
+func (a *Auth) ApproveDevice(ctx context.Context, userCode string, approve bool) (int, error) {
+	const op = "auth.ApproveDevice"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Bool("approve", approve),
+	)
+
+	claims, ok := principal.FromContext(ctx)
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	log = log.With(slog.Int64("user_id", claims.UID))
+	log.Info("deciding device authorization")
+
+	code, err := a.deviceCodes.DeviceCodeByUserCode(ctx, securetoken.Hash(normalizeUserCode(userCode)))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("user code not found")
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidUserCode)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	log = log.With(slog.Int("app_id", code.AppID))
+	if code.Status != models.DeviceCodePending {
+		log.Info("device authorization already decided")
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidUserCode)
+	}
+	if time.Now().After(code.ExpiresAt) {
+		log.Info("user code expired")
+		return 0, fmt.Errorf("%s: %w", op, ErrExpiredToken)
+	}
+
+	status := models.DeviceCodeDenied
+	if approve {
+		usr, err := a.usrProvider.UserByID(ctx, claims.UID)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		app, err := a.appProvider.App(ctx, code.AppID)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := a.admitUser(ctx, log, usr, app); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		status = models.DeviceCodeApproved
+	}
+
+	if err := a.deviceCodes.DecideDeviceCode(ctx, code.ID, claims.UID, status); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			log.Info("device authorization already decided")
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidUserCode)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("device authorization decided")
+	return code.AppID, nil
+}
+
+// PollDevice exchanges the device code for tokens once the user approved
+// the device. Until then it gives ErrAuthorizationPending, or ErrSlowDown
+// when the device polls before its interval has passed, which also makes
+// the interval longer.
+func (a *Auth) PollDevice(ctx context.Context, appID int, secret string, deviceCode string) (models.TokenPair, error) {
+	const op = "auth.PollDevice"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	app, err := a.clientApp(ctx, log, appID, secret)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := a.deviceCodes.DeviceCode(ctx, securetoken.Hash(deviceCode))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("device code not found")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if code.AppID != app.ID {
+		log.Info("device code issued to another app")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+	now := time.Now()
+	if now.After(code.ExpiresAt) {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrDeviceCodeExpired)
+	}
+
+	interval := code.PollInterval
+	tooSoon := now.Sub(code.LastPolledAt) < interval
+	if tooSoon {
+		interval += slowDownStep
+	}
+	if err := a.deviceCodes.RecordDevicePoll(ctx, code.ID, now, interval); err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if tooSoon {
+		log.Info("device polls too often", slog.Duration("interval", interval))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrSlowDown)
+	}
+
+	switch code.Status {
+	case models.DeviceCodePending:
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrAuthorizationPending)
+	case models.DeviceCodeDenied:
+		log.Info("device authorization denied")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrAccessDenied)
+	case models.DeviceCodeApproved:
+	default:
+		log.Info("device code already used")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+	log = log.With(slog.Int64("user_id", code.UserID))
+
+	if err := a.deviceCodes.UseDeviceCode(ctx, code.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			log.Info("device code already used")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	usr, err := a.usrProvider.UserByID(ctx, code.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	familyID, err := securetoken.New()
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	tokens, err := a.issueTokens(ctx, usr, app, familyID, 0, nil)
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("device logged in")
+	return tokens, nil
+}
+
+// newUserCode returns a random user code formatted as XXXX-XXXX.
+func newUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	var b strings.Builder
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// normalizeUserCode drops separators and case, users type codes loosely.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z':
+			return r
+		default:
+			return -1
+		}
+	}, code)
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authorizeDevice starts the device flow for the app.
+func (e *testEnv) authorizeDevice(t *testing.T, appID int) models.DeviceAuthorization {
+	t.Helper()
+
+	authorization, err := e.auth.AuthorizeDevice(context.Background(), appID, "")
+	if err != nil {
+		t.Fatalf("AuthorizeDevice(): %v", err)
+	}
+	return authorization
+}
+
+func TestPollDevice(t *testing.T) {
+	// devices of most cases poll as often as they like
+	noInterval := func(cfg *Config) { cfg.DeviceFlow.PollInterval = 0 }
+
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// prepare acts on the authorization before the device polls and
+		// returns the app and device code to poll with
+		prepare func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string)
+		wantErr error
+	}{
+		{
+			name:      "pending",
+			configure: noInterval,
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				return appID, authorization.DeviceCode
+			},
+			wantErr: ErrAuthorizationPending,
+		},
+		{
+			name: "polled too soon",
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				_, err := env.auth.PollDevice(context.Background(), appID, "", authorization.DeviceCode)
+				if !errors.Is(err, ErrAuthorizationPending) {
+					t.Fatalf("first PollDevice() error = %v, want %v", err, ErrAuthorizationPending)
+				}
+				return appID, authorization.DeviceCode
+			},
+			wantErr: ErrSlowDown,
+		},
+		{
+			name:      "approved",
+			configure: noInterval,
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				env.decideDevice(t, appID, authorization.UserCode, true)
+				return appID, authorization.DeviceCode
+			},
+		},
+		{
+			name:      "denied",
+			configure: noInterval,
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				env.decideDevice(t, appID, authorization.UserCode, false)
+				return appID, authorization.DeviceCode
+			},
+			wantErr: ErrAccessDenied,
+		},
+		{
+			name:      "used",
+			configure: noInterval,
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				env.decideDevice(t, appID, authorization.UserCode, true)
+				if _, err := env.auth.PollDevice(context.Background(), appID, "", authorization.DeviceCode); err != nil {
+					t.Fatalf("first PollDevice(): %v", err)
+				}
+				return appID, authorization.DeviceCode
+			},
+			wantErr: ErrInvalidGrant,
+		},
+		{
+			name: "expired",
+			configure: func(cfg *Config) {
+				cfg.DeviceFlow.TTL = -time.Minute
+			},
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				return appID, authorization.DeviceCode
+			},
+			wantErr: ErrDeviceCodeExpired,
+		},
+		{
+			name:      "unknown device code",
+			configure: noInterval,
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				return appID, "unknown"
+			},
+			wantErr: ErrInvalidGrant,
+		},
+		{
+			name:      "device code of another app",
+			configure: noInterval,
+			prepare: func(t *testing.T, env *testEnv, appID int, authorization models.DeviceAuthorization) (int, string) {
+				env.decideDevice(t, appID, authorization.UserCode, true)
+				return env.openApp(t).ID, authorization.DeviceCode
+			},
+			wantErr: ErrInvalidGrant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			appID, deviceCode := tt.prepare(t, env, app.ID, env.authorizeDevice(t, app.ID))
+
+			tokens, err := env.auth.PollDevice(context.Background(), appID, "", deviceCode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PollDevice() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			claims, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken(): %v", err)
+			}
+			if claims.UID != usr.ID || claims.AppID != app.ID {
+				t.Errorf("claims = %+v, want user %d in app %d", claims, usr.ID, app.ID)
+			}
+		})
+	}
+}
+
+// decideDevice approves or denies the device as user@example.com.
+func (e *testEnv) decideDevice(t *testing.T, appID int, userCode string, approve bool) {
+	t.Helper()
+
+	ctx := e.userContext(t, "user@example.com", appID)
+	if _, err := e.auth.ApproveDevice(ctx, userCode, approve); err != nil {
+		t.Fatalf("ApproveDevice(): %v", err)
+	}
+}
+
+func TestApproveDevice(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// userCode returns the code the user enters, given the shown one
+		userCode func(t *testing.T, env *testEnv, appID int, shown string) string
+		wantErr  error
+	}{
+		{
+			name:     "shown code",
+			userCode: func(t *testing.T, env *testEnv, appID int, shown string) string { return shown },
+		},
+		{
+			name: "code typed loosely",
+			userCode: func(t *testing.T, env *testEnv, appID int, shown string) string {
+				return " " + strings.ToLower(strings.ReplaceAll(shown, "-", ""))
+			},
+		},
+		{
+			name:     "unknown code",
+			userCode: func(t *testing.T, env *testEnv, appID int, shown string) string { return "BCDF-GHJK" },
+			wantErr:  ErrInvalidUserCode,
+		},
+		{
+			name: "decided code",
+			userCode: func(t *testing.T, env *testEnv, appID int, shown string) string {
+				env.decideDevice(t, appID, shown, false)
+				return shown
+			},
+			wantErr: ErrInvalidUserCode,
+		},
+		{
+			name:      "expired code",
+			configure: func(cfg *Config) { cfg.DeviceFlow.TTL = -time.Minute },
+			userCode:  func(t *testing.T, env *testEnv, appID int, shown string) string { return shown },
+			wantErr:   ErrExpiredToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			authorization := env.authorizeDevice(t, app.ID)
+			userCode := tt.userCode(t, env, app.ID, authorization.UserCode)
+
+			appID, err := env.auth.ApproveDevice(env.userContext(t, usr.Email, app.ID), userCode, true)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ApproveDevice() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && appID != app.ID {
+				t.Errorf("ApproveDevice() = %d, want %d", appID, app.ID)
+			}
+		})
+	}
+}
+
+func TestApproveDeviceWithoutCaller(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	authorization := env.authorizeDevice(t, app.ID)
+
+	if _, err := env.auth.ApproveDevice(context.Background(), authorization.UserCode, true); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ApproveDevice() error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestAuthorizeDevice(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		secret    string
+		wantErr   error
+	}{
+		{name: "public client"},
+		{name: "with client secret", secret: testAppSecret},
+		{name: "wrong client secret", secret: "wrong", wantErr: ErrInvalidClient},
+		{
+			name:      "no verification uri",
+			configure: func(cfg *Config) { cfg.DeviceFlow.VerificationURI = "" },
+			wantErr:   ErrDeviceFlowDisabled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+
+			authorization, err := env.auth.AuthorizeDevice(context.Background(), app.ID, tt.secret)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthorizeDevice() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			code := authorization.UserCode
+			if len(code) != userCodeLength+1 || code[userCodeLength/2] != '-' {
+				t.Errorf("user code %q is not formatted as XXXX-XXXX", code)
+			}
+			if strings.Trim(normalizeUserCode(code), userCodeAlphabet) != "" {
+				t.Errorf("user code %q has characters outside the alphabet", code)
+			}
+			want := "https://app.example.com/device?user_code=" + code
+			if authorization.VerificationURIComplete != want {
+				t.Errorf("VerificationURIComplete = %s, want %s", authorization.VerificationURIComplete, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// IntrospectToken tells the app, authenticated with its secret, whether
+// an access or refresh token is active. Tokens are active while they are
+// neither expired nor revoked and their user still exists. Only tokens
+// issued for the app itself are reported, others are inactive to it. The
+// hint only decides which kind of token is looked up first.
+func (a *Auth) IntrospectToken(ctx context.Context, appID int, secret string, token string, tokenTypeHint string) (models.TokenIntrospection, error) {
+	const op = "auth.IntrospectToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+	log.Info("introspecting token")
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found")
+			return models.TokenIntrospection{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+		return models.TokenIntrospection{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.authenticateClient(log, app, secret); err != nil {
+		return models.TokenIntrospection{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	lookups := []func(context.Context, models.App, string) (models.TokenIntrospection, error){
+		a.introspectAccessToken,
+		a.introspectRefreshToken,
+	}
+	if tokenTypeHint == models.TokenTypeRefresh {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		info, err := lookup(ctx, app, token)
+		if err != nil {
+			return models.TokenIntrospection{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if info.Active {
+			log.Info("token is active", slog.String("token_type", info.TokenType))
+			return info, nil
+		}
+	}
+	log.Info("token is not active")
+	return models.TokenIntrospection{}, nil
+}
+
+// introspectAccessToken reports an access token, errors only if the check
+// itself failed.
+func (a *Auth) introspectAccessToken(ctx context.Context, app models.App, token string) (models.TokenIntrospection, error) {
+	claims, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrExpiredToken) {
+			return models.TokenIntrospection{}, nil
+		}
+		return models.TokenIntrospection{}, err
+	}
+	if claims.AppID != app.ID {
+		return models.TokenIntrospection{}, nil
+	}
+
+	info := models.TokenIntrospection{
+		Active:    true,
+		TokenType: models.TokenTypeAccess,
+		ClientID:  claims.AppID,
+		Scopes:    claims.Scopes,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+	}
+	if claims.IsClient() {
+		info.Subject = strconv.Itoa(claims.ClientID)
+		return info, nil
+	}
+
+	exists, err := a.userExists(ctx, claims.UID)
+	if err != nil || !exists {
+		return models.TokenIntrospection{}, err
+	}
+	info.Subject = strconv.FormatInt(claims.UID, 10)
+	return info, nil
+}
+
+// introspectRefreshToken reports a refresh token, errors only if the
+// check itself failed.
+func (a *Auth) introspectRefreshToken(ctx context.Context, app models.App, token string) (models.TokenIntrospection, error) {
+	refresh, err := a.refreshTokens.RefreshToken(ctx, securetoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			return models.TokenIntrospection{}, nil
+		}
+		return models.TokenIntrospection{}, err
+	}
+	if refresh.AppID != app.ID || refresh.Rotated || refresh.Revoked || time.Now().After(refresh.ExpiresAt) {
+		return models.TokenIntrospection{}, nil
+	}
+
+	exists, err := a.userExists(ctx, refresh.UserID)
+	if err != nil || !exists {
+		return models.TokenIntrospection{}, err
+	}
+	return models.TokenIntrospection{
+		Active:    true,
+		TokenType: models.TokenTypeRefresh,
+		Subject:   strconv.FormatInt(refresh.UserID, 10),
+		ClientID:  refresh.AppID,
+		Scopes:    refresh.Scopes,
+		ExpiresAt: refresh.ExpiresAt,
+	}, nil
+}
+
+func (a *Auth) userExists(ctx context.Context, userID int64) (bool, error) {
+	if _, err := a.usrProvider.UserByID(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntrospectToken(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.createApp(t, models.App{AllowSelfRegistration: true, Scopes: []string{"orders:read"}})
+	other := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	subject := strconv.FormatInt(usr.ID, 10)
+
+	tests := []struct {
+		name string
+		// token returns the token to introspect
+		token         func(t *testing.T) string
+		hint          string
+		wantActive    bool
+		wantType      string
+		wantSubject   string
+		wantScopes    []string
+		wantIssuedAt  bool
+		wantExpiresAt bool
+	}{
+		{
+			name:          "access token",
+			token:         func(t *testing.T) string { return env.login(t, usr.Email, app.ID).AccessToken },
+			wantActive:    true,
+			wantType:      models.TokenTypeAccess,
+			wantSubject:   subject,
+			wantIssuedAt:  true,
+			wantExpiresAt: true,
+		},
+		{
+			name:          "access token with refresh hint",
+			token:         func(t *testing.T) string { return env.login(t, usr.Email, app.ID).AccessToken },
+			hint:          models.TokenTypeRefresh,
+			wantActive:    true,
+			wantType:      models.TokenTypeAccess,
+			wantSubject:   subject,
+			wantIssuedAt:  true,
+			wantExpiresAt: true,
+		},
+		{
+			name:          "refresh token",
+			token:         func(t *testing.T) string { return env.login(t, usr.Email, app.ID).RefreshToken },
+			wantActive:    true,
+			wantType:      models.TokenTypeRefresh,
+			wantSubject:   subject,
+			wantExpiresAt: true,
+		},
+		{
+			name: "client token",
+			token: func(t *testing.T) string {
+				token, err := env.auth.IssueClientToken(context.Background(), app.ID, testAppSecret, nil)
+				if err != nil {
+					t.Fatalf("IssueClientToken(): %v", err)
+				}
+				return token.AccessToken
+			},
+			wantActive:    true,
+			wantType:      models.TokenTypeAccess,
+			wantSubject:   strconv.Itoa(app.ID),
+			wantScopes:    []string{"orders:read"},
+			wantIssuedAt:  true,
+			wantExpiresAt: true,
+		},
+		{
+			name:  "expired access token",
+			token: func(t *testing.T) string { return env.signToken(t, usr, app, -time.Minute) },
+		},
+		{
+			name: "revoked access token",
+			token: func(t *testing.T) string {
+				tokens := env.login(t, usr.Email, app.ID)
+				if err := env.auth.RevokeToken(context.Background(), tokens.AccessToken); err != nil {
+					t.Fatalf("RevokeToken(): %v", err)
+				}
+				return tokens.AccessToken
+			},
+		},
+		{
+			name: "rotated refresh token",
+			token: func(t *testing.T) string {
+				tokens := env.login(t, usr.Email, app.ID)
+				if _, err := env.auth.Refresh(context.Background(), tokens.RefreshToken); err != nil {
+					t.Fatalf("Refresh(): %v", err)
+				}
+				return tokens.RefreshToken
+			},
+			hint: models.TokenTypeRefresh,
+		},
+		{
+			name:  "access token of another app",
+			token: func(t *testing.T) string { return env.login(t, usr.Email, other.ID).AccessToken },
+		},
+		{
+			name:  "refresh token of another app",
+			token: func(t *testing.T) string { return env.login(t, usr.Email, other.ID).RefreshToken },
+		},
+		{
+			name:  "unknown token",
+			token: func(t *testing.T) string { return "unknown" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := env.auth.IntrospectToken(context.Background(), app.ID, testAppSecret, tt.token(t), tt.hint)
+			if err != nil {
+				t.Fatalf("IntrospectToken(): %v", err)
+			}
+			if !tt.wantActive {
+				if !reflect.DeepEqual(info, models.TokenIntrospection{}) {
+					t.Errorf("IntrospectToken() = %+v, want an inactive token without details", info)
+				}
+				return
+			}
+			if !info.Active || info.TokenType != tt.wantType || info.Subject != tt.wantSubject || info.ClientID != app.ID {
+				t.Errorf("IntrospectToken() = %+v, want active %s of %s for app %d", info, tt.wantType, tt.wantSubject, app.ID)
+			}
+			if strings.Join(info.Scopes, " ") != strings.Join(tt.wantScopes, " ") {
+				t.Errorf("IntrospectToken() scopes = %v, want %v", info.Scopes, tt.wantScopes)
+			}
+			if info.IssuedAt.IsZero() == tt.wantIssuedAt || info.ExpiresAt.IsZero() == tt.wantExpiresAt {
+				t.Errorf("IntrospectToken() iat = %v, exp = %v", info.IssuedAt, info.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestIntrospectTokenClientAuthentication(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	token := env.login(t, usr.Email, app.ID).AccessToken
+
+	tests := []struct {
+		name   string
+		appID  int
+		secret string
+	}{
+		{name: "wrong secret", appID: app.ID, secret: "wrong"},
+		{name: "no secret", appID: app.ID},
+		{name: "unknown app", appID: app.ID + 100, secret: testAppSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := env.auth.IntrospectToken(context.Background(), tt.appID, tt.secret, token, ""); !errors.Is(err, ErrInvalidClient) {
+				t.Errorf("IntrospectToken() error = %v, want %v", err, ErrInvalidClient)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var ErrKeyRotationDisabled = errors.New("signing keys are loaded from disk and can't be rotated")
+
+type SigningKeyStorage interface {
+	SaveSigningKey(ctx context.Context, key models.SigningKey) error
+	SigningKey(ctx context.Context, kid string) (models.SigningKey, error)
+	ActiveSigningKey(ctx context.Context, appID int) (models.SigningKey, error)
+	SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error)
+	ActivateSigningKey(ctx context.Context, kid string, now time.Time, retireUntil time.Time) error
+	RevokeSigningKey(ctx context.Context, kid string) error
+	RevokeExpiredSigningKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+// JWKS returns public keys tokens of the app are verified with, or keys of
+// all apps if appID is zero. Pending keys are published ahead of use so
+// that resource servers have them cached by the time they sign tokens.
+func (a *Auth) JWKS(ctx context.Context, appID int) (jwt.JWKS, error) {
+	const op = "auth.JWKS"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+	log.Info("listing public keys")
+
+	jwks := jwt.JWKS{Keys: []jwt.JWK{}}
+	if a.fileKey != nil {
+		if jwk, ok := a.fileKey.JWK(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	stored, err := a.signingKeys.SigningKeys(ctx, appID)
+	if err != nil {
+		return jwt.JWKS{}, fmt.Errorf("%s: %w", op, err)
+	}
+	for _, s := range stored {
+		if !jwt.IsAsymmetric(s.Algorithm) {
+			continue
+		}
+		key, err := jwt.ParsePrivateKey(s.ID, s.Algorithm, s.PrivateKey)
+		if err != nil {
+			log.Error("failed to parse signing key", slog.String("kid", s.ID), slog.String("error", err.Error()))
+			continue
+		}
+		if jwk, ok := key.JWK(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks, nil
+}
+
+// RotateSigningKeys immediately replaces the active signing key of the app
+// with a new one. Tokens signed with the previous key keep validating
+// until they expire, unless revokePrevious is set, e.g. when the key has
+// been compromised. Rotation requires the keys:rotate permission.
+func (a *Auth) RotateSigningKeys(ctx context.Context, appID int, revokePrevious bool) (string, error) {
+	const op = "auth.RotateSigningKeys"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	if err := a.authorize(ctx, PermRotateKeys, appID); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if a.fileKey != nil {
+		return "", fmt.Errorf("%s: %w", op, ErrKeyRotationDisabled)
+	}
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("rotating signing keys", slog.Bool("revoke_previous", revokePrevious))
+
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
+	keys, err := a.signingKeys.SigningKeys(ctx, appID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	key, err := a.generateSigningKey(ctx, appID, now)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.signingKeys.ActivateSigningKey(ctx, key.ID, now, now.Add(a.tokenTTL)); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// keys prepared by the schedule are superseded by the new one
+	for _, k := range keys {
+		if k.Status == models.SigningKeyPending || revokePrevious {
+			if err := a.signingKeys.RevokeSigningKey(ctx, k.ID); err != nil {
+				return "", fmt.Errorf("%s: %w", op, err)
+			}
+			log.Info("signing key revoked", slog.String("kid", k.ID), slog.String("status", k.Status))
+		}
+	}
+
+	log.Info("signing keys rotated", slog.String("kid", key.ID))
+	return key.ID, nil
+}
+
+// RotateExpiredSigningKeys moves the keys of every app along their
+// lifecycle: publishes a pending key ahead of the end of the rotation
+// period, activates it when the period ends and revokes retiring keys
+// once tokens they signed have expired. It is run on a schedule.
+func (a *Auth) RotateExpiredSigningKeys(ctx context.Context) error {
+	const op = "auth.RotateExpiredSigningKeys"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if a.fileKey != nil {
+		return nil
+	}
+
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
+	now := time.Now()
+
+	revoked, err := a.signingKeys.RevokeExpiredSigningKeys(ctx, now)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if revoked > 0 {
+		log.Info("retiring signing keys revoked", slog.Int64("count", revoked))
+	}
+
+	keys, err := a.signingKeys.SigningKeys(ctx, 0)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	byApp := make(map[int][]models.SigningKey)
+	for _, k := range keys {
+		byApp[k.AppID] = append(byApp[k.AppID], k)
+	}
+
+	for appID, appKeys := range byApp {
+		if _, err := a.advanceAppKeys(ctx, appID, appKeys, now); err != nil {
+			log.Error("failed to rotate signing keys", slog.Int("app_id", appID), slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+// advanceAppKeys performs the due lifecycle steps for the keys of one app
+// and returns its active key. keyMu must be held.
+func (a *Auth) advanceAppKeys(ctx context.Context, appID int, keys []models.SigningKey, now time.Time) (models.SigningKey, error) {
+	var active, pending *models.SigningKey
+	for i := range keys {
+		k := &keys[i]
+		switch k.Status {
+		case models.SigningKeyActive:
+			if active == nil || k.ActivatesAt.After(active.ActivatesAt) {
+				active = k
+			}
+		case models.SigningKeyPending:
+			if pending == nil || k.CreatedAt.After(pending.CreatedAt) {
+				pending = k
+			}
+		}
+	}
+	if pending != nil && pending.Algorithm != a.signingAlg {
+		pending = nil
+	}
+
+	log := a.log.With(slog.Int("app_id", appID))
+
+	switch {
+	case active == nil || active.Algorithm != a.signingAlg:
+		// nothing usable signs tokens yet, activate right away
+		if pending == nil {
+			key, err := a.generateSigningKey(ctx, appID, now)
+			if err != nil {
+				return models.SigningKey{}, err
+			}
+			pending = &key
+		}
+		pending.ActivatesAt = now
+	case pending == nil && !now.Before(active.ActivatesAt.Add(a.rotationPeriod-a.prepublishPeriod)):
+		key, err := a.generateSigningKey(ctx, appID, active.ActivatesAt.Add(a.rotationPeriod))
+		if err != nil {
+			return models.SigningKey{}, err
+		}
+		log.Info("signing key published", slog.String("kid", key.ID), slog.Time("activates_at", key.ActivatesAt))
+		pending = &key
+	}
+
+	if pending == nil || now.Before(pending.ActivatesAt) {
+		return *active, nil
+	}
+	if err := a.signingKeys.ActivateSigningKey(ctx, pending.ID, now, now.Add(a.tokenTTL)); err != nil {
+		return models.SigningKey{}, err
+	}
+	log.Info("signing key activated", slog.String("kid", pending.ID))
+
+	pending.Status = models.SigningKeyActive
+	pending.ActivatesAt = now
+	return *pending, nil
+}
+
+// generateSigningKey saves a new pending key of the configured algorithm.
+func (a *Auth) generateSigningKey(ctx context.Context, appID int, activatesAt time.Time) (models.SigningKey, error) {
+	key, err := jwt.GenerateSigningKey(a.signingAlg)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	stored := models.SigningKey{
+		ID:          key.ID,
+		AppID:       appID,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  der,
+		Status:      models.SigningKeyPending,
+		CreatedAt:   time.Now(),
+		ActivatesAt: activatesAt,
+	}
+	if err := a.signingKeys.SaveSigningKey(ctx, stored); err != nil {
+		return models.SigningKey{}, err
+	}
+	return stored, nil
+}
+
+// signingKey returns the key new tokens of the app are signed with. The
+// first key of an app is generated on first use.
+func (a *Auth) signingKey(ctx context.Context, app models.App) (jwt.SigningKey, error) {
+	if a.fileKey != nil {
+		return *a.fileKey, nil
+	}
+
+	stored, err := a.signingKeys.ActiveSigningKey(ctx, app.ID)
+	if err != nil && !errors.Is(err, storage.ErrSigningKeyNotFound) {
+		return jwt.SigningKey{}, err
+	}
+	if err != nil || stored.Algorithm != a.signingAlg {
+		stored, err = a.activateFirstKey(ctx, app.ID)
+		if err != nil {
+			return jwt.SigningKey{}, err
+		}
+	}
+	return jwt.ParsePrivateKey(stored.ID, stored.Algorithm, stored.PrivateKey)
+}
+
+func (a *Auth) activateFirstKey(ctx context.Context, appID int) (models.SigningKey, error) {
+	a.keyMu.Lock()
+	defer a.keyMu.Unlock()
+
+	keys, err := a.signingKeys.SigningKeys(ctx, appID)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	return a.advanceAppKeys(ctx, appID, keys, time.Now())
+}
+
+// verificationKeys resolves the keys a token may have been signed with.
+// Every key issued by the service has a kid, tokens without one are never
+// verified: the app secret is known to the app and can't vouch for a user.
+func (a *Auth) verificationKeys(ctx context.Context, kid string, appID int) ([]jwt.SigningKey, error) {
+	if kid == "" {
+		return nil, fmt.Errorf("%w: kid header is missing", jwt.ErrInvalidToken)
+	}
+
+	key, err := a.verificationKey(ctx, kid, appID)
+	if err != nil {
+		return nil, err
+	}
+	return []jwt.SigningKey{key}, nil
+}
+
+// verificationKey resolves the key with the kid a token was signed with.
+func (a *Auth) verificationKey(ctx context.Context, kid string, appID int) (jwt.SigningKey, error) {
+	if a.fileKey != nil && a.fileKey.ID == kid {
+		return *a.fileKey, nil
+	}
+
+	stored, err := a.signingKeys.SigningKey(ctx, kid)
+	if err != nil {
+		if errors.Is(err, storage.ErrSigningKeyNotFound) {
+			return jwt.SigningKey{}, fmt.Errorf("%w: unknown key %s", jwt.ErrInvalidToken, kid)
+		}
+		return jwt.SigningKey{}, err
+	}
+	if stored.AppID != appID {
+		return jwt.SigningKey{}, fmt.Errorf("%w: key %s belongs to another app", jwt.ErrInvalidToken, kid)
+	}
+	switch stored.Status {
+	case models.SigningKeyActive:
+	case models.SigningKeyRetiring:
+		if time.Now().After(stored.ExpiresAt) {
+			return jwt.SigningKey{}, fmt.Errorf("%w: key %s is retired", jwt.ErrInvalidToken, kid)
+		}
+	default:
+		return jwt.SigningKey{}, fmt.Errorf("%w: key %s is %s", jwt.ErrInvalidToken, kid, stored.Status)
+	}
+	return jwt.ParsePrivateKey(stored.ID, stored.Algorithm, stored.PrivateKey)
+}
+
+func (a *Auth) parseToken(ctx context.Context, token string) (jwt.Claims, error) {
+	return jwt.ParseToken(token, func(kid string, appID int) ([]jwt.SigningKey, error) {
+		return a.verificationKeys(ctx, kid, appID)
+	}, jwt.ParseOptions{Issuer: a.issuer, Leeway: a.tokenLeeway})
+}
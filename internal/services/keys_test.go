@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJWKS(t *testing.T) {
+	tests := []struct {
+		alg      string
+		wantKeys int
+	}{
+		{alg: jwt.AlgHS256, wantKeys: 0},
+		{alg: jwt.AlgRS256, wantKeys: 1},
+		{alg: jwt.AlgES256, wantKeys: 1},
+		{alg: jwt.AlgEdDSA, wantKeys: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			env := newTestEnv(t, func(cfg *Config) { cfg.SigningAlgorithm = tt.alg })
+			app := env.openApp(t)
+			key, err := env.auth.signingKey(context.Background(), app)
+			if err != nil {
+				t.Fatalf("signing key: %v", err)
+			}
+
+			jwks, err := env.auth.JWKS(context.Background(), app.ID)
+			if err != nil {
+				t.Fatalf("JWKS(): %v", err)
+			}
+			if len(jwks.Keys) != tt.wantKeys {
+				t.Fatalf("JWKS() returned %d keys, want %d", len(jwks.Keys), tt.wantKeys)
+			}
+			for _, jwk := range jwks.Keys {
+				if jwk.Kid != key.ID || jwk.Alg != tt.alg {
+					t.Errorf("JWKS() key = %+v, want kid %s of %s", jwk, key.ID, tt.alg)
+				}
+			}
+		})
+	}
+}
+
+func TestRotateSigningKeys(t *testing.T) {
+	tests := []struct {
+		name           string
+		revokePrevious bool
+		wantOldErr     error
+	}{
+		{name: "previous key retires"},
+		{name: "previous key revoked", revokePrevious: true, wantOldErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			ctx := env.adminContext(t, app.ID)
+			usr := env.registerUser(t, "user@example.com")
+			old := env.login(t, usr.Email, app.ID)
+			oldKey, err := env.auth.signingKey(context.Background(), app)
+			if err != nil {
+				t.Fatalf("signing key: %v", err)
+			}
+
+			kid, err := env.auth.RotateSigningKeys(ctx, app.ID, tt.revokePrevious)
+			if err != nil {
+				t.Fatalf("RotateSigningKeys(): %v", err)
+			}
+			if kid == oldKey.ID {
+				t.Fatal("RotateSigningKeys() kept the previous key")
+			}
+			newKey, err := env.auth.signingKey(context.Background(), app)
+			if err != nil {
+				t.Fatalf("signing key: %v", err)
+			}
+			if newKey.ID != kid {
+				t.Errorf("tokens are signed with %s, want %s", newKey.ID, kid)
+			}
+
+			if _, err := env.auth.ValidateToken(context.Background(), old.AccessToken); !errors.Is(err, tt.wantOldErr) {
+				t.Errorf("ValidateToken() of token signed before rotation error = %v, want %v", err, tt.wantOldErr)
+			}
+			fresh := env.login(t, usr.Email, app.ID)
+			if _, err := env.auth.ValidateToken(context.Background(), fresh.AccessToken); err != nil {
+				t.Errorf("ValidateToken() of token signed after rotation: %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateSigningKeysDenied(t *testing.T) {
+	fileKey, err := jwt.GenerateSigningKey(jwt.AlgES256)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey(): %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		admin     bool
+		wantErr   error
+	}{
+		{name: "not an admin", wantErr: ErrPermissionDenied},
+		{
+			name:      "keys loaded from disk",
+			configure: func(cfg *Config) { cfg.SigningKey = &fileKey },
+			admin:     true,
+			wantErr:   ErrKeyRotationDisabled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			var ctx context.Context
+			if tt.admin {
+				ctx = env.adminContext(t, app.ID)
+			} else {
+				env.registerUser(t, "user@example.com")
+				ctx = env.userContext(t, "user@example.com", app.ID)
+			}
+
+			if _, err := env.auth.RotateSigningKeys(ctx, app.ID, false); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RotateSigningKeys() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSigningKeySchedule(t *testing.T) {
+	const (
+		rotation   = 30 * 24 * time.Hour
+		prepublish = 24 * time.Hour
+	)
+	env := newTestEnv(t, func(cfg *Config) {
+		cfg.KeyRotationPeriod = rotation
+		cfg.KeyPrepublishPeriod = prepublish
+	})
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	old := env.login(t, usr.Email, app.ID)
+	first, err := env.storage.ActiveSigningKey(context.Background(), app.ID)
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+
+	// the steps run in order, each one at an offset from the activation
+	// of the first key
+	steps := []struct {
+		name          string
+		at            time.Duration
+		wantRotated   bool
+		wantPublished int
+	}{
+		{name: "before prepublish", at: rotation - prepublish - time.Minute, wantPublished: 1},
+		{name: "next key published", at: rotation - prepublish, wantPublished: 2},
+		{name: "still published", at: rotation - time.Minute, wantPublished: 2},
+		{name: "next key activated", at: rotation, wantRotated: true, wantPublished: 2},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			keys, err := env.storage.SigningKeys(context.Background(), app.ID)
+			if err != nil {
+				t.Fatalf("signing keys: %v", err)
+			}
+			active, err := env.auth.advanceAppKeys(context.Background(), app.ID, keys, first.ActivatesAt.Add(step.at))
+			if err != nil {
+				t.Fatalf("advanceAppKeys(): %v", err)
+			}
+			if rotated := active.ID != first.ID; rotated != step.wantRotated {
+				t.Errorf("active key rotated = %v, want %v", rotated, step.wantRotated)
+			}
+			jwks, err := env.auth.JWKS(context.Background(), app.ID)
+			if err != nil {
+				t.Fatalf("JWKS(): %v", err)
+			}
+			if len(jwks.Keys) != step.wantPublished {
+				t.Errorf("JWKS() returned %d keys, want %d", len(jwks.Keys), step.wantPublished)
+			}
+		})
+	}
+
+	// tokens signed with the retiring key are accepted until they expire
+	if _, err := env.auth.ValidateToken(context.Background(), old.AccessToken); err != nil {
+		t.Errorf("ValidateToken() of token signed with the retiring key: %v", err)
+	}
+	retired := first.ActivatesAt.Add(rotation + 2*env.auth.tokenTTL)
+	if _, err := env.storage.RevokeExpiredSigningKeys(context.Background(), retired); err != nil {
+		t.Fatalf("revoke expired keys: %v", err)
+	}
+	if _, err := env.auth.ValidateToken(context.Background(), old.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken() of token signed with the retired key error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestValidateTokenWithoutKid(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) { cfg.SigningAlgorithm = jwt.AlgHS256 })
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	// the app knows its secret, a token it signs must not pass for one
+	// issued by the service
+	secretKey := jwt.SigningKey{Algorithm: jwt.AlgHS256, Key: []byte(testAppSecret)}
+	token, err := jwt.NewToken(testIssuer, usr, app, models.Access{}, nil, secretKey, time.Hour)
+	if err != nil {
+		t.Fatalf("NewToken(): %v", err)
+	}
+	if _, err := env.auth.ValidateToken(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken() error = %v, want %v", err, ErrInvalidToken)
+	}
+}
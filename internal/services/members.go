@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// PermManageMembers allows to add, invite and remove members of an app.
+const PermManageMembers = "members:manage"
+
+var ErrNotMember = errors.New("user is not a member of the app")
+
+type MembershipStorage interface {
+	AddMember(ctx context.Context, userID int64, appID int) error
+	RemoveMember(ctx context.Context, userID int64, appID int) error
+	IsMember(ctx context.Context, userID int64, appID int) (bool, error)
+	SaveInvitation(ctx context.Context, invitation models.AppInvitation) (int64, error)
+	Invitation(ctx context.Context, tokenHash []byte) (models.AppInvitation, error)
+	AcceptInvitation(ctx context.Context, invitationID int64, userID int64) error
+}
+
+// InviteMember mails an invitation to join the app to the email. The
+// address doesn't have to be registered yet, the invitation is accepted
+// by the user registered with it.
+func (a *Auth) InviteMember(ctx context.Context, appID int, email string) error {
+	const op = "auth.InviteMember"
+
+	email = normalizeEmail(email)
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	if err := a.authorize(ctx, PermManageMembers, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("inviting member")
+
+	invitationToken, err := securetoken.New()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	expiresAt := time.Now().Add(a.invitationTTL)
+
+	_, err = a.members.SaveInvitation(ctx, models.AppInvitation{
+		TokenHash: securetoken.Hash(invitationToken),
+		AppID:     appID,
+		Email:     email,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	body := fmt.Sprintf("You have been invited to join %s. Use this token to accept the invitation:\n\n%s\n\n"+
+		"The token expires at %s.", app.Name, invitationToken, expiresAt.UTC().Format(time.RFC1123))
+	if err := a.mailer.Send(ctx, email, "Invitation to "+app.Name, body); err != nil {
+		log.Error("failed to send invitation", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("invitation sent")
+	return nil
+}
+
+// AcceptInvitation makes the user registered with the invited email a
+// member of the app and returns the app id.
+func (a *Auth) AcceptInvitation(ctx context.Context, token string) (int, error) {
+	const op = "auth.AcceptInvitation"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("accepting invitation")
+
+	invitation, err := a.members.Invitation(ctx, securetoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("invitation not found")
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int("app_id", invitation.AppID))
+
+	if invitation.Used {
+		log.Info("invitation already used")
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		log.Info("invitation expired")
+		return 0, fmt.Errorf("%s: %w", op, ErrExpiredToken)
+	}
+
+	// the invitation is kept for a user who is yet to register
+	usr, err := a.usrProvider.User(ctx, invitation.Email)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.members.AcceptInvitation(ctx, invitation.ID, usr.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			log.Info("invitation already used")
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("invitation accepted", slog.Int64("user_id", usr.ID))
+	return invitation.AppID, nil
+}
+
+// AddMember makes the user a member of the app.
+func (a *Auth) AddMember(ctx context.Context, appID int, userID int64) error {
+	const op = "auth.AddMember"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.Int64("user_id", userID),
+	)
+
+	if err := a.authorize(ctx, PermManageMembers, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkRoleScope(ctx, userID, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("adding member")
+
+	if err := a.members.AddMember(ctx, userID, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("member added")
+	return nil
+}
+
+// RemoveMember removes the user from the app together with the roles
+// granted in it and signs the user out of the app.
+func (a *Auth) RemoveMember(ctx context.Context, appID int, userID int64) error {
+	const op = "auth.RemoveMember"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.Int64("user_id", userID),
+	)
+
+	if err := a.authorize(ctx, PermManageMembers, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("removing member")
+
+	if err := a.members.RemoveMember(ctx, userID, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// access tokens can't be revoked per app, the user has to refresh
+	// tokens of other apps
+	now := time.Now()
+	if err := a.revocations.RevokeUserTokens(ctx, userID, now, now.Add(a.tokenTTL)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("member removed")
+	return nil
+}
+
+// joinApp makes sure the user is a member of the app, adding it on first
+// login if the app allows self-registration.
+func (a *Auth) joinApp(ctx context.Context, usr models.User, app models.App) error {
+	isMember, err := a.members.IsMember(ctx, usr.ID, app.ID)
+	if err != nil {
+		return err
+	}
+	if isMember {
+		return nil
+	}
+	if !app.AllowSelfRegistration {
+		a.log.Info("user is not a member of the app", slog.Int64("user_id", usr.ID), slog.Int("app_id", app.ID))
+		return ErrNotMember
+	}
+	return a.members.AddMember(ctx, usr.ID, app.ID)
+}
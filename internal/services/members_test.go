@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginMembership(t *testing.T) {
+	tests := []struct {
+		name      string
+		app       models.App
+		addMember bool
+		wantErr   error
+	}{
+		{name: "self registration", app: models.App{AllowSelfRegistration: true}},
+		{name: "not a member", app: models.App{}, wantErr: ErrNotMember},
+		{name: "added member", app: models.App{}, addMember: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.createApp(t, tt.app)
+			usr := env.registerUser(t, "user@example.com")
+			if tt.addMember {
+				admin := env.adminContext(t, env.openApp(t).ID)
+				if err := env.auth.AddMember(admin, app.ID, usr.ID); err != nil {
+					t.Fatalf("AddMember(): %v", err)
+				}
+			}
+
+			if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	const invited = "invited@example.com"
+
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		register  bool
+		// token returns the token to accept, given the mailed one
+		token   func(t *testing.T, env *testEnv, mailed string) string
+		wantErr error
+	}{
+		{
+			name:     "registered after the invitation",
+			register: true,
+			token:    func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+		},
+		{
+			name:    "not registered",
+			token:   func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+			wantErr: storage.ErrUserNotFound,
+		},
+		{
+			name:     "unknown token",
+			register: true,
+			token:    func(t *testing.T, env *testEnv, mailed string) string { return "unknown" },
+			wantErr:  ErrInvalidToken,
+		},
+		{
+			name:      "expired token",
+			configure: func(cfg *Config) { cfg.InvitationTTL = -time.Minute },
+			register:  true,
+			token:     func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+			wantErr:   ErrExpiredToken,
+		},
+		{
+			name:     "used token",
+			register: true,
+			token: func(t *testing.T, env *testEnv, mailed string) string {
+				if _, err := env.auth.AcceptInvitation(context.Background(), mailed); err != nil {
+					t.Fatalf("first AcceptInvitation(): %v", err)
+				}
+				return mailed
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.createApp(t, models.App{})
+			admin := env.adminContext(t, env.openApp(t).ID)
+
+			if err := env.auth.InviteMember(admin, app.ID, invited); err != nil {
+				t.Fatalf("InviteMember(): %v", err)
+			}
+			mailed := env.mailer.lastSecret(t, invited)
+			if tt.register {
+				env.registerUser(t, invited)
+			}
+
+			appID, err := env.auth.AcceptInvitation(context.Background(), tt.token(t, env, mailed))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AcceptInvitation() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if appID != app.ID {
+				t.Errorf("AcceptInvitation() app = %d, want %d", appID, app.ID)
+			}
+			if _, err := env.auth.Login(context.Background(), invited, testPassword, app.ID, ""); err != nil {
+				t.Errorf("Login() after accepting the invitation: %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.createApp(t, models.App{})
+	admin := env.adminContext(t, env.openApp(t).ID)
+	usr := env.registerUser(t, "user@example.com")
+	if err := env.auth.AddMember(admin, app.ID, usr.ID); err != nil {
+		t.Fatalf("AddMember(): %v", err)
+	}
+	tokens := env.login(t, usr.Email, app.ID)
+	waitNextSecond()
+
+	if err := env.auth.RemoveMember(admin, app.ID, usr.ID); err != nil {
+		t.Fatalf("RemoveMember(): %v", err)
+	}
+	if _, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken() error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := env.auth.Refresh(context.Background(), tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Refresh() error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); !errors.Is(err, ErrNotMember) {
+		t.Errorf("Login() error = %v, want %v", err, ErrNotMember)
+	}
+}
+
+func TestMembershipRequiresPermission(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	ctx := env.userContext(t, usr.Email, app.ID)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "invite", call: func() error { return env.auth.InviteMember(ctx, app.ID, "invited@example.com") }},
+		{name: "add", call: func() error { return env.auth.AddMember(ctx, app.ID, usr.ID) }},
+		{name: "remove", call: func() error { return env.auth.RemoveMember(ctx, app.ID, usr.ID) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("error = %v, want %v", err, ErrPermissionDenied)
+			}
+		})
+	}
+}
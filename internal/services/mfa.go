@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"auth/internal/lib/principal"
+	"auth/internal/lib/securetoken"
+	"auth/internal/lib/totp"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrMFADisabled    = errors.New("mfa is not configured")
+	ErrMFAEnabled     = errors.New("mfa is already enabled")
+	ErrMFANotEnrolled = errors.New("mfa is not enrolled")
+	ErrInvalidMFACode = errors.New("invalid mfa code")
+)
+
+type MFAStorage interface {
+	SaveTOTP(ctx context.Context, userID int64, secret []byte) error
+	TOTP(ctx context.Context, userID int64) (models.TOTP, error)
+	UseTOTPStep(ctx context.Context, userID int64, step int64, confirm bool) error
+	SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) (int64, error)
+	MFAChallenge(ctx context.Context, tokenHash []byte) (models.MFAChallenge, error)
+	UseMFAChallenge(ctx context.Context, challengeID int64) error
+}
+
+// EnrollTOTP generates a new authenticator app secret for the caller and
+// returns it encoded in base32 along with its otpauth URI. MFA is enabled
+// once a code of the secret is confirmed with ConfirmTOTP, until then the
+// secret may be enrolled again.
+func (a *Auth) EnrollTOTP(ctx context.Context) (string, string, error) {
+	const op = "auth.EnrollTOTP"
+
+	claims, ok := principal.FromContext(ctx)
+	if !ok {
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", claims.UID),
+	)
+	if a.mfaCipher == nil {
+		return "", "", fmt.Errorf("%s: %w", op, ErrMFADisabled)
+	}
+	log.Info("enrolling totp")
+
+	usr, err := a.usrProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	encrypted, err := a.mfaCipher.Encrypt(secret, userIDBytes(usr.ID))
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.mfa.SaveTOTP(ctx, usr.ID, encrypted); err != nil {
+		if errors.Is(err, storage.ErrTOTPConfirmed) {
+			log.Info("totp already enabled")
+			return "", "", fmt.Errorf("%s: %w", op, ErrMFAEnabled)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("totp enrolled")
+	return totp.EncodeSecret(secret), totp.URI(a.mfaIssuer, usr.Email, secret), nil
+}
+
+// ConfirmTOTP enables MFA of the caller with a code of the enrolled secret.
+func (a *Auth) ConfirmTOTP(ctx context.Context, code string) error {
+	const op = "auth.ConfirmTOTP"
+
+	claims, ok := principal.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", claims.UID),
+	)
+	log.Info("confirming totp")
+
+	secret, err := a.userTOTP(ctx, claims.UID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if secret.Confirmed {
+		return fmt.Errorf("%s: %w", op, ErrMFAEnabled)
+	}
+	if err := a.useTOTPCode(ctx, secret, code, true); err != nil {
+		log.Info("invalid totp code", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("totp confirmed")
+	return nil
+}
+
+// VerifyMFA completes the login of an MFA challenge with a code of the
+// authenticator app. Wrong codes are throttled the same way as wrong
+// passwords.
+func (a *Auth) VerifyMFA(ctx context.Context, mfaToken string, code string, clientIP string) (models.TokenPair, error) {
+	const op = "auth.VerifyMFA"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("verifying mfa")
+
+	usr, challenge, err := a.passMFAChallenge(ctx, log, mfaToken, clientIP, func(usr models.User) error {
+		secret, err := a.userTOTP(ctx, usr.ID)
+		if err != nil {
+			return err
+		}
+		if err := a.useTOTPCode(ctx, secret, code, false); err != nil {
+			if errors.Is(err, ErrInvalidMFACode) {
+				log.Info("invalid totp code")
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.completeLogin(ctx, log, usr, challenge.AppID)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return tokens, nil
+}
+
+// passMFAChallenge uses up an MFA challenge once verify accepts the second
+// factor of its user and returns the user with the challenge.
+// ErrInvalidMFACode returned by verify is throttled the same way as wrong
+// passwords.
+func (a *Auth) passMFAChallenge(ctx context.Context, log *slog.Logger, mfaToken string, clientIP string, verify func(usr models.User) error) (models.User, models.MFAChallenge, error) {
+	challenge, err := a.mfa.MFAChallenge(ctx, securetoken.Hash(mfaToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("mfa challenge not found")
+			return models.User{}, models.MFAChallenge{}, ErrInvalidToken
+		}
+		return models.User{}, models.MFAChallenge{}, err
+	}
+	log = log.With(slog.Int64("user_id", challenge.UserID))
+	if challenge.Used {
+		log.Info("mfa challenge already used")
+		return models.User{}, models.MFAChallenge{}, ErrInvalidToken
+	}
+	now := time.Now()
+	if now.After(challenge.ExpiresAt) {
+		log.Info("mfa challenge expired")
+		return models.User{}, models.MFAChallenge{}, ErrExpiredToken
+	}
+
+	usr, err := a.usrProvider.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		return models.User{}, models.MFAChallenge{}, err
+	}
+	keys := loginKeys(usr.Email, clientIP)
+	if err := a.checkLoginAllowed(ctx, keys, now); err != nil {
+		log.Warn("login throttled", slog.String("error", err.Error()))
+		return models.User{}, models.MFAChallenge{}, err
+	}
+
+	if err := verify(usr); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			return models.User{}, models.MFAChallenge{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidMFACode)
+		}
+		return models.User{}, models.MFAChallenge{}, err
+	}
+
+	if err := a.mfa.UseMFAChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			log.Info("mfa challenge already used")
+			return models.User{}, models.MFAChallenge{}, ErrInvalidToken
+		}
+		return models.User{}, models.MFAChallenge{}, err
+	}
+	if err := a.loginFailures.ResetLoginFailures(ctx, userLoginKey(usr.Email)); err != nil {
+		return models.User{}, models.MFAChallenge{}, err
+	}
+	return usr, challenge, nil
+}
+
+// mfaEnabled reports whether the user has to pass the second factor.
+func (a *Auth) mfaEnabled(ctx context.Context, userID int64) (bool, error) {
+	secret, err := a.mfa.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return secret.Confirmed, nil
+}
+
+// newMFAChallenge returns the token of a new challenge of the user.
+func (a *Auth) newMFAChallenge(ctx context.Context, userID int64, appID int) (string, error) {
+	token, err := securetoken.New()
+	if err != nil {
+		return "", err
+	}
+	_, err = a.mfa.SaveMFAChallenge(ctx, models.MFAChallenge{
+		TokenHash: securetoken.Hash(token),
+		UserID:    userID,
+		AppID:     appID,
+		ExpiresAt: time.Now().Add(a.mfaChallengeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// userTOTP returns the enrolled secret of the user decrypted.
+func (a *Auth) userTOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	if a.mfaCipher == nil {
+		return models.TOTP{}, ErrMFADisabled
+	}
+	secret, err := a.mfa.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return models.TOTP{}, ErrMFANotEnrolled
+		}
+		return models.TOTP{}, err
+	}
+	secret.Secret, err = a.mfaCipher.Decrypt(secret.Secret, userIDBytes(userID))
+	if err != nil {
+		return models.TOTP{}, err
+	}
+	return secret, nil
+}
+
+// useTOTPCode checks the code and uses up its time step, so it can't be
+// replayed. With confirm the secret is enabled as well.
+func (a *Auth) useTOTPCode(ctx context.Context, secret models.TOTP, code string, confirm bool) error {
+	step, ok := totp.Validate(secret.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if err := a.mfa.UseTOTPStep(ctx, secret.UserID, step, confirm); err != nil {
+		if errors.Is(err, storage.ErrTOTPStepUsed) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// userIDBytes binds encrypted secrets to their user.
+func userIDBytes(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"auth/internal/lib/totp"
+	"context"
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+)
+
+// enableTOTP enrolls and confirms an authenticator app secret of the
+// caller and returns the secret. The current time step is used up.
+func (e *testEnv) enableTOTP(t *testing.T, ctx context.Context) []byte {
+	t.Helper()
+
+	encoded, _, err := e.auth.EnrollTOTP(ctx)
+	if err != nil {
+		t.Fatalf("EnrollTOTP(): %v", err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	if err := e.auth.ConfirmTOTP(ctx, totp.Code(secret, totp.Step(time.Now()))); err != nil {
+		t.Fatalf("ConfirmTOTP(): %v", err)
+	}
+	return secret
+}
+
+// mfaChallenge logs in a user with MFA enabled and returns the MFA token.
+func (e *testEnv) mfaChallenge(t *testing.T, email string, appID int) string {
+	t.Helper()
+
+	res, err := e.auth.Login(context.Background(), email, testPassword, appID, "")
+	if err != nil {
+		t.Fatalf("Login(): %v", err)
+	}
+	if res.MFAToken == "" || res.Tokens.AccessToken != "" {
+		t.Fatalf("Login() = %+v, want an mfa challenge only", res)
+	}
+	return res.MFAToken
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	ctx := env.userContext(t, usr.Email, app.ID)
+
+	encoded, uri, err := env.auth.EnrollTOTP(ctx)
+	if err != nil {
+		t.Fatalf("EnrollTOTP(): %v", err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	if uri == "" {
+		t.Error("EnrollTOTP() returned no uri")
+	}
+	// login is not affected until the secret is confirmed
+	env.login(t, usr.Email, app.ID)
+
+	current := totp.Step(time.Now())
+	tests := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{name: "wrong code", code: totp.Code(secret, current+5), wantErr: ErrInvalidMFACode},
+		{name: "current code", code: totp.Code(secret, current)},
+		{name: "already confirmed", code: totp.Code(secret, current+1), wantErr: ErrMFAEnabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := env.auth.ConfirmTOTP(ctx, tt.code); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ConfirmTOTP() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, _, err := env.auth.EnrollTOTP(ctx); !errors.Is(err, ErrMFAEnabled) {
+		t.Errorf("EnrollTOTP() when enabled error = %v, want %v", err, ErrMFAEnabled)
+	}
+}
+
+func TestEnrollTOTPDisabled(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) { cfg.MFACipher = nil })
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	ctx := env.userContext(t, usr.Email, app.ID)
+
+	if _, _, err := env.auth.EnrollTOTP(ctx); !errors.Is(err, ErrMFADisabled) {
+		t.Errorf("EnrollTOTP() error = %v, want %v", err, ErrMFADisabled)
+	}
+}
+
+func TestVerifyMFA(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// code returns the code to verify with and the mfa token to
+		// verify it for
+		code    func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string)
+		wantErr error
+	}{
+		{
+			name: "next code",
+			code: func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string) {
+				return totp.Code(secret, totp.Step(time.Now())+1), mfaToken
+			},
+		},
+		{
+			name: "code used to confirm",
+			code: func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string) {
+				return totp.Code(secret, totp.Step(time.Now())), mfaToken
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name: "wrong code",
+			code: func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string) {
+				return totp.Code(secret, totp.Step(time.Now())+5), mfaToken
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name: "replayed code",
+			code: func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string) {
+				code := totp.Code(secret, totp.Step(time.Now())+1)
+				if _, err := env.auth.VerifyMFA(context.Background(), mfaToken, code, ""); err != nil {
+					t.Fatalf("first VerifyMFA(): %v", err)
+				}
+				return code, env.mfaChallenge(t, "user@example.com", appID)
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name: "used challenge",
+			code: func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string) {
+				if _, err := env.auth.VerifyMFA(context.Background(), mfaToken, totp.Code(secret, totp.Step(time.Now())+1), ""); err != nil {
+					t.Fatalf("first VerifyMFA(): %v", err)
+				}
+				return totp.Code(secret, totp.Step(time.Now())-1), mfaToken
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:      "expired challenge",
+			configure: func(cfg *Config) { cfg.MFAChallengeTTL = -time.Minute },
+			code: func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string) {
+				return totp.Code(secret, totp.Step(time.Now())+1), mfaToken
+			},
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name: "unknown challenge",
+			code: func(t *testing.T, env *testEnv, secret []byte, appID int, mfaToken string) (string, string) {
+				return totp.Code(secret, totp.Step(time.Now())+1), "unknown"
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			secret := env.enableTOTP(t, env.userContext(t, usr.Email, app.ID))
+			code, mfaToken := tt.code(t, env, secret, app.ID, env.mfaChallenge(t, usr.Email, app.ID))
+
+			tokens, err := env.auth.VerifyMFA(context.Background(), mfaToken, code, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyMFA() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			claims, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken(): %v", err)
+			}
+			if claims.UID != usr.ID || claims.AppID != app.ID {
+				t.Errorf("claims = %+v, want user %d in app %d", claims, usr.ID, app.ID)
+			}
+		})
+	}
+}
+
+func TestVerifyMFAThrottled(t *testing.T) {
+	env := newTestEnv(t, lockout)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	secret := env.enableTOTP(t, env.userContext(t, usr.Email, app.ID))
+	mfaToken := env.mfaChallenge(t, usr.Email, app.ID)
+
+	wrong := totp.Code(secret, totp.Step(time.Now())+5)
+	for i := 0; i < env.auth.throttling.MaxFailures; i++ {
+		if _, err := env.auth.VerifyMFA(context.Background(), mfaToken, wrong, ""); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("VerifyMFA() error = %v, want %v", err, ErrInvalidMFACode)
+		}
+	}
+	// the right code doesn't help once the user is locked out
+	right := totp.Code(secret, totp.Step(time.Now())+1)
+	if _, err := env.auth.VerifyMFA(context.Background(), mfaToken, right, ""); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("VerifyMFA() error = %v, want %v", err, ErrTooManyAttempts)
+	}
+}
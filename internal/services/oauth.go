@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidClient            = errors.New("invalid oauth client")
+	ErrRedirectURINotRegistered = errors.New("redirect uri is not registered for the app")
+	ErrInvalidRedirectURI       = errors.New("redirect uri must be an absolute uri without a fragment")
+	ErrPKCERequired             = errors.New("code challenge with the S256 method is required")
+	ErrInvalidGrant             = errors.New("invalid authorization grant")
+	ErrMFARequired              = errors.New("mfa code is required")
+)
+
+type AuthorizationCodeStorage interface {
+	SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) (int64, error)
+	AuthorizationCode(ctx context.Context, codeHash []byte) (models.AuthorizationCode, error)
+	UseAuthorizationCode(ctx context.Context, codeID int64) error
+}
+
+// CheckAuthorizationRequest makes sure the app may start the
+// authorization_code grant with the request and returns the app.
+func (a *Auth) CheckAuthorizationRequest(ctx context.Context, req models.AuthorizationRequest) (models.App, error) {
+	const op = "auth.CheckAuthorizationRequest"
+
+	app, err := a.oauthClient(ctx, req)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return withoutSecrets(app), nil
+}
+
+// Authorize logs the user in for the authorization request and returns
+// the authorization code the client exchanges for tokens. Users with MFA
+// enabled have to provide the code of their authenticator app as well,
+// ErrMFARequired asks for it.
+func (a *Auth) Authorize(ctx context.Context, req models.AuthorizationRequest, email string, password string, mfaCode string, clientIP string) (string, error) {
+	const op = "auth.Authorize"
+
+	email = normalizeEmail(email)
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", req.AppID),
+	)
+	log.Info("authorizing oauth client")
+
+	app, err := a.oauthClient(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	keys := loginKeys(email, clientIP)
+	usr, err := a.checkPassword(ctx, log, email, password, keys, now)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	log = log.With(slog.Int64("user_id", usr.ID))
+
+	mfaEnabled, err := a.mfaEnabled(ctx, usr.ID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if mfaEnabled {
+		if mfaCode == "" {
+			log.Info("mfa required")
+			return "", fmt.Errorf("%s: %w", op, ErrMFARequired)
+		}
+		secret, err := a.userTOTP(ctx, usr.ID)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		if err := a.useTOTPCode(ctx, secret, mfaCode, false); err != nil {
+			if errors.Is(err, ErrInvalidMFACode) {
+				log.Info("invalid totp code")
+				return "", fmt.Errorf("%s: %w", op, a.recordLoginFailure(ctx, keys, now, ErrInvalidMFACode))
+			}
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := a.loginFailures.ResetLoginFailures(ctx, userLoginKey(usr.Email)); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.admitUser(ctx, log, usr, app); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := securetoken.New()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	familyID, err := securetoken.New()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = a.authorizationCodes.SaveAuthorizationCode(ctx, models.AuthorizationCode{
+		CodeHash:      securetoken.Hash(code),
+		AppID:         app.ID,
+		UserID:        usr.ID,
+		RedirectURI:   req.RedirectURI,
+		CodeChallenge: req.CodeChallenge,
+		FamilyID:      familyID,
+		ExpiresAt:     now.Add(a.authorizationCodeTTL),
+		Scopes:        a.oidcScopes(req.Scopes),
+		Nonce:         req.Nonce,
+		AuthTime:      now,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("authorization code issued")
+	return code, nil
+}
+
+// ExchangeAuthorizationCode issues tokens for an authorization code. The
+// code is used once, presenting it again revokes the refresh tokens it was
+// exchanged for.
+func (a *Auth) ExchangeAuthorizationCode(ctx context.Context, exchange models.CodeExchange) (models.TokenPair, error) {
+	const op = "auth.ExchangeAuthorizationCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", exchange.AppID),
+	)
+	log.Info("exchanging authorization code")
+
+	app, err := a.clientApp(ctx, log, exchange.AppID, exchange.ClientSecret)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := a.authorizationCodes.AuthorizationCode(ctx, securetoken.Hash(exchange.Code))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("authorization code not found")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log = log.With(slog.Int64("user_id", code.UserID))
+
+	if code.AppID != app.ID {
+		log.Info("authorization code issued to another app")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+	if code.Used {
+		log.Warn("authorization code reused")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, a.revokeCodeFamily(ctx, log, code.FamilyID))
+	}
+	if time.Now().After(code.ExpiresAt) {
+		log.Info("authorization code expired")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+	if exchange.RedirectURI != code.RedirectURI {
+		log.Info("redirect uri doesn't match")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+	if !verifyCodeChallenge(exchange.CodeVerifier, code.CodeChallenge) {
+		log.Info("invalid code verifier")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	if err := a.authorizationCodes.UseAuthorizationCode(ctx, code.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			log.Warn("authorization code reused")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, a.revokeCodeFamily(ctx, log, code.FamilyID))
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	usr, err := a.usrProvider.UserByID(ctx, code.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	tokens, err := a.issueTokens(ctx, usr, app, code.FamilyID, 0, code.Scopes)
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if containsString(code.Scopes, models.ScopeOpenID) {
+		tokens.IDToken, err = a.idToken(ctx, usr, app, code)
+		if err != nil {
+			log.Error("failed to issue id token", slog.String("error", err.Error()))
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	log.Info("authorization code exchanged")
+	return tokens, nil
+}
+
+// oauthClient returns the app of the authorization request after checking
+// its redirect uri and code challenge.
+func (a *Auth) oauthClient(ctx context.Context, req models.AuthorizationRequest) (models.App, error) {
+	app, err := a.appProvider.App(ctx, req.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, ErrInvalidClient
+		}
+		return models.App{}, err
+	}
+	if !app.AllowsRedirectURI(req.RedirectURI) {
+		return models.App{}, ErrRedirectURINotRegistered
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != models.PKCEMethodS256 {
+		return models.App{}, ErrPKCERequired
+	}
+	return app, nil
+}
+
+// revokeCodeFamily revokes the refresh tokens a reused authorization code
+// was exchanged for, the code may have been stolen.
+func (a *Auth) revokeCodeFamily(ctx context.Context, log *slog.Logger, familyID string) error {
+	if err := a.refreshTokens.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		log.Error("failed to revoke token family", slog.String("error", err.Error()))
+		return err
+	}
+	return ErrInvalidGrant
+}
+
+// verifyCodeChallenge reports whether the S256 challenge was derived from
+// verifier.
+func verifyCodeChallenge(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	derived := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(derived), []byte(challenge)) == 1
+}
+
+// validateRedirectURIs makes sure every uri can be redirected to.
+func validateRedirectURIs(uris []string) error {
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return fmt.Errorf("%w: %q", ErrInvalidRedirectURI, uri)
+		}
+		// private-use schemes of native apps have no host, web ones must
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https":
+			if u.Host == "" {
+				return fmt.Errorf("%w: %q", ErrInvalidRedirectURI, uri)
+			}
+		case "javascript", "data", "vbscript":
+			return fmt.Errorf("%w: %q", ErrInvalidRedirectURI, uri)
+		}
+	}
+	return nil
+}
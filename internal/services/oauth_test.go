@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"auth/internal/lib/totp"
+	"auth/internal/models"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// codeChallenge returns the S256 challenge of the verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oauthApp creates an app with testRedirectURI registered.
+func (e *testEnv) oauthApp(t *testing.T) models.App {
+	t.Helper()
+	return e.createApp(t, models.App{
+		AllowSelfRegistration: true,
+		RedirectURIs:          []string{testRedirectURI},
+	})
+}
+
+// authorizationRequest returns a valid request of the app.
+func authorizationRequest(appID int) models.AuthorizationRequest {
+	return models.AuthorizationRequest{
+		AppID:               appID,
+		RedirectURI:         testRedirectURI,
+		CodeChallenge:       codeChallenge(testCodeVerifier),
+		CodeChallengeMethod: models.PKCEMethodS256,
+	}
+}
+
+// codeExchange returns the exchange of the code the request was
+// authorized with.
+func codeExchange(appID int, code string) models.CodeExchange {
+	return models.CodeExchange{
+		AppID:        appID,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testCodeVerifier,
+	}
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		// RFC 7636, appendix B
+		{name: "rfc example", verifier: testCodeVerifier, challenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", want: true},
+		{name: "other verifier", verifier: strings.Repeat("a", 43), challenge: codeChallenge(testCodeVerifier)},
+		{name: "plain challenge", verifier: testCodeVerifier, challenge: testCodeVerifier},
+		{name: "short verifier", verifier: strings.Repeat("a", 42), challenge: codeChallenge(strings.Repeat("a", 42))},
+		{name: "long verifier", verifier: strings.Repeat("a", 129), challenge: codeChallenge(strings.Repeat("a", 129))},
+		{name: "longest verifier", verifier: strings.Repeat("a", 128), challenge: codeChallenge(strings.Repeat("a", 128)), want: true},
+		{name: "empty", verifier: "", challenge: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("verifyCodeChallenge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAuthorizationRequest(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.oauthApp(t)
+
+	tests := []struct {
+		name    string
+		modify  func(req *models.AuthorizationRequest)
+		wantErr error
+	}{
+		{name: "valid request", modify: func(req *models.AuthorizationRequest) {}},
+		{
+			name:    "unknown app",
+			modify:  func(req *models.AuthorizationRequest) { req.AppID = app.ID + 100 },
+			wantErr: ErrInvalidClient,
+		},
+		{
+			name:    "unregistered redirect uri",
+			modify:  func(req *models.AuthorizationRequest) { req.RedirectURI = "https://evil.example.net/callback" },
+			wantErr: ErrRedirectURINotRegistered,
+		},
+		{
+			name:    "redirect uri prefix",
+			modify:  func(req *models.AuthorizationRequest) { req.RedirectURI = testRedirectURI + "/more" },
+			wantErr: ErrRedirectURINotRegistered,
+		},
+		{
+			name:    "no code challenge",
+			modify:  func(req *models.AuthorizationRequest) { req.CodeChallenge = "" },
+			wantErr: ErrPKCERequired,
+		},
+		{
+			name:    "plain method",
+			modify:  func(req *models.AuthorizationRequest) { req.CodeChallengeMethod = "plain" },
+			wantErr: ErrPKCERequired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := authorizationRequest(app.ID)
+			tt.modify(&req)
+
+			got, err := env.auth.CheckAuthorizationRequest(context.Background(), req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckAuthorizationRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (got.ID != app.ID || got.SecretHash != nil) {
+				t.Errorf("CheckAuthorizationRequest() = %+v, want app %d without secrets", got, app.ID)
+			}
+		})
+	}
+}
+
+func TestExchangeAuthorizationCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// modify changes the exchange of the issued code
+		modify  func(t *testing.T, env *testEnv, exchange *models.CodeExchange)
+		wantErr error
+	}{
+		{name: "issued code", modify: func(t *testing.T, env *testEnv, exchange *models.CodeExchange) {}},
+		{
+			name:   "with client secret",
+			modify: func(t *testing.T, env *testEnv, exchange *models.CodeExchange) { exchange.ClientSecret = testAppSecret },
+		},
+		{
+			name:    "wrong client secret",
+			modify:  func(t *testing.T, env *testEnv, exchange *models.CodeExchange) { exchange.ClientSecret = "wrong" },
+			wantErr: ErrInvalidClient,
+		},
+		{
+			name:    "unknown code",
+			modify:  func(t *testing.T, env *testEnv, exchange *models.CodeExchange) { exchange.Code = "unknown" },
+			wantErr: ErrInvalidGrant,
+		},
+		{
+			name: "code of another app",
+			modify: func(t *testing.T, env *testEnv, exchange *models.CodeExchange) {
+				exchange.AppID = env.oauthApp(t).ID
+			},
+			wantErr: ErrInvalidGrant,
+		},
+		{
+			name:      "expired code",
+			configure: func(cfg *Config) { cfg.AuthorizationCodeTTL = -time.Minute },
+			modify:    func(t *testing.T, env *testEnv, exchange *models.CodeExchange) {},
+			wantErr:   ErrInvalidGrant,
+		},
+		{
+			name: "other redirect uri",
+			modify: func(t *testing.T, env *testEnv, exchange *models.CodeExchange) {
+				exchange.RedirectURI = "https://app.example.com/other"
+			},
+			wantErr: ErrInvalidGrant,
+		},
+		{
+			name: "wrong verifier",
+			modify: func(t *testing.T, env *testEnv, exchange *models.CodeExchange) {
+				exchange.CodeVerifier = strings.Repeat("a", 43)
+			},
+			wantErr: ErrInvalidGrant,
+		},
+		{
+			name: "no verifier",
+			modify: func(t *testing.T, env *testEnv, exchange *models.CodeExchange) {
+				exchange.CodeVerifier = ""
+			},
+			wantErr: ErrInvalidGrant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.oauthApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			code, err := env.auth.Authorize(context.Background(), authorizationRequest(app.ID), usr.Email, testPassword, "", "")
+			if err != nil {
+				t.Fatalf("Authorize(): %v", err)
+			}
+			exchange := codeExchange(app.ID, code)
+			tt.modify(t, env, &exchange)
+
+			tokens, err := env.auth.ExchangeAuthorizationCode(context.Background(), exchange)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExchangeAuthorizationCode() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			claims, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken(): %v", err)
+			}
+			if claims.UID != usr.ID || claims.AppID != app.ID {
+				t.Errorf("claims = %+v, want user %d in app %d", claims, usr.ID, app.ID)
+			}
+			if tokens.IDToken != "" {
+				t.Error("ExchangeAuthorizationCode() issued an id token without the openid scope")
+			}
+		})
+	}
+}
+
+func TestExchangeAuthorizationCodeReuse(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.oauthApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	code, err := env.auth.Authorize(context.Background(), authorizationRequest(app.ID), usr.Email, testPassword, "", "")
+	if err != nil {
+		t.Fatalf("Authorize(): %v", err)
+	}
+	tokens, err := env.auth.ExchangeAuthorizationCode(context.Background(), codeExchange(app.ID, code))
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode(): %v", err)
+	}
+	other := env.login(t, usr.Email, app.ID)
+
+	if _, err := env.auth.ExchangeAuthorizationCode(context.Background(), codeExchange(app.ID, code)); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("ExchangeAuthorizationCode() of used code error = %v, want %v", err, ErrInvalidGrant)
+	}
+	// the code may have been stolen, the tokens it was exchanged for stop
+	// working
+	if _, err := env.auth.Refresh(context.Background(), tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Refresh() of the exchanged token error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := env.auth.Refresh(context.Background(), other.RefreshToken); err != nil {
+		t.Errorf("Refresh() of another login: %v", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		// mfaCode returns the code of the authenticator app to authorize
+		// with, nil for users without MFA
+		mfaCode func(secret []byte) string
+		wantErr error
+	}{
+		{name: "password", password: testPassword},
+		{name: "wrong password", password: "wrong-password", wantErr: ErrInvalidCredentials},
+		{
+			name:     "mfa without code",
+			password: testPassword,
+			mfaCode:  func(secret []byte) string { return "" },
+			wantErr:  ErrMFARequired,
+		},
+		{
+			name:     "mfa with code",
+			password: testPassword,
+			mfaCode:  func(secret []byte) string { return totp.Code(secret, totp.Step(time.Now())+1) },
+		},
+		{
+			name:     "mfa with wrong code",
+			password: testPassword,
+			mfaCode:  func(secret []byte) string { return totp.Code(secret, totp.Step(time.Now())+5) },
+			wantErr:  ErrInvalidMFACode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.oauthApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			var mfaCode string
+			if tt.mfaCode != nil {
+				secret := env.enableTOTP(t, env.userContext(t, usr.Email, app.ID))
+				mfaCode = tt.mfaCode(secret)
+			}
+
+			code, err := env.auth.Authorize(context.Background(), authorizationRequest(app.ID), usr.Email, tt.password, mfaCode, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authorize() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && code == "" {
+				t.Error("Authorize() returned no code")
+			}
+		})
+	}
+}
+
+func TestValidateRedirectURIs(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr error
+	}{
+		{uri: "https://app.example.com/callback"},
+		{uri: "http://localhost:8080/callback"},
+		{uri: "com.example.app:/callback"},
+		{uri: "/callback", wantErr: ErrInvalidRedirectURI},
+		{uri: "https://app.example.com/callback#fragment", wantErr: ErrInvalidRedirectURI},
+		{uri: "https:///callback", wantErr: ErrInvalidRedirectURI},
+		{uri: "javascript:alert(1)", wantErr: ErrInvalidRedirectURI},
+		{uri: "data:text/html,hi", wantErr: ErrInvalidRedirectURI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if err := validateRedirectURIs([]string{tt.uri}); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateRedirectURIs() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
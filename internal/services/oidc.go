@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+var ErrOIDCDisabled = errors.New("openid connect is not configured")
+
+// oidcSupportedScopes are the scopes of the authorization_code grant, in
+// the order they are advertised.
+var oidcSupportedScopes = []string{models.ScopeOpenID, models.ScopeEmail}
+
+// OpenIDProvider returns what relying parties discover about the service.
+func (a *Auth) OpenIDProvider(ctx context.Context) (models.OpenIDProvider, error) {
+	const op = "auth.OpenIDProvider"
+
+	if !a.oidcEnabled() {
+		return models.OpenIDProvider{}, fmt.Errorf("%s: %w", op, ErrOIDCDisabled)
+	}
+	return models.OpenIDProvider{
+		Issuer:              a.issuer,
+		SigningAlgorithm:    a.signingAlg,
+		Scopes:              oidcSupportedScopes,
+		DeviceAuthorization: a.deviceFlow.VerificationURI != "",
+	}, nil
+}
+
+// UserInfo returns the claims about the user an access token was issued
+// to, limited to the scopes granted to the token. Tokens issued to apps by
+// the client_credentials grant have no user and are rejected.
+func (a *Auth) UserInfo(ctx context.Context, token string) (models.UserInfo, error) {
+	const op = "auth.UserInfo"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if !a.oidcEnabled() {
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrOIDCDisabled)
+	}
+	claims, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if claims.IsClient() {
+		log.Info("client token has no user", slog.Int("app_id", claims.ClientID))
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	usr, err := a.usrProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", slog.Int64("user_id", claims.UID))
+			return models.UserInfo{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return models.UserInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+	info := models.UserInfo{UserID: usr.ID}
+	if containsString(claims.Scopes, models.ScopeEmail) {
+		info.Email = usr.Email
+		info.EmailVerified = &usr.EmailVerified
+	}
+	return info, nil
+}
+
+// oidcEnabled reports whether the service is an OpenID Connect provider.
+func (a *Auth) oidcEnabled() bool {
+	return a.issuer != "" && jwt.IsAsymmetric(a.signingAlg)
+}
+
+// idToken issues the ID token for tokens exchanged for the authorization
+// code.
+func (a *Auth) idToken(ctx context.Context, usr models.User, app models.App, code models.AuthorizationCode) (string, error) {
+	key, err := a.signingKey(ctx, app)
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewIDToken(a.issuer, usr, app, code.Nonce, code.AuthTime, code.Scopes, key, a.tokenTTL)
+}
+
+// oidcScopes keeps the supported scopes of an authorization request, none
+// while OpenID Connect is disabled.
+func (a *Auth) oidcScopes(requested []string) []string {
+	if !a.oidcEnabled() {
+		return nil
+	}
+	var scopes []string
+	for _, scope := range requested {
+		if containsString(oidcSupportedScopes, scope) && !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/models"
+	"context"
+	"crypto"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+// exchangeCode authorizes the user for the scopes and exchanges the code.
+func (e *testEnv) exchangeCode(t *testing.T, email string, appID int, scopes []string, nonce string) models.TokenPair {
+	t.Helper()
+
+	req := authorizationRequest(appID)
+	req.Scopes = scopes
+	req.Nonce = nonce
+	code, err := e.auth.Authorize(context.Background(), req, email, testPassword, "", "")
+	if err != nil {
+		t.Fatalf("Authorize(): %v", err)
+	}
+	tokens, err := e.auth.ExchangeAuthorizationCode(context.Background(), codeExchange(appID, code))
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode(): %v", err)
+	}
+	return tokens
+}
+
+func TestIDToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		scopes      []string
+		wantIDToken bool
+		wantEmail   bool
+	}{
+		{name: "no openid scope", scopes: []string{models.ScopeEmail}},
+		{name: "openid scope", scopes: []string{models.ScopeOpenID}, wantIDToken: true},
+		{name: "email scope", scopes: []string{models.ScopeOpenID, models.ScopeEmail}, wantIDToken: true, wantEmail: true},
+		{name: "unsupported scope", scopes: []string{models.ScopeOpenID, "profile"}, wantIDToken: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.oauthApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			tokens := env.exchangeCode(t, usr.Email, app.ID, tt.scopes, "n-0S6_WzA2Mj")
+
+			if (tokens.IDToken != "") != tt.wantIDToken {
+				t.Fatalf("ExchangeAuthorizationCode() id token = %q, want one: %v", tokens.IDToken, tt.wantIDToken)
+			}
+			if !tt.wantIDToken {
+				return
+			}
+			key, err := env.auth.signingKey(context.Background(), app)
+			if err != nil {
+				t.Fatalf("signing key: %v", err)
+			}
+			claims := gojwt.MapClaims{}
+			_, err = gojwt.ParseWithClaims(tokens.IDToken, claims, func(*gojwt.Token) (interface{}, error) {
+				return key.Key.(crypto.Signer).Public(), nil
+			}, gojwt.WithValidMethods([]string{jwt.AlgES256}), gojwt.WithIssuer(testIssuer),
+				gojwt.WithAudience(strconv.Itoa(app.ID)), gojwt.WithSubject(strconv.FormatInt(usr.ID, 10)))
+			if err != nil {
+				t.Fatalf("parse id token: %v", err)
+			}
+			if claims["nonce"] != "n-0S6_WzA2Mj" {
+				t.Errorf("id token nonce = %v, want %s", claims["nonce"], "n-0S6_WzA2Mj")
+			}
+			if _, ok := claims["auth_time"]; !ok {
+				t.Error("id token has no auth_time")
+			}
+			if email, _ := claims["email"].(string); (email == usr.Email) != tt.wantEmail {
+				t.Errorf("id token email = %q, want it: %v", email, tt.wantEmail)
+			}
+
+			// an id token is not an access token
+			if _, err := env.auth.ValidateToken(context.Background(), tokens.IDToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("ValidateToken() of id token error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.createApp(t, models.App{
+		AllowSelfRegistration: true,
+		RedirectURIs:          []string{testRedirectURI},
+		Scopes:                []string{"orders:read"},
+	})
+	usr := env.registerUser(t, "user@example.com")
+	verified := false
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		want    models.UserInfo
+		wantErr error
+	}{
+		{
+			name: "email scope",
+			token: func(t *testing.T) string {
+				return env.exchangeCode(t, usr.Email, app.ID, []string{models.ScopeOpenID, models.ScopeEmail}, "").AccessToken
+			},
+			want: models.UserInfo{UserID: usr.ID, Email: usr.Email, EmailVerified: &verified},
+		},
+		{
+			name: "openid scope",
+			token: func(t *testing.T) string {
+				return env.exchangeCode(t, usr.Email, app.ID, []string{models.ScopeOpenID}, "").AccessToken
+			},
+			want: models.UserInfo{UserID: usr.ID},
+		},
+		{
+			name:  "password login",
+			token: func(t *testing.T) string { return env.login(t, usr.Email, app.ID).AccessToken },
+			want:  models.UserInfo{UserID: usr.ID},
+		},
+		{
+			name: "client token",
+			token: func(t *testing.T) string {
+				token, err := env.auth.IssueClientToken(context.Background(), app.ID, testAppSecret, nil)
+				if err != nil {
+					t.Fatalf("IssueClientToken(): %v", err)
+				}
+				return token.AccessToken
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "invalid token",
+			token:   func(t *testing.T) string { return "invalid" },
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := env.auth.UserInfo(context.Background(), tt.token(t))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UserInfo() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UserInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenIDProvider(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		want      models.OpenIDProvider
+		wantErr   error
+	}{
+		{
+			name: "enabled",
+			want: models.OpenIDProvider{
+				Issuer:              testIssuer,
+				SigningAlgorithm:    jwt.AlgES256,
+				Scopes:              []string{models.ScopeOpenID, models.ScopeEmail},
+				DeviceAuthorization: true,
+			},
+		},
+		{
+			name:      "without device flow",
+			configure: func(cfg *Config) { cfg.DeviceFlow.VerificationURI = "" },
+			want: models.OpenIDProvider{
+				Issuer:           testIssuer,
+				SigningAlgorithm: jwt.AlgES256,
+				Scopes:           []string{models.ScopeOpenID, models.ScopeEmail},
+			},
+		},
+		{
+			name:      "symmetric keys",
+			configure: func(cfg *Config) { cfg.SigningAlgorithm = jwt.AlgHS256 },
+			wantErr:   ErrOIDCDisabled,
+		},
+		{
+			name:      "no issuer",
+			configure: func(cfg *Config) { cfg.Issuer = "" },
+			wantErr:   ErrOIDCDisabled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+
+			got, err := env.auth.OpenIDProvider(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenIDProvider() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OpenIDProvider() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) { cfg.SigningAlgorithm = jwt.AlgHS256 })
+	app := env.oauthApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	// openid is ignored, no id token is issued
+	tokens := env.exchangeCode(t, usr.Email, app.ID, []string{models.ScopeOpenID, models.ScopeEmail}, "")
+	if tokens.IDToken != "" {
+		t.Error("ExchangeAuthorizationCode() issued an id token")
+	}
+	if _, err := env.auth.UserInfo(context.Background(), tokens.AccessToken); !errors.Is(err, ErrOIDCDisabled) {
+		t.Errorf("UserInfo() error = %v, want %v", err, ErrOIDCDisabled)
+	}
+}
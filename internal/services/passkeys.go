@@ -0,0 +1,482 @@
+package auth
+
+import (
+	"auth/internal/lib/principal"
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var ErrPasskeysNotConfigured = errors.New("passkeys are not configured for the app")
+
+// userHandleSize is the number of random bytes in a user handle.
+const userHandleSize = 32
+
+type PasskeyStorage interface {
+	SavePasskey(ctx context.Context, passkey models.Passkey) (int64, error)
+	Passkeys(ctx context.Context, userID int64, rpID string) ([]models.Passkey, error)
+	PasskeyByCredentialID(ctx context.Context, credentialID []byte) (models.Passkey, error)
+	UpdatePasskeyUsage(ctx context.Context, passkeyID int64, signCount uint32, backupState bool, usedAt time.Time) error
+	SaveWebAuthnSession(ctx context.Context, session models.WebAuthnSession) (int64, error)
+	WebAuthnSession(ctx context.Context, tokenHash []byte) (models.WebAuthnSession, error)
+	UseWebAuthnSession(ctx context.Context, sessionID int64) error
+}
+
+// BeginPasskeyRegistration starts registration of a passkey of the caller
+// for the relying party of the app. It returns the token of the ceremony
+// and the options to pass to navigator.credentials.create, encoded in
+// JSON.
+func (a *Auth) BeginPasskeyRegistration(ctx context.Context, appID int) (string, string, error) {
+	const op = "auth.BeginPasskeyRegistration"
+
+	claims, ok := principal.FromContext(ctx)
+	if !ok {
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", claims.UID),
+		slog.Int("app_id", appID),
+	)
+	log.Info("beginning passkey registration")
+
+	app, rp, err := a.relyingParty(ctx, appID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	usr, err := a.usrProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	user, err := a.webAuthnUser(ctx, usr, app.WebAuthn.RPID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if user.handle == nil {
+		// the first passkey for the relying party, the handle is kept in
+		// the session until the passkey is saved
+		user.handle = make([]byte, userHandleSize)
+		if _, err := rand.Read(user.handle); err != nil {
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, c := range user.credentials {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+	creation, session, err := rp.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, options, err := a.saveWebAuthnSession(ctx, models.CeremonyRegistration, usr.ID, appID, session, creation)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("passkey registration begun")
+	return token, options, nil
+}
+
+// FinishPasskeyRegistration verifies the credential created by the
+// authenticator, given as the JSON of the PublicKeyCredential, and saves
+// it as a passkey of the caller.
+func (a *Auth) FinishPasskeyRegistration(ctx context.Context, sessionToken string, credential string) error {
+	const op = "auth.FinishPasskeyRegistration"
+
+	claims, ok := principal.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", claims.UID),
+	)
+	log.Info("finishing passkey registration")
+
+	session, data, err := a.webAuthnSession(ctx, sessionToken, models.CeremonyRegistration)
+	if err != nil {
+		log.Info("invalid webauthn session", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if session.UserID != claims.UID {
+		log.Warn("webauthn session of another user")
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	app, rp, err := a.relyingParty(ctx, session.AppID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	usr, err := a.usrProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	user, err := a.webAuthnUser(ctx, usr, app.WebAuthn.RPID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if user.handle == nil {
+		user.handle = data.UserID
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(strings.NewReader(credential))
+	if err != nil {
+		log.Info("invalid credential", slog.String("error", webAuthnError(err)))
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+	created, err := rp.CreateCredential(user, data, parsed)
+	if err != nil {
+		log.Info("credential rejected", slog.String("error", webAuthnError(err)))
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+	if err := a.passkeys.UseWebAuthnSession(ctx, session.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	transports := make([]string, 0, len(created.Transport))
+	for _, t := range created.Transport {
+		transports = append(transports, string(t))
+	}
+	_, err = a.passkeys.SavePasskey(ctx, models.Passkey{
+		UserID:          usr.ID,
+		RPID:            app.WebAuthn.RPID,
+		CredentialID:    created.ID,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		AAGUID:          created.Authenticator.AAGUID,
+		UserHandle:      user.handle,
+		SignCount:       created.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("passkey registered")
+	return nil
+}
+
+// BeginPasskeyLogin starts a passkey login to the app. With an email the
+// passkeys of the user are offered, otherwise the authenticator picks a
+// discoverable one. An unknown email is treated as no email, so the call
+// can't be used to discover accounts. It returns the token of the ceremony
+// and the options to pass to navigator.credentials.get, encoded in JSON.
+func (a *Auth) BeginPasskeyLogin(ctx context.Context, appID int, email string) (string, string, error) {
+	const op = "auth.BeginPasskeyLogin"
+
+	email = normalizeEmail(email)
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+	log.Info("beginning passkey login")
+
+	app, rp, err := a.relyingParty(ctx, appID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var user *webAuthnUser
+	if email != "" {
+		usr, err := a.usrProvider.User(ctx, email)
+		if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+		if err == nil {
+			user, err = a.webAuthnUser(ctx, usr, app.WebAuthn.RPID)
+			if err != nil {
+				return "", "", fmt.Errorf("%s: %w", op, err)
+			}
+		}
+	}
+
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	var userID int64
+	if user != nil && len(user.credentials) > 0 {
+		assertion, session, err = rp.BeginLogin(user)
+		userID = user.user.ID
+	} else {
+		assertion, session, err = rp.BeginDiscoverableLogin()
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, options, err := a.saveWebAuthnSession(ctx, models.CeremonyLogin, userID, appID, session, assertion)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("passkey login begun")
+	return token, options, nil
+}
+
+// FinishPasskeyLogin verifies the assertion of the authenticator, given as
+// the JSON of the PublicKeyCredential, and issues tokens the same way as
+// Login. A passkey is enough on its own, MFA isn't asked for. Failures are
+// throttled the same way as wrong passwords.
+func (a *Auth) FinishPasskeyLogin(ctx context.Context, sessionToken string, credential string, clientIP string) (models.TokenPair, error) {
+	const op = "auth.FinishPasskeyLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("finishing passkey login")
+
+	session, data, err := a.webAuthnSession(ctx, sessionToken, models.CeremonyLogin)
+	if err != nil {
+		log.Info("invalid webauthn session", slog.String("error", err.Error()))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	app, rp, err := a.relyingParty(ctx, session.AppID)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(strings.NewReader(credential))
+	if err != nil {
+		log.Info("invalid assertion", slog.String("error", webAuthnError(err)))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+	passkey, err := a.passkeys.PasskeyByCredentialID(ctx, parsed.RawID)
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyNotFound) {
+			log.Info("unknown passkey")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log = log.With(slog.Int64("user_id", passkey.UserID))
+
+	usr, err := a.usrProvider.UserByID(ctx, passkey.UserID)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	now := time.Now()
+	keys := loginKeys(usr.Email, clientIP)
+	if err := a.checkLoginAllowed(ctx, keys, now); err != nil {
+		log.Warn("login throttled", slog.String("error", err.Error()))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.webAuthnUser(ctx, usr, app.WebAuthn.RPID)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	var validated *webauthn.Credential
+	if session.UserID != 0 {
+		if session.UserID != usr.ID {
+			log.Info("passkey of another user")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, a.recordLoginFailure(ctx, keys, now, ErrInvalidCredentials))
+		}
+		validated, err = rp.ValidateLogin(user, data, parsed)
+	} else {
+		validated, err = rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			if !bytes.Equal(userHandle, user.WebAuthnID()) {
+				return nil, ErrInvalidCredentials
+			}
+			return user, nil
+		}, data, parsed)
+	}
+	if err != nil {
+		log.Info("assertion rejected", slog.String("error", webAuthnError(err)))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, a.recordLoginFailure(ctx, keys, now, ErrInvalidCredentials))
+	}
+	if validated.Authenticator.CloneWarning {
+		log.Warn("passkey sign counter didn't grow, the authenticator may be cloned",
+			slog.Int64("passkey_id", passkey.ID))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	if err := a.passkeys.UseWebAuthnSession(ctx, session.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	err = a.passkeys.UpdatePasskeyUsage(ctx, passkey.ID, validated.Authenticator.SignCount, validated.Flags.BackupState, now)
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyNotFound) {
+			log.Warn("passkey used concurrently", slog.Int64("passkey_id", passkey.ID))
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.loginFailures.ResetLoginFailures(ctx, userLoginKey(usr.Email)); err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.completeLogin(ctx, log, usr, session.AppID)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return tokens, nil
+}
+
+// relyingParty returns the app and its WebAuthn relying party.
+func (a *Auth) relyingParty(ctx context.Context, appID int) (models.App, *webauthn.WebAuthn, error) {
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		return models.App{}, nil, err
+	}
+	if app.WebAuthn.RPID == "" {
+		return models.App{}, nil, ErrPasskeysNotConfigured
+	}
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          app.WebAuthn.RPID,
+		RPDisplayName: app.Name,
+		RPOrigins:     app.WebAuthn.Origins,
+	})
+	if err != nil {
+		return models.App{}, nil, fmt.Errorf("%w: %s", ErrPasskeysNotConfigured, err.Error())
+	}
+	return app, rp, nil
+}
+
+// saveWebAuthnSession keeps the state of a begun ceremony and returns its
+// token along with the options for the client encoded in JSON.
+func (a *Auth) saveWebAuthnSession(ctx context.Context, ceremony string, userID int64, appID int, session *webauthn.SessionData, options any) (string, string, error) {
+	expiresAt := time.Now().Add(a.webAuthnSessionTTL)
+	session.Expires = expiresAt
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", "", err
+	}
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := securetoken.New()
+	if err != nil {
+		return "", "", err
+	}
+	_, err = a.passkeys.SaveWebAuthnSession(ctx, models.WebAuthnSession{
+		TokenHash: securetoken.Hash(token),
+		UserID:    userID,
+		AppID:     appID,
+		Ceremony:  ceremony,
+		Data:      data,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return token, string(encoded), nil
+}
+
+// webAuthnSession returns an unfinished session of the ceremony.
+func (a *Auth) webAuthnSession(ctx context.Context, token string, ceremony string) (models.WebAuthnSession, webauthn.SessionData, error) {
+	session, err := a.passkeys.WebAuthnSession(ctx, securetoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			return models.WebAuthnSession{}, webauthn.SessionData{}, ErrInvalidToken
+		}
+		return models.WebAuthnSession{}, webauthn.SessionData{}, err
+	}
+	if session.Used || session.Ceremony != ceremony {
+		return models.WebAuthnSession{}, webauthn.SessionData{}, ErrInvalidToken
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return models.WebAuthnSession{}, webauthn.SessionData{}, ErrExpiredToken
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &data); err != nil {
+		return models.WebAuthnSession{}, webauthn.SessionData{}, err
+	}
+	return session, data, nil
+}
+
+// webAuthnUser is a user along with its passkeys for the relying party.
+type webAuthnUser struct {
+	user        models.User
+	credentials []webauthn.Credential
+	// handle is shared by the passkeys, nil while there are none
+	handle []byte
+}
+
+func (a *Auth) webAuthnUser(ctx context.Context, usr models.User, rpID string) (*webAuthnUser, error) {
+	passkeys, err := a.passkeys.Passkeys(ctx, usr.ID, rpID)
+	if err != nil {
+		return nil, err
+	}
+	var handle []byte
+	credentials := make([]webauthn.Credential, 0, len(passkeys))
+	for _, p := range passkeys {
+		handle = p.UserHandle
+		transports := make([]protocol.AuthenticatorTransport, 0, len(p.Transports))
+		for _, t := range p.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		})
+	}
+	return &webAuthnUser{user: usr, credentials: credentials, handle: handle}, nil
+}
+
+// WebAuthnID is the user handle. It is random rather than the user id, so
+// authenticators learn nothing about the account from it.
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.handle
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// webAuthnError returns details of protocol errors, their messages alone
+// are too generic to tell what went wrong.
+func webAuthnError(err error) string {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.DevInfo != "" {
+		return protocolErr.Error() + ": " + protocolErr.DevInfo
+	}
+	return err.Error()
+}
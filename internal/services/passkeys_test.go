@@ -0,0 +1,440 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://app.example.com"
+)
+
+// Flags of authenticator data.
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedCredential = 0x40
+)
+
+// softAuthenticator is a software passkey authenticator holding a single
+// ES256 credential. It answers the ceremonies the way a browser and a
+// platform authenticator would, with "none" attestation.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	// origin is the origin the browser reports, testOrigin when empty
+	origin string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("credential id: %v", err)
+	}
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+// create answers navigator.credentials.create with the options returned
+// by BeginPasskeyRegistration.
+func (a *softAuthenticator) create(t *testing.T, options string) string {
+	t.Helper()
+
+	var creation struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			RP        struct {
+				ID string `json:"id"`
+			} `json:"rp"`
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal([]byte(options), &creation); err != nil {
+		t.Fatalf("decode creation options: %v", err)
+	}
+	handle, err := base64.RawURLEncoding.DecodeString(creation.PublicKey.User.ID)
+	if err != nil {
+		t.Fatalf("decode user handle: %v", err)
+	}
+	a.userHandle = handle
+
+	coseKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("encode public key: %v", err)
+	}
+	authData := a.authData(creation.PublicKey.RP.ID, flagUserPresent|flagUserVerified|flagAttestedCredential)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, coseKey...)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatalf("encode attestation: %v", err)
+	}
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    a.clientData(t, "webauthn.create", creation.PublicKey.Challenge),
+		"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+	})
+}
+
+// get answers navigator.credentials.get with the options returned by
+// BeginPasskeyLogin.
+func (a *softAuthenticator) get(t *testing.T, options string) string {
+	t.Helper()
+
+	var assertion struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			RPID      string `json:"rpId"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal([]byte(options), &assertion); err != nil {
+		t.Fatalf("decode assertion options: %v", err)
+	}
+
+	a.signCount++
+	authData := a.authData(assertion.PublicKey.RPID, flagUserPresent|flagUserVerified)
+	clientData := a.clientData(t, "webauthn.get", assertion.PublicKey.Challenge)
+	rawClientData, _ := base64.RawURLEncoding.DecodeString(clientData)
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    clientData,
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":         base64.RawURLEncoding.EncodeToString(signature),
+		"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+	})
+}
+
+// authData returns authenticator data without attested credential data.
+func (a *softAuthenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, typ string, challenge string) string {
+	t.Helper()
+
+	origin := a.origin
+	if origin == "" {
+		origin = testOrigin
+	}
+	data, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+	if err != nil {
+		t.Fatalf("encode client data: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) string {
+	t.Helper()
+
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+	credential, err := json.Marshal(map[string]any{
+		"id":       id,
+		"rawId":    id,
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("encode credential: %v", err)
+	}
+	return string(credential)
+}
+
+// passkeyApp creates an app with a relying party served at testOrigin.
+func (e *testEnv) passkeyApp(t *testing.T) models.App {
+	t.Helper()
+	return e.createApp(t, models.App{
+		AllowSelfRegistration: true,
+		WebAuthn:              models.WebAuthnConfig{RPID: testRPID, Origins: []string{testOrigin}},
+	})
+}
+
+// registerPasskey registers a passkey of a new authenticator for the
+// caller.
+func (e *testEnv) registerPasskey(t *testing.T, ctx context.Context, appID int) *softAuthenticator {
+	t.Helper()
+
+	authenticator := newSoftAuthenticator(t)
+	token, options, err := e.auth.BeginPasskeyRegistration(ctx, appID)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration(): %v", err)
+	}
+	if err := e.auth.FinishPasskeyRegistration(ctx, token, authenticator.create(t, options)); err != nil {
+		t.Fatalf("FinishPasskeyRegistration(): %v", err)
+	}
+	return authenticator
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish returns the context and the session token to finish the
+		// registration with
+		finish  func(t *testing.T, env *testEnv, ctx context.Context, appID int, token string) (context.Context, string)
+		origin  string
+		wantErr error
+	}{
+		{
+			name: "created credential",
+			finish: func(t *testing.T, env *testEnv, ctx context.Context, appID int, token string) (context.Context, string) {
+				return ctx, token
+			},
+		},
+		{
+			name: "wrong origin",
+			finish: func(t *testing.T, env *testEnv, ctx context.Context, appID int, token string) (context.Context, string) {
+				return ctx, token
+			},
+			origin:  "https://evil.example.net",
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name: "session of another user",
+			finish: func(t *testing.T, env *testEnv, ctx context.Context, appID int, token string) (context.Context, string) {
+				other := env.registerUser(t, "other@example.com")
+				return env.userContext(t, other.Email, appID), token
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "unknown session",
+			finish: func(t *testing.T, env *testEnv, ctx context.Context, appID int, token string) (context.Context, string) {
+				return ctx, "unknown"
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.passkeyApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			ctx := env.userContext(t, usr.Email, app.ID)
+
+			token, options, err := env.auth.BeginPasskeyRegistration(ctx, app.ID)
+			if err != nil {
+				t.Fatalf("BeginPasskeyRegistration(): %v", err)
+			}
+			authenticator := newSoftAuthenticator(t)
+			authenticator.origin = tt.origin
+			credential := authenticator.create(t, options)
+			finishCtx, finishToken := tt.finish(t, env, ctx, app.ID, token)
+
+			err = env.auth.FinishPasskeyRegistration(finishCtx, finishToken, credential)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinishPasskeyRegistration() error = %v, want %v", err, tt.wantErr)
+			}
+			passkeys, err := env.storage.Passkeys(context.Background(), usr.ID, testRPID)
+			if err != nil {
+				t.Fatalf("passkeys: %v", err)
+			}
+			wantPasskeys := 1
+			if tt.wantErr != nil {
+				wantPasskeys = 0
+			}
+			if len(passkeys) != wantPasskeys {
+				t.Errorf("user has %d passkeys, want %d", len(passkeys), wantPasskeys)
+			}
+		})
+	}
+}
+
+func TestPasskeyRegistrationNotConfigured(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	_, _, err := env.auth.BeginPasskeyRegistration(env.userContext(t, usr.Email, app.ID), app.ID)
+	if !errors.Is(err, ErrPasskeysNotConfigured) {
+		t.Errorf("BeginPasskeyRegistration() error = %v, want %v", err, ErrPasskeysNotConfigured)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// email is passed to BeginPasskeyLogin
+		email string
+		// answer returns the credential to finish the login with
+		answer  func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string
+		wantErr error
+	}{
+		{
+			name:  "passkeys of the user",
+			email: "user@example.com",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				return authenticator.get(t, options)
+			},
+		},
+		{
+			name: "discoverable passkey",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				return authenticator.get(t, options)
+			},
+		},
+		{
+			name:  "unknown email",
+			email: "unknown@example.com",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				return authenticator.get(t, options)
+			},
+		},
+		{
+			name:  "signed with another key",
+			email: "user@example.com",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				forged := newSoftAuthenticator(t)
+				forged.credentialID = authenticator.credentialID
+				forged.userHandle = authenticator.userHandle
+				forged.signCount = authenticator.signCount
+				return forged.get(t, options)
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:  "sign counter didn't grow",
+			email: "user@example.com",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				token, earlier, err := env.auth.BeginPasskeyLogin(context.Background(), appID, "user@example.com")
+				if err != nil {
+					t.Fatalf("BeginPasskeyLogin(): %v", err)
+				}
+				if _, err := env.auth.FinishPasskeyLogin(context.Background(), token, authenticator.get(t, earlier), ""); err != nil {
+					t.Fatalf("earlier FinishPasskeyLogin(): %v", err)
+				}
+				authenticator.signCount = 0
+				return authenticator.get(t, options)
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:  "unknown credential",
+			email: "user@example.com",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				unknown := newSoftAuthenticator(t)
+				unknown.userHandle = authenticator.userHandle
+				return unknown.get(t, options)
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:  "wrong origin",
+			email: "user@example.com",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				authenticator.origin = "https://evil.example.net"
+				return authenticator.get(t, options)
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:  "passkey of another user",
+			email: "other@example.com",
+			answer: func(t *testing.T, env *testEnv, appID int, authenticator *softAuthenticator, options string) string {
+				return authenticator.get(t, options)
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.passkeyApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			authenticator := env.registerPasskey(t, env.userContext(t, usr.Email, app.ID), app.ID)
+			other := env.registerUser(t, "other@example.com")
+			env.registerPasskey(t, env.userContext(t, other.Email, app.ID), app.ID)
+
+			token, options, err := env.auth.BeginPasskeyLogin(context.Background(), app.ID, tt.email)
+			if err != nil {
+				t.Fatalf("BeginPasskeyLogin(): %v", err)
+			}
+			credential := tt.answer(t, env, app.ID, authenticator, options)
+
+			tokens, err := env.auth.FinishPasskeyLogin(context.Background(), token, credential, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinishPasskeyLogin() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			claims, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken(): %v", err)
+			}
+			if claims.UID != usr.ID || claims.AppID != app.ID {
+				t.Errorf("claims = %+v, want user %d in app %d", claims, usr.ID, app.ID)
+			}
+		})
+	}
+}
+
+func TestPasskeyLoginSessionUsedOnce(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.passkeyApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	ctx := env.userContext(t, usr.Email, app.ID)
+	authenticator := env.registerPasskey(t, ctx, app.ID)
+
+	token, options, err := env.auth.BeginPasskeyLogin(context.Background(), app.ID, usr.Email)
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin(): %v", err)
+	}
+	if _, err := env.auth.FinishPasskeyLogin(context.Background(), token, authenticator.get(t, options), ""); err != nil {
+		t.Fatalf("FinishPasskeyLogin(): %v", err)
+	}
+	if _, err := env.auth.FinishPasskeyLogin(context.Background(), token, authenticator.get(t, options), ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("FinishPasskeyLogin() with used session error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	// a registration session doesn't finish a login
+	regToken, regOptions, err := env.auth.BeginPasskeyRegistration(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration(): %v", err)
+	}
+	if _, err := env.auth.FinishPasskeyLogin(context.Background(), regToken, authenticator.get(t, regOptions), ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("FinishPasskeyLogin() with registration session error = %v, want %v", err, ErrInvalidToken)
+	}
+}
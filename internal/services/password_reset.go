@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type PasswordResetStorage interface {
+	SavePasswordResetToken(ctx context.Context, token models.PasswordResetToken) (int64, error)
+	PasswordResetToken(ctx context.Context, tokenHash []byte) (models.PasswordResetToken, error)
+	ResetPassword(ctx context.Context, tokenID int64, passHash []byte) error
+}
+
+// RequestPasswordReset mails a one-time password reset token to the user.
+// The email is sent in the background and the call always succeeds, so
+// neither its result nor its timing tells whether the email is registered.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	const op = "auth.RequestPasswordReset"
+
+	email = normalizeEmail(email)
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("requesting password reset")
+
+	a.background(ctx, func(ctx context.Context) {
+		if err := a.sendPasswordReset(ctx, log, email); err != nil {
+			log.Error("failed to send password reset email", slog.String("error", err.Error()))
+		}
+	})
+	return nil
+}
+
+// sendPasswordReset mails a new reset token to the user with the email,
+// unknown emails are skipped.
+func (a *Auth) sendPasswordReset(ctx context.Context, log *slog.Logger, email string) error {
+	usr, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return nil
+		}
+		return err
+	}
+
+	log = log.With(slog.Int64("user_id", usr.ID))
+
+	token, expiresAt, err := a.newPasswordResetToken(ctx, usr.ID)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Use this token to set a new password:\n\n%s\n\nThe token expires at %s. "+
+		"If you didn't request a password reset, ignore this email.",
+		token, expiresAt.UTC().Format(time.RFC1123))
+	if err := a.mailer.Send(ctx, usr.Email, "Reset your password", body); err != nil {
+		return err
+	}
+	log.Info("password reset email sent")
+	return nil
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset
+// and signs the user out of every session.
+func (a *Auth) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	const op = "auth.ResetPassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("resetting password")
+
+	rt, err := a.passwordResets.PasswordResetToken(ctx, securetoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("password reset token not found")
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", rt.UserID))
+
+	if rt.Used {
+		log.Info("password reset token already used")
+		return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		log.Info("password reset token expired")
+		return fmt.Errorf("%s: %w", op, ErrExpiredToken)
+	}
+
+	pHash, err := a.hashPassword(newPassword)
+	if err != nil {
+		if errors.Is(err, ErrWeakPassword) {
+			log.Info("weak password", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.Error("failed to generate password hash", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.passwordResets.ResetPassword(ctx, rt.ID, pHash); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) || errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("password reset token no longer applies", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeUserSessions(ctx, rt.UserID); err != nil {
+		log.Error("failed to revoke sessions", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("password reset")
+	return nil
+}
+
+// newPasswordResetToken returns a new reset token of the user and when it
+// expires.
+func (a *Auth) newPasswordResetToken(ctx context.Context, userID int64) (string, time.Time, error) {
+	token, err := securetoken.New()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(a.passwordResetTTL)
+
+	_, err = a.passwordResets.SavePasswordResetToken(ctx, models.PasswordResetToken{
+		TokenHash: securetoken.Hash(token),
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
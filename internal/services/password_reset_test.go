@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const newPassword = "battery-staple"
+
+func TestResetPassword(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// token returns the token to reset with, given the mailed one
+		token       func(t *testing.T, env *testEnv, mailed string) string
+		newPassword string
+		wantErr     error
+	}{
+		{
+			name:        "mailed token",
+			token:       func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+			newPassword: newPassword,
+		},
+		{
+			name:        "weak password",
+			token:       func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+			newPassword: "short",
+			wantErr:     ErrWeakPassword,
+		},
+		{
+			name:        "unknown token",
+			token:       func(t *testing.T, env *testEnv, mailed string) string { return "unknown" },
+			newPassword: newPassword,
+			wantErr:     ErrInvalidToken,
+		},
+		{
+			name:        "expired token",
+			configure:   func(cfg *Config) { cfg.PasswordResetTTL = -time.Minute },
+			token:       func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+			newPassword: newPassword,
+			wantErr:     ErrExpiredToken,
+		},
+		{
+			name: "used token",
+			token: func(t *testing.T, env *testEnv, mailed string) string {
+				if err := env.auth.ResetPassword(context.Background(), mailed, "first-new-password"); err != nil {
+					t.Fatalf("first ResetPassword(): %v", err)
+				}
+				return mailed
+			},
+			newPassword: newPassword,
+			wantErr:     ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			session := env.login(t, usr.Email, app.ID)
+
+			if err := env.auth.RequestPasswordReset(context.Background(), usr.Email); err != nil {
+				t.Fatalf("RequestPasswordReset(): %v", err)
+			}
+			env.auth.Wait()
+			token := tt.token(t, env, env.mailer.lastSecret(t, usr.Email))
+
+			err := env.auth.ResetPassword(context.Background(), token, tt.newPassword)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResetPassword() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Login() with the old password error = %v, want %v", err, ErrInvalidCredentials)
+			}
+			if _, err := env.auth.Login(context.Background(), usr.Email, tt.newPassword, app.ID, ""); err != nil {
+				t.Errorf("Login() with the new password: %v", err)
+			}
+			if _, err := env.auth.Refresh(context.Background(), session.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Refresh() of a session before the reset error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := env.auth.RequestPasswordReset(context.Background(), "unknown@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset(): %v", err)
+	}
+	env.auth.Wait()
+	if mails := env.mailer.to("unknown@example.com"); len(mails) != 0 {
+		t.Errorf("%d emails sent to an unknown address", len(mails))
+	}
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordlessCodeDigits is the length of mailed login codes.
+const passwordlessCodeDigits = 6
+
+var (
+	ErrUnknownPasswordlessMethod = errors.New("unknown passwordless login method")
+	ErrInvalidLoginCode          = errors.New("invalid login code")
+)
+
+// Passwordless configures login with a link or code mailed to the user.
+type Passwordless struct {
+	// TTL is how long a link or code is valid
+	TTL time.Duration
+	// MaxAttempts is how many wrong codes a challenge takes before it
+	// stops working
+	MaxAttempts int
+	// LinkURL is the page of the app that completes the login, the token
+	// is added to it as the token query parameter. Without it the bare
+	// token is mailed.
+	LinkURL string
+}
+
+type PasswordlessStorage interface {
+	SavePasswordlessChallenge(ctx context.Context, challenge models.PasswordlessChallenge) (int64, error)
+	PasswordlessChallengeBySecret(ctx context.Context, secretHash []byte) (models.PasswordlessChallenge, error)
+	ActivePasswordlessChallenge(ctx context.Context, userID int64, appID int) (models.PasswordlessChallenge, error)
+	RecordPasswordlessAttempt(ctx context.Context, challengeID int64, maxAttempts int) (int, error)
+	UsePasswordlessChallenge(ctx context.Context, challengeID int64) error
+}
+
+// StartPasswordlessLogin mails the user a single-use login link or code
+// for the app, replacing the one sent before. The email is sent in the
+// background and the call succeeds for any email of a known app, so
+// neither its result nor its timing tells whether the email is registered.
+func (a *Auth) StartPasswordlessLogin(ctx context.Context, email string, appID int, method string) error {
+	const op = "auth.StartPasswordlessLogin"
+
+	email = normalizeEmail(email)
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("method", method),
+	)
+	log.Info("starting passwordless login")
+
+	if method != models.PasswordlessLink && method != models.PasswordlessCode {
+		return fmt.Errorf("%s: %w", op, ErrUnknownPasswordlessMethod)
+	}
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.background(ctx, func(ctx context.Context) {
+		if err := a.sendPasswordlessLogin(ctx, log, email, app, method); err != nil {
+			log.Error("failed to send passwordless login email", slog.String("error", err.Error()))
+		}
+	})
+	return nil
+}
+
+// sendPasswordlessLogin mails a new login link or code for the app to the
+// user with the email, unknown emails are skipped.
+func (a *Auth) sendPasswordlessLogin(ctx context.Context, log *slog.Logger, email string, app models.App, method string) error {
+	usr, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return nil
+		}
+		return err
+	}
+	log = log.With(slog.Int64("user_id", usr.ID))
+
+	var secret string
+	var secretHash []byte
+	if method == models.PasswordlessLink {
+		secret, err = securetoken.New()
+		secretHash = securetoken.Hash(secret)
+	} else {
+		secret, err = newPasswordlessCode()
+		if err == nil {
+			secretHash, err = bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(a.passwordless.TTL)
+	_, err = a.passwordlessChallenges.SavePasswordlessChallenge(ctx, models.PasswordlessChallenge{
+		UserID:     usr.ID,
+		AppID:      app.ID,
+		Method:     method,
+		SecretHash: secretHash,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	var body string
+	if method == models.PasswordlessLink {
+		body = fmt.Sprintf("Use this link to log in to %s:\n\n%s\n\nThe link expires at %s. "+
+			"If you didn't try to log in, ignore this email.",
+			app.Name, a.passwordlessLink(secret), expiresAt.UTC().Format(time.RFC1123))
+	} else {
+		body = fmt.Sprintf("Your code to log in to %s:\n\n%s\n\nThe code expires at %s. "+
+			"If you didn't try to log in, ignore this email.",
+			app.Name, secret, expiresAt.UTC().Format(time.RFC1123))
+	}
+	if err := a.mailer.Send(ctx, usr.Email, "Log in to "+app.Name, body); err != nil {
+		return err
+	}
+	log.Info("passwordless login email sent")
+	return nil
+}
+
+// CompletePasswordlessLogin logs the user in with the token of a mailed
+// link or, when token is empty, with the mailed code for the email and
+// app. It issues tokens the same way as Login, users with MFA enabled get
+// an MFA challenge token instead. Wrong codes are throttled the same way
+// as wrong passwords.
+func (a *Auth) CompletePasswordlessLogin(ctx context.Context, token string, email string, appID int, code string, clientIP string) (models.LoginResult, error) {
+	const op = "auth.CompletePasswordlessLogin"
+
+	email = normalizeEmail(email)
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("completing passwordless login")
+
+	var challenge models.PasswordlessChallenge
+	var usr models.User
+	var err error
+	if token != "" {
+		challenge, usr, err = a.passwordlessLinkChallenge(ctx, log, token, clientIP)
+	} else {
+		challenge, usr, err = a.passwordlessCodeChallenge(ctx, log, email, appID, code, clientIP)
+	}
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log = log.With(slog.Int64("user_id", usr.ID))
+
+	if err := a.passwordlessChallenges.UsePasswordlessChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) {
+			log.Info("passwordless challenge already used")
+			return models.LoginResult{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	mfaEnabled, err := a.mfaEnabled(ctx, usr.ID)
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if mfaEnabled {
+		mfaToken, err := a.newMFAChallenge(ctx, usr.ID, challenge.AppID)
+		if err != nil {
+			log.Error("failed to create mfa challenge", slog.String("error", err.Error()))
+			return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("mfa required")
+		return models.LoginResult{MFAToken: mfaToken}, nil
+	}
+
+	if err := a.loginFailures.ResetLoginFailures(ctx, userLoginKey(usr.Email)); err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	tokens, err := a.completeLogin(ctx, log, usr, challenge.AppID)
+	if err != nil {
+		return models.LoginResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return models.LoginResult{Tokens: tokens}, nil
+}
+
+// passwordlessLinkChallenge returns the valid challenge of a link token
+// and its user.
+func (a *Auth) passwordlessLinkChallenge(ctx context.Context, log *slog.Logger, token string, clientIP string) (models.PasswordlessChallenge, models.User, error) {
+	challenge, err := a.passwordlessChallenges.PasswordlessChallengeBySecret(ctx, securetoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("passwordless link not found")
+			return models.PasswordlessChallenge{}, models.User{}, ErrInvalidToken
+		}
+		return models.PasswordlessChallenge{}, models.User{}, err
+	}
+	if challenge.Method != models.PasswordlessLink || challenge.Used {
+		log.Info("passwordless link already used")
+		return models.PasswordlessChallenge{}, models.User{}, ErrInvalidToken
+	}
+	now := time.Now()
+	if now.After(challenge.ExpiresAt) {
+		log.Info("passwordless link expired")
+		return models.PasswordlessChallenge{}, models.User{}, ErrExpiredToken
+	}
+
+	usr, err := a.usrProvider.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		return models.PasswordlessChallenge{}, models.User{}, err
+	}
+	if err := a.checkLoginAllowed(ctx, loginKeys(usr.Email, clientIP), now); err != nil {
+		log.Warn("login throttled", slog.String("error", err.Error()))
+		return models.PasswordlessChallenge{}, models.User{}, err
+	}
+	return challenge, usr, nil
+}
+
+// passwordlessCodeChallenge checks the code against the active challenge
+// of the user for the app and returns the challenge and its user.
+func (a *Auth) passwordlessCodeChallenge(ctx context.Context, log *slog.Logger, email string, appID int, code string, clientIP string) (models.PasswordlessChallenge, models.User, error) {
+	now := time.Now()
+	keys := loginKeys(email, clientIP)
+	if err := a.checkLoginAllowed(ctx, keys, now); err != nil {
+		log.Warn("login throttled", slog.String("error", err.Error()))
+		return models.PasswordlessChallenge{}, models.User{}, err
+	}
+
+	usr, err := a.usrProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found")
+			return models.PasswordlessChallenge{}, models.User{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidLoginCode)
+		}
+		return models.PasswordlessChallenge{}, models.User{}, err
+	}
+	challenge, err := a.passwordlessChallenges.ActivePasswordlessChallenge(ctx, usr.ID, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("no passwordless code sent", slog.Int64("user_id", usr.ID))
+			return models.PasswordlessChallenge{}, models.User{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidLoginCode)
+		}
+		return models.PasswordlessChallenge{}, models.User{}, err
+	}
+	if challenge.Method != models.PasswordlessCode {
+		log.Info("passwordless link sent instead of a code", slog.Int64("user_id", usr.ID))
+		return models.PasswordlessChallenge{}, models.User{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidLoginCode)
+	}
+	if now.After(challenge.ExpiresAt) {
+		log.Info("passwordless code expired", slog.Int64("user_id", usr.ID))
+		return models.PasswordlessChallenge{}, models.User{}, ErrExpiredToken
+	}
+
+	if bcrypt.CompareHashAndPassword(challenge.SecretHash, []byte(code)) != nil {
+		attempts, err := a.passwordlessChallenges.RecordPasswordlessAttempt(ctx, challenge.ID, a.passwordless.MaxAttempts)
+		if err != nil {
+			return models.PasswordlessChallenge{}, models.User{}, err
+		}
+		log.Info("invalid passwordless code", slog.Int64("user_id", usr.ID), slog.Int("attempts", attempts))
+		return models.PasswordlessChallenge{}, models.User{}, a.recordLoginFailure(ctx, keys, now, ErrInvalidLoginCode)
+	}
+	return challenge, usr, nil
+}
+
+// passwordlessLink returns the link mailed for the token.
+func (a *Auth) passwordlessLink(token string) string {
+	if a.passwordless.LinkURL == "" {
+		return token
+	}
+	link, err := url.Parse(a.passwordless.LinkURL)
+	if err != nil {
+		return token
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// newPasswordlessCode returns a random code of passwordlessCodeDigits
+// digits.
+func newPasswordlessCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < passwordlessCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", passwordlessCodeDigits, n.Int64()), nil
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// startPasswordless starts a passwordless login and returns the token of
+// the mailed link or the mailed code.
+func (e *testEnv) startPasswordless(t *testing.T, email string, appID int, method string) string {
+	t.Helper()
+
+	if err := e.auth.StartPasswordlessLogin(context.Background(), email, appID, method); err != nil {
+		t.Fatalf("StartPasswordlessLogin(): %v", err)
+	}
+	e.auth.Wait()
+	secret := e.mailer.lastSecret(t, email)
+	if method == models.PasswordlessCode {
+		return secret
+	}
+	link, err := url.Parse(secret)
+	if err != nil {
+		t.Fatalf("parse link %s: %v", secret, err)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordlessLink(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// present returns the token to complete the login with, given the
+		// mailed one
+		present func(t *testing.T, env *testEnv, token string) string
+		wantErr error
+	}{
+		{
+			name:    "mailed token",
+			present: func(t *testing.T, env *testEnv, token string) string { return token },
+		},
+		{
+			name:    "unknown token",
+			present: func(t *testing.T, env *testEnv, token string) string { return "unknown" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:      "expired token",
+			configure: func(cfg *Config) { cfg.Passwordless.TTL = -time.Minute },
+			present:   func(t *testing.T, env *testEnv, token string) string { return token },
+			wantErr:   ErrExpiredToken,
+		},
+		{
+			name: "used token",
+			present: func(t *testing.T, env *testEnv, token string) string {
+				if _, err := env.auth.CompletePasswordlessLogin(context.Background(), token, "", 0, "", ""); err != nil {
+					t.Fatalf("first CompletePasswordlessLogin(): %v", err)
+				}
+				return token
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			token := env.startPasswordless(t, usr.Email, app.ID, models.PasswordlessLink)
+
+			res, err := env.auth.CompletePasswordlessLogin(context.Background(), tt.present(t, env, token), "", 0, "", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompletePasswordlessLogin() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			claims, err := env.auth.ValidateToken(context.Background(), res.Tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken(): %v", err)
+			}
+			if claims.UID != usr.ID || claims.AppID != app.ID {
+				t.Errorf("claims = %+v, want user %d in app %d", claims, usr.ID, app.ID)
+			}
+		})
+	}
+}
+
+func TestPasswordlessCode(t *testing.T) {
+	tests := []struct {
+		name string
+		// code returns the email and code to complete the login with,
+		// given the mailed code
+		code    func(t *testing.T, env *testEnv, appID int, code string) (string, string)
+		wantErr error
+	}{
+		{
+			name: "mailed code",
+			code: func(t *testing.T, env *testEnv, appID int, code string) (string, string) {
+				return "user@example.com", code
+			},
+		},
+		{
+			name: "wrong code",
+			code: func(t *testing.T, env *testEnv, appID int, code string) (string, string) {
+				return "user@example.com", wrongCode(code)
+			},
+			wantErr: ErrInvalidLoginCode,
+		},
+		{
+			name: "code of another user",
+			code: func(t *testing.T, env *testEnv, appID int, code string) (string, string) {
+				env.registerUser(t, "other@example.com")
+				return "other@example.com", code
+			},
+			wantErr: ErrInvalidLoginCode,
+		},
+		{
+			name: "unknown email",
+			code: func(t *testing.T, env *testEnv, appID int, code string) (string, string) {
+				return "unknown@example.com", code
+			},
+			wantErr: ErrInvalidLoginCode,
+		},
+		{
+			name: "replaced code",
+			code: func(t *testing.T, env *testEnv, appID int, code string) (string, string) {
+				env.startPasswordless(t, "user@example.com", appID, models.PasswordlessCode)
+				return "user@example.com", code
+			},
+			wantErr: ErrInvalidLoginCode,
+		},
+		{
+			name: "link sent after the code",
+			code: func(t *testing.T, env *testEnv, appID int, code string) (string, string) {
+				env.startPasswordless(t, "user@example.com", appID, models.PasswordlessLink)
+				return "user@example.com", code
+			},
+			wantErr: ErrInvalidLoginCode,
+		},
+		{
+			name: "attempts used up",
+			code: func(t *testing.T, env *testEnv, appID int, code string) (string, string) {
+				for i := 0; i < env.auth.passwordless.MaxAttempts; i++ {
+					_, err := env.auth.CompletePasswordlessLogin(context.Background(), "", "user@example.com", appID, wrongCode(code), "")
+					if !errors.Is(err, ErrInvalidLoginCode) {
+						t.Fatalf("CompletePasswordlessLogin() with wrong code error = %v, want %v", err, ErrInvalidLoginCode)
+					}
+				}
+				return "user@example.com", code
+			},
+			wantErr: ErrInvalidLoginCode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			mailed := env.startPasswordless(t, usr.Email, app.ID, models.PasswordlessCode)
+			if len(mailed) != passwordlessCodeDigits {
+				t.Fatalf("mailed code %q has %d digits, want %d", mailed, len(mailed), passwordlessCodeDigits)
+			}
+			email, code := tt.code(t, env, app.ID, mailed)
+
+			res, err := env.auth.CompletePasswordlessLogin(context.Background(), "", email, app.ID, code, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompletePasswordlessLogin() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if _, err := env.auth.ValidateToken(context.Background(), res.Tokens.AccessToken); err != nil {
+				t.Errorf("ValidateToken(): %v", err)
+			}
+		})
+	}
+}
+
+// wrongCode returns a code that differs from the code.
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestPasswordlessLoginWithMFA(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	env.enableTOTP(t, env.userContext(t, usr.Email, app.ID))
+	token := env.startPasswordless(t, usr.Email, app.ID, models.PasswordlessLink)
+
+	res, err := env.auth.CompletePasswordlessLogin(context.Background(), token, "", 0, "", "")
+	if err != nil {
+		t.Fatalf("CompletePasswordlessLogin(): %v", err)
+	}
+	if res.MFAToken == "" || res.Tokens.AccessToken != "" {
+		t.Errorf("CompletePasswordlessLogin() = %+v, want an mfa challenge only", res)
+	}
+}
+
+func TestStartPasswordlessLogin(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+
+	tests := []struct {
+		name    string
+		email   string
+		appID   int
+		method  string
+		wantErr error
+	}{
+		{name: "unknown email", email: "unknown@example.com", appID: app.ID, method: models.PasswordlessCode},
+		{name: "unknown method", email: "user@example.com", appID: app.ID, method: "sms", wantErr: ErrUnknownPasswordlessMethod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := env.auth.StartPasswordlessLogin(context.Background(), tt.email, tt.appID, tt.method)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StartPasswordlessLogin() error = %v, want %v", err, tt.wantErr)
+			}
+			env.auth.Wait()
+			if mails := env.mailer.to(tt.email); len(mails) != 0 {
+				t.Errorf("%d emails sent to %s, want none", len(mails), tt.email)
+			}
+		})
+	}
+}
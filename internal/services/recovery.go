@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"auth/internal/lib/principal"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeSize is the number of random bytes in a code, it gives
+	// 10 base32 characters
+	recoveryCodeSize = 6
+)
+
+var ErrRecoveryCodesExist = errors.New("recovery codes already generated")
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type RecoveryCodeStorage interface {
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes [][]byte, now time.Time) error
+	RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, codeID int64, now time.Time) error
+}
+
+// GenerateRecoveryCodes returns a set of single-use recovery codes for the
+// caller. They are shown only once, so it fails if the caller still has
+// unused codes, RegenerateRecoveryCodes replaces them.
+func (a *Auth) GenerateRecoveryCodes(ctx context.Context) ([]string, error) {
+	const op = "auth.GenerateRecoveryCodes"
+
+	codes, err := a.generateRecoveryCodes(ctx, op, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes returns a new set of recovery codes for the
+// caller, the previous codes stop working.
+func (a *Auth) RegenerateRecoveryCodes(ctx context.Context) ([]string, error) {
+	const op = "auth.RegenerateRecoveryCodes"
+
+	codes, err := a.generateRecoveryCodes(ctx, op, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return codes, nil
+}
+
+// LoginWithRecoveryCode passes an MFA challenge with a recovery code in
+// place of a code of the authenticator app, so the password is still
+// required. No session is issued: it returns a password reset token and the
+// user logs in again once ResetPassword sets a new password. The code is
+// used up and MFA stays enabled. Wrong codes are throttled the same way as
+// wrong passwords.
+func (a *Auth) LoginWithRecoveryCode(ctx context.Context, mfaToken string, code string, clientIP string) (string, error) {
+	const op = "auth.LoginWithRecoveryCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("attempting to login with recovery code")
+
+	usr, _, err := a.passMFAChallenge(ctx, log, mfaToken, clientIP, func(usr models.User) error {
+		codes, err := a.recoveryCodes.RecoveryCodes(ctx, usr.ID)
+		if err != nil {
+			return err
+		}
+		matched, ok := matchRecoveryCode(codes, code)
+		if !ok {
+			log.Info("invalid recovery code", slog.Int64("user_id", usr.ID))
+			return ErrInvalidMFACode
+		}
+		if err := a.recoveryCodes.UseRecoveryCode(ctx, matched.ID, time.Now()); err != nil {
+			if errors.Is(err, storage.ErrTokenUsed) {
+				log.Info("recovery code already used", slog.Int64("user_id", usr.ID))
+				return ErrInvalidMFACode
+			}
+			return err
+		}
+		// audit record of the break-glass login
+		log.Warn("recovery code used",
+			slog.Int64("user_id", usr.ID),
+			slog.String("client_ip", clientIP),
+			slog.Int64("recovery_code_id", matched.ID),
+			slog.Int("codes_left", len(codes)-1),
+		)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, _, err := a.newPasswordResetToken(ctx, usr.ID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("password reset required", slog.Int64("user_id", usr.ID))
+	return token, nil
+}
+
+func (a *Auth) generateRecoveryCodes(ctx context.Context, op string, replace bool) ([]string, error) {
+	claims, ok := principal.FromContext(ctx)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", claims.UID),
+	)
+	log.Info("generating recovery codes")
+
+	if !replace {
+		existing, err := a.recoveryCodes.RecoveryCodes(ctx, claims.UID)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			log.Info("recovery codes already generated")
+			return nil, ErrRecoveryCodesExist
+		}
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		codes[i], hashes[i] = code, hash
+	}
+	if err := a.recoveryCodes.ReplaceRecoveryCodes(ctx, claims.UID, hashes, time.Now()); err != nil {
+		return nil, err
+	}
+	log.Info("recovery codes generated")
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as xxxxx-xxxxx.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode drops formatting users may type differently.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func matchRecoveryCode(codes []models.RecoveryCode, code string) (models.RecoveryCode, bool) {
+	normalized := []byte(normalizeRecoveryCode(code))
+	for _, c := range codes {
+		if bcrypt.CompareHashAndPassword(c.CodeHash, normalized) == nil {
+			return c, true
+		}
+	}
+	return models.RecoveryCode{}, false
+}
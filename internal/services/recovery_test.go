@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	ctx := env.userContext(t, usr.Email, app.ID)
+
+	codes, err := env.auth.GenerateRecoveryCodes(ctx)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes(): %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("GenerateRecoveryCodes() returned %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q returned twice", code)
+		}
+		seen[code] = true
+	}
+
+	if _, err := env.auth.GenerateRecoveryCodes(ctx); !errors.Is(err, ErrRecoveryCodesExist) {
+		t.Errorf("GenerateRecoveryCodes() again error = %v, want %v", err, ErrRecoveryCodesExist)
+	}
+	if _, err := env.auth.GenerateRecoveryCodes(context.Background()); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("GenerateRecoveryCodes() without caller error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestLoginWithRecoveryCode(t *testing.T) {
+	tests := []struct {
+		name string
+		// code returns the recovery code to login with, given the
+		// generated ones
+		code    func(t *testing.T, env *testEnv, ctx context.Context, codes []string, appID int) string
+		wantErr error
+	}{
+		{
+			name: "generated code",
+			code: func(t *testing.T, env *testEnv, ctx context.Context, codes []string, appID int) string {
+				return codes[0]
+			},
+		},
+		{
+			name: "code typed differently",
+			code: func(t *testing.T, env *testEnv, ctx context.Context, codes []string, appID int) string {
+				return " " + strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+			},
+		},
+		{
+			name: "wrong code",
+			code: func(t *testing.T, env *testEnv, ctx context.Context, codes []string, appID int) string {
+				return "aaaaa-aaaaa"
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name: "used code",
+			code: func(t *testing.T, env *testEnv, ctx context.Context, codes []string, appID int) string {
+				mfaToken := env.mfaChallenge(t, "user@example.com", appID)
+				if _, err := env.auth.LoginWithRecoveryCode(context.Background(), mfaToken, codes[0], ""); err != nil {
+					t.Fatalf("first LoginWithRecoveryCode(): %v", err)
+				}
+				return codes[0]
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name: "regenerated code",
+			code: func(t *testing.T, env *testEnv, ctx context.Context, codes []string, appID int) string {
+				if _, err := env.auth.RegenerateRecoveryCodes(ctx); err != nil {
+					t.Fatalf("RegenerateRecoveryCodes(): %v", err)
+				}
+				return codes[0]
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			ctx := env.userContext(t, usr.Email, app.ID)
+			env.enableTOTP(t, ctx)
+			codes, err := env.auth.GenerateRecoveryCodes(ctx)
+			if err != nil {
+				t.Fatalf("GenerateRecoveryCodes(): %v", err)
+			}
+			code := tt.code(t, env, ctx, codes, app.ID)
+
+			resetToken, err := env.auth.LoginWithRecoveryCode(context.Background(), env.mfaChallenge(t, usr.Email, app.ID), code, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoginWithRecoveryCode() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			// no session is issued until the password is reset
+			const newPassword = "recovered-Passw0rd"
+			if err := env.auth.ResetPassword(context.Background(), resetToken, newPassword); err != nil {
+				t.Fatalf("ResetPassword(): %v", err)
+			}
+			if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Login() with old password error = %v, want %v", err, ErrInvalidCredentials)
+			}
+			// mfa stays enabled, the next login is challenged again
+			res, err := env.auth.Login(context.Background(), usr.Email, newPassword, app.ID, "")
+			if err != nil {
+				t.Fatalf("Login() with new password: %v", err)
+			}
+			if res.MFAToken == "" || res.Tokens.AccessToken != "" {
+				t.Errorf("Login() = %+v, want an mfa challenge only", res)
+			}
+		})
+	}
+}
+
+func TestRegenerateRecoveryCodes(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	ctx := env.userContext(t, usr.Email, app.ID)
+	env.enableTOTP(t, ctx)
+	if _, err := env.auth.GenerateRecoveryCodes(ctx); err != nil {
+		t.Fatalf("GenerateRecoveryCodes(): %v", err)
+	}
+
+	codes, err := env.auth.RegenerateRecoveryCodes(ctx)
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes(): %v", err)
+	}
+	if _, err := env.auth.LoginWithRecoveryCode(context.Background(), env.mfaChallenge(t, usr.Email, app.ID), codes[len(codes)-1], ""); err != nil {
+		t.Errorf("LoginWithRecoveryCode() with regenerated code: %v", err)
+	}
+}
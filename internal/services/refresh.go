@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Refresh exchanges a refresh token for a new token pair. Every refresh
+// token can be used once: presenting an already rotated token revokes the
+// whole family it belongs to, since it means the token has leaked.
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (models.TokenPair, error) {
+	const op = "auth.Refresh"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("refreshing tokens")
+
+	current, err := a.refreshTokens.RefreshToken(ctx, securetoken.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Info("refresh token not found")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", current.UserID), slog.String("family_id", current.FamilyID))
+
+	if current.Revoked {
+		log.Info("refresh token revoked")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	if current.Rotated {
+		return models.TokenPair{}, a.revokeReusedFamily(ctx, log, op, current.FamilyID)
+	}
+	if time.Now().After(current.ExpiresAt) {
+		log.Info("refresh token expired")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrExpiredToken)
+	}
+
+	usr, err := a.usrProvider.UserByID(ctx, current.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	app, err := a.appProvider.App(ctx, current.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found")
+			return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	isMember, err := a.members.IsMember(ctx, usr.ID, app.ID)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !isMember {
+		log.Info("user is no longer a member of the app")
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, ErrNotMember)
+	}
+
+	tokens, err := a.issueTokens(ctx, usr, app, current.FamilyID, current.ID, current.Scopes)
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenRotated) {
+			// a concurrent request has rotated the token first
+			return models.TokenPair{}, a.revokeReusedFamily(ctx, log, op, current.FamilyID)
+		}
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return models.TokenPair{}, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("tokens refreshed")
+	return tokens, nil
+}
+
+func (a *Auth) revokeReusedFamily(ctx context.Context, log *slog.Logger, op string, familyID string) error {
+	log.Warn("refresh token reuse detected, revoking token family")
+	if err := a.refreshTokens.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		log.Error("failed to revoke token family", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+}
+
+// issueTokens creates an access token and a refresh token in the given
+// family. If previousID is not zero the refresh token with that id is
+// rotated, otherwise a new family is started.
+func (a *Auth) issueTokens(ctx context.Context, usr models.User, app models.App, familyID string, previousID int64, scopes []string) (models.TokenPair, error) {
+	key, err := a.signingKey(ctx, app)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	access, err := a.roles.UserAccess(ctx, usr.ID, app.ID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	expiresAt := time.Now().Add(a.tokenTTL)
+	accessToken, err := jwt.NewToken(a.issuer, usr, app, access, scopes, key, a.tokenTTL)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	refreshToken, err := securetoken.New()
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	next := models.RefreshToken{
+		TokenHash: securetoken.Hash(refreshToken),
+		FamilyID:  familyID,
+		UserID:    usr.ID,
+		AppID:     app.ID,
+		ExpiresAt: time.Now().Add(a.refreshTokenTTL),
+		Scopes:    scopes,
+	}
+	if previousID == 0 {
+		_, err = a.refreshTokens.SaveRefreshToken(ctx, next)
+	} else {
+		_, err = a.refreshTokens.RotateRefreshToken(ctx, previousID, next)
+	}
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return models.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresAt: expiresAt}, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// present returns the refresh token to refresh with, given the
+		// one issued on login
+		present func(t *testing.T, env *testEnv, refreshToken string) string
+		wantErr error
+	}{
+		{
+			name:    "issued token",
+			present: func(t *testing.T, env *testEnv, refreshToken string) string { return refreshToken },
+		},
+		{
+			name:    "unknown token",
+			present: func(t *testing.T, env *testEnv, refreshToken string) string { return "unknown" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:      "expired token",
+			configure: func(cfg *Config) { cfg.RefreshTokenTTL = -time.Minute },
+			present:   func(t *testing.T, env *testEnv, refreshToken string) string { return refreshToken },
+			wantErr:   ErrExpiredToken,
+		},
+		{
+			name: "rotated token",
+			present: func(t *testing.T, env *testEnv, refreshToken string) string {
+				if _, err := env.auth.Refresh(context.Background(), refreshToken); err != nil {
+					t.Fatalf("first refresh: %v", err)
+				}
+				return refreshToken
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			issued := env.login(t, usr.Email, app.ID)
+
+			tokens, err := env.auth.Refresh(context.Background(), tt.present(t, env, issued.RefreshToken))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Refresh() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if tokens.RefreshToken == issued.RefreshToken {
+				t.Error("Refresh() returned the presented refresh token")
+			}
+			claims, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken() of refreshed access token: %v", err)
+			}
+			if claims.UID != usr.ID || claims.AppID != app.ID {
+				t.Errorf("refreshed claims = %+v, want user %d in app %d", claims, usr.ID, app.ID)
+			}
+		})
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	stolen := env.login(t, usr.Email, app.ID)
+	other := env.login(t, usr.Email, app.ID)
+
+	rotated, err := env.auth.Refresh(context.Background(), stolen.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh(): %v", err)
+	}
+	if _, err := env.auth.Refresh(context.Background(), stolen.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Refresh() of reused token error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	tests := []struct {
+		name         string
+		refreshToken string
+		wantErr      error
+	}{
+		{name: "token rotated before reuse", refreshToken: rotated.RefreshToken, wantErr: ErrInvalidToken},
+		{name: "token of another login", refreshToken: other.RefreshToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := env.auth.Refresh(context.Background(), tt.refreshToken)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Refresh() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"auth/internal/lib/jwt"
+	"auth/internal/lib/securetoken"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Logout revokes the access token and, if given, the refresh token family
+// of the session. With everywhere set every token of the user is revoked.
+func (a *Auth) Logout(ctx context.Context, token string, refreshToken string, everywhere bool) error {
+	const op = "auth.Logout"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("logging out user")
+
+	claims, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UID))
+
+	if everywhere {
+		if err := a.revokeUserSessions(ctx, claims.UID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("user logged out everywhere")
+		a.pruneRevocations(ctx, log)
+		return nil
+	}
+
+	if err := a.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if refreshToken != "" {
+		current, err := a.refreshTokens.RefreshToken(ctx, securetoken.Hash(refreshToken))
+		switch {
+		case errors.Is(err, storage.ErrRefreshTokenNotFound):
+			log.Info("refresh token not found")
+		case err != nil:
+			return fmt.Errorf("%s: %w", op, err)
+		case current.UserID != claims.UID:
+			log.Warn("refresh token belongs to another user")
+		default:
+			if err := a.refreshTokens.RevokeRefreshTokenFamily(ctx, current.FamilyID); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+	}
+	log.Info("user logged out")
+	a.pruneRevocations(ctx, log)
+	return nil
+}
+
+// RevokeToken revokes an access or a refresh token. Following RFC 7009,
+// tokens that are unknown, invalid or already expired are not an error.
+func (a *Auth) RevokeToken(ctx context.Context, token string) error {
+	const op = "auth.RevokeToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("revoking token")
+
+	claims, err := a.parseToken(ctx, token)
+	switch {
+	case err == nil:
+		if err := a.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("access token revoked", slog.Int64("user_id", claims.UID))
+		a.pruneRevocations(ctx, log)
+		return nil
+	case errors.Is(err, jwt.ErrExpiredToken):
+		log.Info("access token already expired")
+		return nil
+	case !errors.Is(err, jwt.ErrInvalidToken) && !errors.Is(err, storage.ErrAppNotFound):
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// not an access token, try it as a refresh token
+	current, err := a.refreshTokens.RefreshToken(ctx, securetoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Info("unknown token")
+			return nil
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.refreshTokens.RevokeRefreshTokenFamily(ctx, current.FamilyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("refresh token revoked", slog.Int64("user_id", current.UserID))
+	return nil
+}
+
+// revokeUserSessions revokes all access and refresh tokens issued to the user so far.
+func (a *Auth) revokeUserSessions(ctx context.Context, userID int64) error {
+	now := time.Now()
+	if err := a.revocations.RevokeUserTokens(ctx, userID, now, now.Add(a.tokenTTL)); err != nil {
+		return err
+	}
+	return a.refreshTokens.RevokeUserRefreshTokens(ctx, userID)
+}
+
+// pruneRevocations drops records of revoked tokens that have expired since.
+// Expired tokens are accepted for the leeway, so records are kept for as
+// long. A failure is only logged as the revocation itself has succeeded.
+func (a *Auth) pruneRevocations(ctx context.Context, log *slog.Logger) {
+	if err := a.revocations.PruneRevocations(ctx, time.Now().Add(-a.tokenLeeway)); err != nil {
+		log.Error("failed to prune revocations", slog.String("error", err.Error()))
+	}
+}
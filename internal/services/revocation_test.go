@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitNextSecond sleeps until the wall clock enters the next second.
+// Revocations of all tokens of a user have a precision of a second, like
+// the iat claim they are compared with.
+func waitNextSecond() {
+	now := time.Now()
+	time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name             string
+		withRefresh      bool
+		everywhere       bool
+		wantRefreshErr   error
+		wantOtherAccess  error
+		wantOtherRefresh error
+	}{
+		{name: "access token only"},
+		{name: "with refresh token", withRefresh: true, wantRefreshErr: ErrInvalidToken},
+		{
+			name:             "everywhere",
+			everywhere:       true,
+			wantRefreshErr:   ErrInvalidToken,
+			wantOtherAccess:  ErrInvalidToken,
+			wantOtherRefresh: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			session := env.login(t, usr.Email, app.ID)
+			other := env.login(t, usr.Email, app.ID)
+			waitNextSecond()
+
+			refreshToken := ""
+			if tt.withRefresh {
+				refreshToken = session.RefreshToken
+			}
+			if err := env.auth.Logout(context.Background(), session.AccessToken, refreshToken, tt.everywhere); err != nil {
+				t.Fatalf("Logout(): %v", err)
+			}
+
+			if _, err := env.auth.ValidateToken(context.Background(), session.AccessToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("ValidateToken() of logged out token error = %v, want %v", err, ErrInvalidToken)
+			}
+			if _, err := env.auth.Refresh(context.Background(), session.RefreshToken); !errors.Is(err, tt.wantRefreshErr) {
+				t.Errorf("Refresh() of the session error = %v, want %v", err, tt.wantRefreshErr)
+			}
+			if _, err := env.auth.ValidateToken(context.Background(), other.AccessToken); !errors.Is(err, tt.wantOtherAccess) {
+				t.Errorf("ValidateToken() of another session error = %v, want %v", err, tt.wantOtherAccess)
+			}
+			if _, err := env.auth.Refresh(context.Background(), other.RefreshToken); !errors.Is(err, tt.wantOtherRefresh) {
+				t.Errorf("Refresh() of another session error = %v, want %v", err, tt.wantOtherRefresh)
+			}
+		})
+	}
+}
+
+func TestLogoutRefreshTokenOfAnotherUser(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	victim := env.registerUser(t, "victim@example.com")
+	session := env.login(t, usr.Email, app.ID)
+	victimSession := env.login(t, victim.Email, app.ID)
+
+	if err := env.auth.Logout(context.Background(), session.AccessToken, victimSession.RefreshToken, false); err != nil {
+		t.Fatalf("Logout(): %v", err)
+	}
+	if _, err := env.auth.Refresh(context.Background(), victimSession.RefreshToken); err != nil {
+		t.Errorf("Refresh() of another user's session: %v", err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	tests := []struct {
+		name string
+		// token returns the token to revoke and a check that it no longer
+		// works
+		token func(t *testing.T) (string, func() error)
+	}{
+		{
+			name: "access token",
+			token: func(t *testing.T) (string, func() error) {
+				tokens := env.login(t, usr.Email, app.ID)
+				return tokens.AccessToken, func() error {
+					_, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken)
+					return err
+				}
+			},
+		},
+		{
+			name: "refresh token",
+			token: func(t *testing.T) (string, func() error) {
+				tokens := env.login(t, usr.Email, app.ID)
+				return tokens.RefreshToken, func() error {
+					_, err := env.auth.Refresh(context.Background(), tokens.RefreshToken)
+					return err
+				}
+			},
+		},
+		{
+			name: "expired access token",
+			token: func(t *testing.T) (string, func() error) {
+				return env.signToken(t, usr, app, -time.Minute), nil
+			},
+		},
+		{
+			name: "unknown token",
+			token: func(t *testing.T) (string, func() error) {
+				return "unknown", nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, check := tt.token(t)
+			if err := env.auth.RevokeToken(context.Background(), token); err != nil {
+				t.Fatalf("RevokeToken(): %v", err)
+			}
+			if check == nil {
+				return
+			}
+			if err := check(); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("revoked token error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestLoginAfterLogoutEverywhere(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+	session := env.login(t, usr.Email, app.ID)
+	if err := env.auth.Logout(context.Background(), session.AccessToken, "", true); err != nil {
+		t.Fatalf("Logout(): %v", err)
+	}
+	waitNextSecond()
+
+	tokens := env.login(t, usr.Email, app.ID)
+	if _, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken); err != nil {
+		t.Errorf("ValidateToken() of token issued after revocation: %v", err)
+	}
+}
+
+func TestRevokeTokenWithinLeeway(t *testing.T) {
+	tests := []struct {
+		name    string
+		revoke  bool
+		wantErr error
+	}{
+		{name: "expired within leeway"},
+		{name: "revoked after expiry", revoke: true, wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, func(cfg *Config) { cfg.TokenLeeway = time.Minute })
+			app := env.openApp(t)
+			usr := env.registerUser(t, "user@example.com")
+			token := env.signToken(t, usr, app, -10*time.Second)
+
+			if tt.revoke {
+				// revoking prunes expired records, this one is kept while
+				// the token is still accepted
+				if err := env.auth.RevokeToken(context.Background(), token); err != nil {
+					t.Fatalf("RevokeToken(): %v", err)
+				}
+			}
+			if _, err := env.auth.ValidateToken(context.Background(), token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestAssignRole(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	other := env.openApp(t)
+	ctx := env.adminContext(t, app.ID)
+	usr := env.registerUser(t, "user@example.com")
+
+	tests := []struct {
+		name    string
+		userID  int64
+		role    string
+		appID   int
+		wantErr error
+	}{
+		{name: "role in app", userID: usr.ID, role: "admin", appID: app.ID},
+		{name: "unknown role", userID: usr.ID, role: "unknown", appID: app.ID, wantErr: storage.ErrRoleNotFound},
+		{name: "unknown user", userID: usr.ID + 100, role: "admin", appID: app.ID, wantErr: storage.ErrUserNotFound},
+		{name: "unknown app", userID: usr.ID, role: "admin", appID: other.ID + 100, wantErr: storage.ErrAppNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := env.auth.AssignRole(ctx, tt.userID, tt.role, tt.appID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AssignRole() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the role applies in its app only
+	permissions := []struct {
+		appID int
+		want  bool
+	}{
+		{appID: app.ID, want: true},
+		{appID: other.ID, want: false},
+	}
+	for _, p := range permissions {
+		has, err := env.auth.CheckPermission(ctx, usr.ID, p.appID, PermManageApps)
+		if err != nil {
+			t.Fatalf("CheckPermission(): %v", err)
+		}
+		if has != p.want {
+			t.Errorf("CheckPermission() in app %d = %v, want %v", p.appID, has, p.want)
+		}
+	}
+
+	claims, err := env.auth.ValidateToken(context.Background(), env.login(t, usr.Email, app.ID).AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken(): %v", err)
+	}
+	if !slices.Contains(claims.Roles, "admin") || !slices.Contains(claims.Permissions, PermManageApps) {
+		t.Errorf("token roles = %v, permissions = %v, want the admin role", claims.Roles, claims.Permissions)
+	}
+}
+
+func TestAssignRoleRequiresPermission(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	other := env.openApp(t)
+	manager := env.registerUser(t, "manager@example.com")
+	usr := env.registerUser(t, "user@example.com")
+	if err := env.storage.AssignRole(context.Background(), manager.ID, "admin", app.ID); err != nil {
+		t.Fatalf("assign role: %v", err)
+	}
+	ctx := env.userContext(t, manager.Email, app.ID)
+
+	tests := []struct {
+		name    string
+		appID   int
+		wantErr error
+	}{
+		{name: "app the caller manages", appID: app.ID},
+		{name: "another app", appID: other.ID, wantErr: ErrPermissionDenied},
+		{name: "every app", appID: 0, wantErr: ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := env.auth.AssignRole(ctx, usr.ID, "admin", tt.appID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AssignRole() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevokeRole(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	ctx := env.adminContext(t, app.ID)
+	usr := env.registerUser(t, "user@example.com")
+	if err := env.auth.AssignRole(ctx, usr.ID, "admin", app.ID); err != nil {
+		t.Fatalf("AssignRole(): %v", err)
+	}
+	tokens := env.login(t, usr.Email, app.ID)
+	waitNextSecond()
+
+	if err := env.auth.RevokeRole(ctx, usr.ID, "admin", app.ID); err != nil {
+		t.Fatalf("RevokeRole(): %v", err)
+	}
+	has, err := env.auth.CheckPermission(ctx, usr.ID, app.ID, PermManageApps)
+	if err != nil {
+		t.Fatalf("CheckPermission(): %v", err)
+	}
+	if has {
+		t.Error("CheckPermission() = true after the role was revoked")
+	}
+	// the access token carries the revoked role
+	if _, err := env.auth.ValidateToken(context.Background(), tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken() error = %v, want %v", err, ErrInvalidToken)
+	}
+	refreshed, err := env.auth.Refresh(context.Background(), tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh(): %v", err)
+	}
+	claims, err := env.auth.ValidateToken(context.Background(), refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken() of refreshed token: %v", err)
+	}
+	if len(claims.Roles) != 0 {
+		t.Errorf("refreshed token roles = %v, want none", claims.Roles)
+	}
+}
+
+func TestListRoles(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	admin := env.adminContext(t, app.ID)
+	usr := env.registerUser(t, "user@example.com")
+	other := env.registerUser(t, "other@example.com")
+	if err := env.auth.AssignRole(admin, other.ID, "admin", app.ID); err != nil {
+		t.Fatalf("AssignRole(): %v", err)
+	}
+	ctx := env.userContext(t, usr.Email, app.ID)
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		userID    int64
+		wantRoles int
+		wantErr   error
+	}{
+		{name: "defined roles", ctx: ctx, userID: 0, wantRoles: 1},
+		{name: "own roles", ctx: ctx, userID: usr.ID, wantRoles: 0},
+		{name: "roles of another user", ctx: ctx, userID: other.ID, wantErr: ErrPermissionDenied},
+		{name: "roles of another user as admin", ctx: admin, userID: other.ID, wantRoles: 1},
+		{name: "no access token", ctx: context.Background(), userID: usr.ID, wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles, err := env.auth.ListRoles(tt.ctx, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ListRoles() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(roles) != tt.wantRoles {
+				t.Errorf("ListRoles() returned %d roles, want %d", len(roles), tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestCheckPermission(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.openApp(t)
+	admin := env.adminContext(t, app.ID)
+	usr := env.registerUser(t, "user@example.com")
+	other := env.registerUser(t, "other@example.com")
+	if err := env.auth.AssignRole(admin, other.ID, "admin", app.ID); err != nil {
+		t.Fatalf("AssignRole(): %v", err)
+	}
+	ctx := env.userContext(t, usr.Email, app.ID)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		userID  int64
+		want    bool
+		wantErr error
+	}{
+		{name: "own permission", ctx: ctx, userID: usr.ID, want: false},
+		{name: "permission of another user", ctx: ctx, userID: other.ID, wantErr: ErrPermissionDenied},
+		{name: "permission of another user as admin", ctx: admin, userID: other.ID, want: true},
+		{name: "unknown user as admin", ctx: admin, userID: other.ID + 100, wantErr: storage.ErrUserNotFound},
+		{name: "no access token", ctx: context.Background(), userID: usr.ID, wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			has, err := env.auth.CheckPermission(tt.ctx, tt.userID, app.ID, PermManageApps)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckPermission() error = %v, want %v", err, tt.wantErr)
+			}
+			if has != tt.want {
+				t.Errorf("CheckPermission() = %v, want %v", has, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// PermManageUsers allows to unlock users locked out after failed logins.
+const PermManageUsers = "users:manage"
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// RetryError reports when an operation refused for now may be retried.
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err.Error(), e.RetryAfter)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// LoginThrottling limits password guessing. After every failed login of a
+// user the next attempt is delayed, doubling the delay each time, and
+// after MaxFailures the user is locked out. Addresses are locked out after
+// MaxFailuresPerIP failures without the delay, as many users may share one.
+// Zero limits turn the respective check off.
+type LoginThrottling struct {
+	MaxFailures      int
+	MaxFailuresPerIP int
+	LockoutDuration  time.Duration
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	// FailureWindow is how long a failure is counted for
+	FailureWindow time.Duration
+}
+
+type LoginFailureStorage interface {
+	LoginFailures(ctx context.Context, key string) (models.LoginFailures, error)
+	RecordLoginFailure(ctx context.Context, key string, now time.Time, resetBefore time.Time) (int, error)
+	BlockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginFailures(ctx context.Context, key string) error
+	PruneLoginFailures(ctx context.Context, now time.Time, before time.Time) error
+}
+
+// UnlockUser lifts the lockout of the user and forgets its failed logins.
+func (a *Auth) UnlockUser(ctx context.Context, userID int64) error {
+	const op = "auth.UnlockUser"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	)
+
+	if err := a.authorize(ctx, PermManageUsers, 0); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	usr, err := a.usrProvider.UserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("unlocking user")
+
+	if err := a.loginFailures.ResetLoginFailures(ctx, userLoginKey(usr.Email)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("user unlocked")
+	return nil
+}
+
+// PruneLoginFailures forgets failed logins that no longer count. It is run
+// on a schedule.
+func (a *Auth) PruneLoginFailures(ctx context.Context) error {
+	const op = "auth.PruneLoginFailures"
+
+	now := time.Now()
+	if err := a.loginFailures.PruneLoginFailures(ctx, now, now.Add(-a.throttling.FailureWindow)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// checkLoginAllowed returns ErrTooManyAttempts if any of the keys is
+// blocked.
+func (a *Auth) checkLoginAllowed(ctx context.Context, keys []string, now time.Time) error {
+	var blockedUntil time.Time
+	for _, key := range keys {
+		failures, err := a.loginFailures.LoginFailures(ctx, key)
+		if err != nil {
+			return err
+		}
+		if failures.BlockedUntil.After(blockedUntil) {
+			blockedUntil = failures.BlockedUntil
+		}
+	}
+	if now.Before(blockedUntil) {
+		return &RetryError{Err: ErrTooManyAttempts, RetryAfter: blockedUntil.Sub(now)}
+	}
+	return nil
+}
+
+// recordLoginFailure counts the failure for every key, blocks further
+// attempts as configured and returns cause telling when the next attempt
+// is allowed.
+func (a *Auth) recordLoginFailure(ctx context.Context, keys []string, now time.Time, cause error) error {
+	var blockedUntil time.Time
+	for _, key := range keys {
+		failures, err := a.loginFailures.RecordLoginFailure(ctx, key, now, now.Add(-a.throttling.FailureWindow))
+		if err != nil {
+			return err
+		}
+
+		var until time.Time
+		if strings.HasPrefix(key, ipKeyPrefix) {
+			if a.throttling.MaxFailuresPerIP > 0 && failures >= a.throttling.MaxFailuresPerIP {
+				until = now.Add(a.throttling.LockoutDuration)
+			}
+		} else {
+			until = now.Add(a.loginDelay(failures))
+			if a.throttling.MaxFailures > 0 && failures >= a.throttling.MaxFailures {
+				until = now.Add(a.throttling.LockoutDuration)
+			}
+		}
+		if !until.After(now) {
+			continue
+		}
+
+		// blocks are stored with a precision of a second
+		until = ceilSecond(until)
+		if err := a.loginFailures.BlockLogin(ctx, key, until); err != nil {
+			return err
+		}
+		if until.After(blockedUntil) {
+			blockedUntil = until
+		}
+	}
+
+	if blockedUntil.IsZero() {
+		return cause
+	}
+	return &RetryError{Err: cause, RetryAfter: blockedUntil.Sub(now)}
+}
+
+// loginDelay is the delay before the next attempt after the given number
+// of failures in a row.
+func (a *Auth) loginDelay(failures int) time.Duration {
+	delay := a.throttling.BaseDelay
+	for i := 1; i < failures && delay < a.throttling.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > a.throttling.MaxDelay {
+		delay = a.throttling.MaxDelay
+	}
+	return delay
+}
+
+const (
+	userKeyPrefix = "user:"
+	ipKeyPrefix   = "ip:"
+)
+
+// loginKeys returns keys failed logins are counted by. Users are keyed by
+// email, so that unknown emails are throttled the same way.
+func loginKeys(email string, clientIP string) []string {
+	keys := []string{userLoginKey(email)}
+	if clientIP != "" {
+		keys = append(keys, ipKeyPrefix+clientIP)
+	}
+	return keys
+}
+
+func userLoginKey(email string) string {
+	return userKeyPrefix + normalizeEmail(email)
+}
+
+func ceilSecond(t time.Time) time.Time {
+	if truncated := t.Truncate(time.Second); !truncated.Equal(t) {
+		return truncated.Add(time.Second)
+	}
+	return t
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// lockout locks users out after three failures without delaying the
+// attempts before.
+func lockout(cfg *Config) {
+	cfg.LoginThrottling = LoginThrottling{
+		MaxFailures:      3,
+		MaxFailuresPerIP: 5,
+		LockoutDuration:  15 * time.Minute,
+		FailureWindow:    time.Hour,
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	env := newTestEnv(t, lockout)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	// the attempts run in order
+	attempts := []struct {
+		name      string
+		password  string
+		wantErr   error
+		wantRetry bool
+	}{
+		{name: "first failure", password: "wrong-password", wantErr: ErrInvalidCredentials},
+		{name: "second failure", password: "wrong-password", wantErr: ErrInvalidCredentials},
+		{name: "failure locking out", password: "wrong-password", wantErr: ErrInvalidCredentials, wantRetry: true},
+		{name: "correct password while locked out", password: testPassword, wantErr: ErrTooManyAttempts, wantRetry: true},
+	}
+	for _, attempt := range attempts {
+		t.Run(attempt.name, func(t *testing.T) {
+			_, err := env.auth.Login(context.Background(), usr.Email, attempt.password, app.ID, "")
+			if !errors.Is(err, attempt.wantErr) {
+				t.Fatalf("Login() error = %v, want %v", err, attempt.wantErr)
+			}
+			var retryErr *RetryError
+			if errors.As(err, &retryErr) != attempt.wantRetry {
+				t.Fatalf("Login() error = %v, want retry %v", err, attempt.wantRetry)
+			}
+			if attempt.wantRetry && retryErr.RetryAfter > env.auth.throttling.LockoutDuration+time.Second {
+				t.Errorf("retry after %s, longer than the lockout", retryErr.RetryAfter)
+			}
+		})
+	}
+
+	admin := env.adminContext(t, app.ID)
+	if err := env.auth.UnlockUser(admin, usr.ID); err != nil {
+		t.Fatalf("UnlockUser(): %v", err)
+	}
+	if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); err != nil {
+		t.Errorf("Login() after unlock: %v", err)
+	}
+}
+
+func TestLoginResetsFailures(t *testing.T) {
+	env := newTestEnv(t, lockout)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			if _, err := env.auth.Login(context.Background(), usr.Email, "wrong-password", app.ID, ""); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Login() error = %v, want %v", err, ErrInvalidCredentials)
+			}
+		}
+		if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); err != nil {
+			t.Fatalf("Login() after two failures: %v", err)
+		}
+	}
+}
+
+func TestLoginLockoutByIP(t *testing.T) {
+	env := newTestEnv(t, lockout)
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	// failures for different emails from one address add up
+	for i := 0; i < env.auth.throttling.MaxFailuresPerIP; i++ {
+		email := fmt.Sprintf("guess%d@example.com", i)
+		if _, err := env.auth.Login(context.Background(), email, "wrong-password", app.ID, "203.0.113.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login() error = %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		clientIP string
+		wantErr  error
+	}{
+		{name: "blocked address", clientIP: "203.0.113.1", wantErr: ErrTooManyAttempts},
+		{name: "another address", clientIP: "198.51.100.1"},
+		{name: "address unknown", clientIP: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, tt.clientIP); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	a := &Auth{throttling: LoginThrottling{BaseDelay: time.Second, MaxDelay: 5 * time.Second}}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 3, want: 4 * time.Second},
+		{failures: 4, want: 5 * time.Second},
+		{failures: 100, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := a.loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginDelayed(t *testing.T) {
+	env := newTestEnv(t, func(cfg *Config) {
+		cfg.LoginThrottling = LoginThrottling{BaseDelay: time.Minute, MaxDelay: time.Hour, FailureWindow: time.Hour}
+	})
+	app := env.openApp(t)
+	usr := env.registerUser(t, "user@example.com")
+
+	if _, err := env.auth.Login(context.Background(), usr.Email, "wrong-password", app.ID, ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Login() error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	_, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, "")
+	var retryErr *RetryError
+	if !errors.Is(err, ErrTooManyAttempts) || !errors.As(err, &retryErr) {
+		t.Fatalf("Login() during the delay error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if retryErr.RetryAfter <= 0 || retryErr.RetryAfter > time.Minute+time.Second {
+		t.Errorf("retry after %s, want about a minute", retryErr.RetryAfter)
+	}
+}
+
+func TestLoginEmailCase(t *testing.T) {
+	env := newTestEnv(t, lockout)
+	app := env.openApp(t)
+	usr := env.registerUser(t, " User@Example.com")
+	if usr.Email != "user@example.com" {
+		t.Fatalf("registered email = %q, want it normalized", usr.Email)
+	}
+	if _, err := env.auth.RegisterNewUser(context.Background(), "USER@example.com", testPassword); !errors.Is(err, storage.ErrUserExists) {
+		t.Errorf("RegisterNewUser() of the email in another case error = %v, want %v", err, storage.ErrUserExists)
+	}
+
+	// failures in any case count against the same user, the attempts run
+	// in order
+	attempts := []struct {
+		name     string
+		email    string
+		password string
+		wantErr  error
+	}{
+		{name: "lowercase", email: "user@example.com", password: testPassword},
+		{name: "uppercase", email: "USER@EXAMPLE.COM", password: testPassword},
+		{name: "padded", email: " User@Example.com ", password: testPassword},
+		{name: "failure in lowercase", email: "user@example.com", password: "wrong-password", wantErr: ErrInvalidCredentials},
+		{name: "failure in uppercase", email: "USER@EXAMPLE.COM", password: "wrong-password", wantErr: ErrInvalidCredentials},
+		{name: "failure locking out", email: "User@Example.com", password: "wrong-password", wantErr: ErrInvalidCredentials},
+		{name: "locked out in another case", email: "uSER@eXAMPLE.COM", password: testPassword, wantErr: ErrTooManyAttempts},
+	}
+	for _, attempt := range attempts {
+		t.Run(attempt.name, func(t *testing.T) {
+			if _, err := env.auth.Login(context.Background(), attempt.email, attempt.password, app.ID, ""); !errors.Is(err, attempt.wantErr) {
+				t.Errorf("Login() error = %v, want %v", err, attempt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"auth/internal/lib/securetoken"
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var ErrEmailNotVerified = errors.New("email is not verified")
+
+type VerificationTokenStorage interface {
+	SaveVerificationToken(ctx context.Context, token models.VerificationToken) (int64, error)
+	VerificationToken(ctx context.Context, tokenHash []byte) (models.VerificationToken, error)
+	ConfirmEmail(ctx context.Context, tokenID int64) error
+}
+
+// Mailer delivers emails to users.
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+// background runs fn detached from the request, for work whose duration
+// must not show in the response time.
+func (a *Auth) background(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	a.pending.Add(1)
+	go func() {
+		defer a.pending.Done()
+		fn(ctx)
+	}()
+}
+
+// Wait blocks until the work started in the background is done.
+func (a *Auth) Wait() {
+	a.pending.Wait()
+}
+
+// SendVerificationEmail sends a new verification token to the user. To
+// avoid disclosing which emails are registered the email is sent in the
+// background and the call always succeeds, nothing is sent to unknown and
+// already verified emails.
+func (a *Auth) SendVerificationEmail(ctx context.Context, email string) error {
+	const op = "auth.SendVerificationEmail"
+
+	email = normalizeEmail(email)
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("sending verification email")
+
+	a.background(ctx, func(ctx context.Context) {
+		usr, err := a.usrProvider.User(ctx, email)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("user not found")
+				return
+			}
+			log.Error("failed to get user", slog.String("error", err.Error()))
+			return
+		}
+		if usr.EmailVerified {
+			log.Info("email already verified", slog.Int64("user_id", usr.ID))
+			return
+		}
+
+		if err := a.sendVerificationEmail(ctx, usr); err != nil {
+			log.Error("failed to send verification email", slog.String("error", err.Error()))
+			return
+		}
+		log.Info("verification email sent", slog.Int64("user_id", usr.ID))
+	})
+	return nil
+}
+
+// VerifyEmail marks the email the token was sent to as verified and
+// returns the id of its user.
+func (a *Auth) VerifyEmail(ctx context.Context, token string) (int64, error) {
+	const op = "auth.VerifyEmail"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+	log.Info("verifying email")
+
+	vt, err := a.verificationTokens.VerificationToken(ctx, securetoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrTokenNotFound) {
+			log.Info("verification token not found")
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", vt.UserID))
+
+	if vt.Used {
+		log.Info("verification token already used")
+		return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+	if time.Now().After(vt.ExpiresAt) {
+		log.Info("verification token expired")
+		return 0, fmt.Errorf("%s: %w", op, ErrExpiredToken)
+	}
+
+	if err := a.verificationTokens.ConfirmEmail(ctx, vt.ID); err != nil {
+		if errors.Is(err, storage.ErrTokenUsed) || errors.Is(err, storage.ErrUserNotFound) {
+			// used concurrently, or the user has changed email since
+			log.Info("verification token no longer applies", slog.String("error", err.Error()))
+			return 0, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	log.Info("email verified")
+	return vt.UserID, nil
+}
+
+func (a *Auth) sendVerificationEmail(ctx context.Context, usr models.User) error {
+	token, err := securetoken.New()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(a.verificationTTL)
+
+	_, err = a.verificationTokens.SaveVerificationToken(ctx, models.VerificationToken{
+		TokenHash: securetoken.Hash(token),
+		UserID:    usr.ID,
+		Email:     usr.Email,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Use this token to verify your email address:\n\n%s\n\nThe token expires at %s.",
+		token, expiresAt.UTC().Format(time.RFC1123))
+	return a.mailer.Send(ctx, usr.Email, "Verify your email address", body)
+}
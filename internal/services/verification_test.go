@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"auth/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config)
+		// token returns the token to verify with, given the one mailed on
+		// registration
+		token   func(t *testing.T, env *testEnv, mailed string) string
+		wantErr error
+	}{
+		{
+			name:  "mailed token",
+			token: func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+		},
+		{
+			name:    "unknown token",
+			token:   func(t *testing.T, env *testEnv, mailed string) string { return "unknown" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:      "expired token",
+			configure: func(cfg *Config) { cfg.EmailVerificationTTL = -time.Minute },
+			token:     func(t *testing.T, env *testEnv, mailed string) string { return mailed },
+			wantErr:   ErrExpiredToken,
+		},
+		{
+			name: "used token",
+			token: func(t *testing.T, env *testEnv, mailed string) string {
+				if _, err := env.auth.VerifyEmail(context.Background(), mailed); err != nil {
+					t.Fatalf("first VerifyEmail(): %v", err)
+				}
+				return mailed
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(cfg *Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			env := newTestEnv(t, configure...)
+			usr := env.registerUser(t, "user@example.com")
+			mailed := env.mailer.lastSecret(t, usr.Email)
+
+			userID, err := env.auth.VerifyEmail(context.Background(), tt.token(t, env, mailed))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyEmail() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if userID != usr.ID {
+				t.Errorf("VerifyEmail() user = %d, want %d", userID, usr.ID)
+			}
+			verified, err := env.storage.UserByID(context.Background(), usr.ID)
+			if err != nil {
+				t.Fatalf("user: %v", err)
+			}
+			if !verified.EmailVerified {
+				t.Error("email is not verified")
+			}
+		})
+	}
+}
+
+func TestSendVerificationEmail(t *testing.T) {
+	env := newTestEnv(t)
+	unverified := env.registerUser(t, "unverified@example.com")
+	verified := env.registerUser(t, "verified@example.com")
+	if _, err := env.auth.VerifyEmail(context.Background(), env.mailer.lastSecret(t, verified.Email)); err != nil {
+		t.Fatalf("VerifyEmail(): %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		email     string
+		wantMails int
+	}{
+		{name: "unverified email", email: unverified.Email, wantMails: 2},
+		{name: "verified email", email: verified.Email, wantMails: 1},
+		{name: "unknown email", email: "unknown@example.com", wantMails: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := env.auth.SendVerificationEmail(context.Background(), tt.email); err != nil {
+				t.Fatalf("SendVerificationEmail(): %v", err)
+			}
+			env.auth.Wait()
+			if got := len(env.mailer.to(tt.email)); got != tt.wantMails {
+				t.Errorf("%d emails sent to %s, want %d", got, tt.email, tt.wantMails)
+			}
+		})
+	}
+}
+
+func TestLoginRequiresVerifiedEmail(t *testing.T) {
+	env := newTestEnv(t)
+	app := env.createApp(t, models.App{AllowSelfRegistration: true, RequireEmailVerification: true})
+	usr := env.registerUser(t, "user@example.com")
+
+	if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("Login() with unverified email error = %v, want %v", err, ErrEmailNotVerified)
+	}
+	if _, err := env.auth.VerifyEmail(context.Background(), env.mailer.lastSecret(t, usr.Email)); err != nil {
+		t.Fatalf("VerifyEmail(): %v", err)
+	}
+	if _, err := env.auth.Login(context.Background(), usr.Email, testPassword, app.ID, ""); err != nil {
+		t.Errorf("Login() with verified email: %v", err)
+	}
+}
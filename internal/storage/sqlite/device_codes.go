@@ -0,0 +1,154 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const deviceCodeColumns = `id, device_code_hash, user_code_hash, app_id, user_id, status, poll_interval,
+	last_polled_at, expires_at`
+
+func (s *Storage) SaveDeviceCode(ctx context.Context, code models.DeviceCode) (int64, error) {
+	const op = "storage.sqlite.SaveDeviceCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`INSERT INTO device_codes(device_code_hash, user_code_hash, app_id, poll_interval,
+		expires_at) VALUES(?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление кода устройства
+	res, err := stmt.ExecContext(ctx, code.DeviceCodeHash, code.UserCodeHash, code.AppID,
+		int64(code.PollInterval/time.Second), code.ExpiresAt.Unix())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserCodeExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// DeviceCode returns the grant the device polls for.
+func (s *Storage) DeviceCode(ctx context.Context, deviceCodeHash []byte) (models.DeviceCode, error) {
+	const op = "storage.sqlite.DeviceCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + deviceCodeColumns + " FROM device_codes WHERE device_code_hash = ?")
+	if err != nil {
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := scanDeviceCode(stmt.QueryRowContext(ctx, deviceCodeHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.DeviceCode{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, nil
+}
+
+// DeviceCodeByUserCode returns the grant the user is asked to approve.
+func (s *Storage) DeviceCodeByUserCode(ctx context.Context, userCodeHash []byte) (models.DeviceCode, error) {
+	const op = "storage.sqlite.DeviceCodeByUserCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + deviceCodeColumns + " FROM device_codes WHERE user_code_hash = ?")
+	if err != nil {
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := scanDeviceCode(stmt.QueryRowContext(ctx, userCodeHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.DeviceCode{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, nil
+}
+
+// DecideDeviceCode records the decision of the user on a pending grant, a
+// grant already decided gives ErrTokenUsed.
+func (s *Storage) DecideDeviceCode(ctx context.Context, codeID int64, userID int64, status string) error {
+	const op = "storage.sqlite.DecideDeviceCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE device_codes SET status = ?, user_id = ? WHERE id = ? AND status = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, status, userID, codeID, models.DeviceCodePending)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+	return nil
+}
+
+// RecordDevicePoll saves when the device polled and the interval it has to
+// wait before polling again.
+func (s *Storage) RecordDevicePoll(ctx context.Context, codeID int64, polledAt time.Time, interval time.Duration) error {
+	const op = "storage.sqlite.RecordDevicePoll"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE device_codes SET last_polled_at = ?, poll_interval = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, polledAt.Unix(), int64(interval/time.Second), codeID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseDeviceCode marks an approved grant exchanged for tokens, a grant
+// already exchanged gives ErrTokenUsed.
+func (s *Storage) UseDeviceCode(ctx context.Context, codeID int64) error {
+	const op = "storage.sqlite.UseDeviceCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE device_codes SET status = ? WHERE id = ? AND status = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, models.DeviceCodeUsed, codeID, models.DeviceCodeApproved)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+	return nil
+}
+
+func scanDeviceCode(row scanner) (models.DeviceCode, error) {
+	var code models.DeviceCode
+	var userID sql.NullInt64
+	var interval, lastPolledAt, expiresAt int64
+	err := row.Scan(&code.ID, &code.DeviceCodeHash, &code.UserCodeHash, &code.AppID, &userID, &code.Status,
+		&interval, &lastPolledAt, &expiresAt)
+	if err != nil {
+		return models.DeviceCode{}, err
+	}
+	code.UserID = userID.Int64
+	code.PollInterval = time.Duration(interval) * time.Second
+	code.LastPolledAt = timeOrZero(lastPolledAt)
+	code.ExpiresAt = time.Unix(expiresAt, 0)
+	return code, nil
+}
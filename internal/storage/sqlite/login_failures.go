@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LoginFailures returns failed logins counted for the key. A key without
+// failures gives a zero count rather than an error.
+func (s *Storage) LoginFailures(ctx context.Context, key string) (models.LoginFailures, error) {
+	const op = "storage.sqlite.LoginFailures"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT failures, last_failure_at, blocked_until FROM login_failures WHERE key = ?")
+	if err != nil {
+		return models.LoginFailures{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	failures := models.LoginFailures{Key: key}
+	var lastFailureAt, blockedUntil int64
+	err = stmt.QueryRowContext(ctx, key).Scan(&failures.Failures, &lastFailureAt, &blockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return failures, nil
+		}
+		return models.LoginFailures{}, fmt.Errorf("%s: %w", op, err)
+	}
+	failures.LastFailureAt = time.Unix(lastFailureAt, 0)
+	failures.BlockedUntil = timeOrZero(blockedUntil)
+	return failures, nil
+}
+
+// RecordLoginFailure counts a failed login for the key and returns how many
+// there have been. Failures before resetBefore are forgotten.
+func (s *Storage) RecordLoginFailure(ctx context.Context, key string, now time.Time, resetBefore time.Time) (int, error) {
+	const op = "storage.sqlite.RecordLoginFailure"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`INSERT INTO login_failures(key, failures, last_failure_at) VALUES(?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var failures int
+	if err := stmt.QueryRowContext(ctx, key, now.Unix(), resetBefore.Unix()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return failures, nil
+}
+
+// BlockLogin refuses login attempts for the key until the given time.
+func (s *Storage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "storage.sqlite.BlockLogin"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE login_failures SET blocked_until = ? WHERE key = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, until.Unix(), key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ResetLoginFailures forgets failed logins for the key and lifts its block.
+func (s *Storage) ResetLoginFailures(ctx context.Context, key string) error {
+	const op = "storage.sqlite.ResetLoginFailures"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("DELETE FROM login_failures WHERE key = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, key); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// PruneLoginFailures deletes counters with no failures since before that
+// aren't blocked at now anymore.
+func (s *Storage) PruneLoginFailures(ctx context.Context, now time.Time, before time.Time) error {
+	const op = "storage.sqlite.PruneLoginFailures"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("DELETE FROM login_failures WHERE last_failure_at < ? AND blocked_until < ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, before.Unix(), now.Unix()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
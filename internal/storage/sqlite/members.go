@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AddMember makes the user a member of the app. Adding an existing member
+// is not an error.
+func (s *Storage) AddMember(ctx context.Context, userID int64, appID int) error {
+	const op = "storage.sqlite.AddMember"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT OR IGNORE INTO user_apps(user_id, app_id, created_at) VALUES(?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, userID, appID, time.Now().Unix()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RemoveMember removes the user from the app along with roles granted in
+// the app and revokes refresh tokens issued for it.
+func (s *Storage) RemoveMember(ctx context.Context, userID int64, appID int) error {
+	const op = "storage.sqlite.RemoveMember"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM user_apps WHERE user_id = ? AND app_id = ?", userID, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM user_roles WHERE user_id = ? AND app_id = ?",
+		"UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = ? AND app_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID, appID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) IsMember(ctx context.Context, userID int64, appID int) (bool, error) {
+	const op = "storage.sqlite.IsMember"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT EXISTS(SELECT 1 FROM user_apps WHERE user_id = ? AND app_id = ?)")
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var isMember bool
+	if err := stmt.QueryRowContext(ctx, userID, appID).Scan(&isMember); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return isMember, nil
+}
+
+func (s *Storage) SaveInvitation(ctx context.Context, invitation models.AppInvitation) (int64, error) {
+	const op = "storage.sqlite.SaveInvitation"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT INTO app_invitations(token_hash, app_id, email, expires_at) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление приглашения
+	res, err := stmt.ExecContext(ctx, invitation.TokenHash, invitation.AppID, invitation.Email, invitation.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) Invitation(ctx context.Context, tokenHash []byte) (models.AppInvitation, error) {
+	const op = "storage.sqlite.Invitation"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT id, token_hash, app_id, email, expires_at, used FROM app_invitations WHERE token_hash = ?")
+	if err != nil {
+		return models.AppInvitation{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenHash)
+
+	var invitation models.AppInvitation
+	var expiresAt int64
+	err = row.Scan(&invitation.ID, &invitation.TokenHash, &invitation.AppID, &invitation.Email, &expiresAt, &invitation.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AppInvitation{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.AppInvitation{}, fmt.Errorf("%s: %w", op, err)
+	}
+	invitation.ExpiresAt = time.Unix(expiresAt, 0)
+	return invitation, nil
+}
+
+// AcceptInvitation uses up the invitation and makes the user a member of
+// its app.
+func (s *Storage) AcceptInvitation(ctx context.Context, invitationID int64, userID int64) error {
+	const op = "storage.sqlite.AcceptInvitation"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var appID int
+	err = tx.QueryRowContext(ctx, "SELECT app_id FROM app_invitations WHERE id = ?", invitationID).Scan(&appID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Приглашение одноразовое
+	res, err := tx.ExecContext(ctx, "UPDATE app_invitations SET used = TRUE WHERE id = ? AND used = FALSE", invitationID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO user_apps(user_id, app_id, created_at) VALUES(?, ?, ?)",
+		userID, appID, time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SaveTOTP stores a new unconfirmed secret of the user, replacing one that
+// hasn't been confirmed. A confirmed secret isn't replaced.
+func (s *Storage) SaveTOTP(ctx context.Context, userID int64, secret []byte) error {
+	const op = "storage.sqlite.SaveTOTP"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`INSERT INTO user_totp(user_id, secret) VALUES(?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_used_step = 0
+		WHERE confirmed = FALSE`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, userID, secret)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPConfirmed)
+	}
+	return nil
+}
+
+func (s *Storage) TOTP(ctx context.Context, userID int64) (models.TOTP, error) {
+	const op = "storage.sqlite.TOTP"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT user_id, secret, confirmed, last_used_step FROM user_totp WHERE user_id = ?")
+	if err != nil {
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var totp models.TOTP
+	err = stmt.QueryRowContext(ctx, userID).Scan(&totp.UserID, &totp.Secret, &totp.Confirmed, &totp.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TOTP{}, fmt.Errorf("%s: %w", op, storage.ErrTOTPNotFound)
+		}
+		return models.TOTP{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return totp, nil
+}
+
+// UseTOTPStep records that a code of the step has been accepted and, with
+// confirm, activates the secret. Steps not after the last used one give
+// ErrTOTPStepUsed, so each code works once.
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64, confirm bool) error {
+	const op = "storage.sqlite.UseTOTPStep"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`UPDATE user_totp SET last_used_step = ?, confirmed = confirmed OR ?
+		WHERE user_id = ? AND last_used_step < ?`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, step, confirm, userID, step)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+	return nil
+}
+
+func (s *Storage) SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) (int64, error) {
+	const op = "storage.sqlite.SaveMFAChallenge"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT INTO mfa_challenges(token_hash, user_id, app_id, expires_at) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление челленджа
+	res, err := stmt.ExecContext(ctx, challenge.TokenHash, challenge.UserID, challenge.AppID, challenge.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) MFAChallenge(ctx context.Context, tokenHash []byte) (models.MFAChallenge, error) {
+	const op = "storage.sqlite.MFAChallenge"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT id, token_hash, user_id, app_id, expires_at, used FROM mfa_challenges WHERE token_hash = ?")
+	if err != nil {
+		return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var challenge models.MFAChallenge
+	var expiresAt int64
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&challenge.ID, &challenge.TokenHash, &challenge.UserID, &challenge.AppID, &expiresAt, &challenge.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+	challenge.ExpiresAt = time.Unix(expiresAt, 0)
+	return challenge, nil
+}
+
+// UseMFAChallenge marks the challenge completed, a challenge already
+// completed gives ErrTokenUsed.
+func (s *Storage) UseMFAChallenge(ctx context.Context, challengeID int64) error {
+	const op = "storage.sqlite.UseMFAChallenge"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE mfa_challenges SET used = TRUE WHERE id = ? AND used = FALSE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, challengeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code models.AuthorizationCode) (int64, error) {
+	const op = "storage.sqlite.SaveAuthorizationCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`INSERT INTO authorization_codes(code_hash, app_id, user_id, redirect_uri, code_challenge,
+		family_id, expires_at, scope, nonce, auth_time) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление кода авторизации
+	res, err := stmt.ExecContext(ctx, code.CodeHash, code.AppID, code.UserID, code.RedirectURI, code.CodeChallenge,
+		code.FamilyID, code.ExpiresAt.Unix(), strings.Join(code.Scopes, " "), code.Nonce, code.AuthTime.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) AuthorizationCode(ctx context.Context, codeHash []byte) (models.AuthorizationCode, error) {
+	const op = "storage.sqlite.AuthorizationCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`SELECT id, code_hash, app_id, user_id, redirect_uri, code_challenge, family_id,
+		expires_at, used, scope, nonce, auth_time FROM authorization_codes WHERE code_hash = ?`)
+	if err != nil {
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var code models.AuthorizationCode
+	var scope string
+	var expiresAt, authTime int64
+	err = stmt.QueryRowContext(ctx, codeHash).Scan(&code.ID, &code.CodeHash, &code.AppID, &code.UserID,
+		&code.RedirectURI, &code.CodeChallenge, &code.FamilyID, &expiresAt, &code.Used, &scope, &code.Nonce, &authTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.AuthorizationCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+	code.ExpiresAt = time.Unix(expiresAt, 0)
+	code.Scopes = strings.Fields(scope)
+	code.AuthTime = time.Unix(authTime, 0)
+	return code, nil
+}
+
+// UseAuthorizationCode marks the code exchanged, a code already exchanged
+// gives ErrTokenUsed.
+func (s *Storage) UseAuthorizationCode(ctx context.Context, codeID int64) error {
+	const op = "storage.sqlite.UseAuthorizationCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE authorization_codes SET used = TRUE WHERE id = ? AND used = FALSE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, codeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const passkeyColumns = `id, user_id, rp_id, credential_id, public_key, attestation_type, aaguid, user_handle,
+	sign_count, transports, backup_eligible, backup_state, created_at, last_used_at`
+
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) (int64, error) {
+	const op = "storage.sqlite.SavePasskey"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`INSERT INTO passkeys(user_id, rp_id, credential_id, public_key, attestation_type, aaguid,
+		user_handle, sign_count, transports, backup_eligible, backup_state, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление ключа
+	res, err := stmt.ExecContext(ctx, passkey.UserID, passkey.RPID, passkey.CredentialID, passkey.PublicKey,
+		passkey.AttestationType, passkey.AAGUID, passkey.UserHandle, passkey.SignCount, strings.Join(passkey.Transports, " "),
+		passkey.BackupEligible, passkey.BackupState, passkey.CreatedAt.Unix())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// Passkeys returns passkeys of the user registered for the relying party.
+func (s *Storage) Passkeys(ctx context.Context, userID int64, rpID string) ([]models.Passkey, error) {
+	const op = "storage.sqlite.Passkeys"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + passkeyColumns + " FROM passkeys WHERE user_id = ? AND rp_id = ? ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID, rpID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var passkeys []models.Passkey
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return passkeys, nil
+}
+
+func (s *Storage) PasskeyByCredentialID(ctx context.Context, credentialID []byte) (models.Passkey, error) {
+	const op = "storage.sqlite.PasskeyByCredentialID"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + passkeyColumns + " FROM passkeys WHERE credential_id = ?")
+	if err != nil {
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passkey, err := scanPasskey(stmt.QueryRowContext(ctx, credentialID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Passkey{}, fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+		}
+		return models.Passkey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return passkey, nil
+}
+
+// UpdatePasskeyUsage records a login with the passkey. The counter is only
+// moved forward, a login racing with another one using a later counter
+// gives ErrPasskeyNotFound.
+func (s *Storage) UpdatePasskeyUsage(ctx context.Context, passkeyID int64, signCount uint32, backupState bool, usedAt time.Time) error {
+	const op = "storage.sqlite.UpdatePasskeyUsage"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`UPDATE passkeys SET sign_count = ?, backup_state = ?, last_used_at = ?
+		WHERE id = ? AND (sign_count < ? OR sign_count = 0)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, signCount, backupState, usedAt.Unix(), passkeyID, signCount)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+	}
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnSession(ctx context.Context, session models.WebAuthnSession) (int64, error) {
+	const op = "storage.sqlite.SaveWebAuthnSession"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`INSERT INTO webauthn_sessions(token_hash, user_id, app_id, ceremony, data, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление сессии
+	res, err := stmt.ExecContext(ctx, session.TokenHash, session.UserID, session.AppID, session.Ceremony, session.Data,
+		session.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) WebAuthnSession(ctx context.Context, tokenHash []byte) (models.WebAuthnSession, error) {
+	const op = "storage.sqlite.WebAuthnSession"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`SELECT id, token_hash, user_id, app_id, ceremony, data, expires_at, used
+		FROM webauthn_sessions WHERE token_hash = ?`)
+	if err != nil {
+		return models.WebAuthnSession{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var session models.WebAuthnSession
+	var expiresAt int64
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&session.ID, &session.TokenHash, &session.UserID, &session.AppID,
+		&session.Ceremony, &session.Data, &expiresAt, &session.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebAuthnSession{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.WebAuthnSession{}, fmt.Errorf("%s: %w", op, err)
+	}
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	return session, nil
+}
+
+// UseWebAuthnSession marks the session finished, a session already
+// finished gives ErrTokenUsed.
+func (s *Storage) UseWebAuthnSession(ctx context.Context, sessionID int64) error {
+	const op = "storage.sqlite.UseWebAuthnSession"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE webauthn_sessions SET used = TRUE WHERE id = ? AND used = FALSE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+	return nil
+}
+
+func scanPasskey(row scanner) (models.Passkey, error) {
+	var passkey models.Passkey
+	var transports string
+	var createdAt, lastUsedAt int64
+	err := row.Scan(&passkey.ID, &passkey.UserID, &passkey.RPID, &passkey.CredentialID, &passkey.PublicKey,
+		&passkey.AttestationType, &passkey.AAGUID, &passkey.UserHandle, &passkey.SignCount, &transports, &passkey.BackupEligible,
+		&passkey.BackupState, &createdAt, &lastUsedAt)
+	if err != nil {
+		return models.Passkey{}, err
+	}
+	passkey.Transports = strings.Fields(transports)
+	passkey.CreatedAt = time.Unix(createdAt, 0)
+	passkey.LastUsedAt = timeOrZero(lastUsedAt)
+	return passkey, nil
+}
@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *Storage) SavePasswordResetToken(ctx context.Context, token models.PasswordResetToken) (int64, error) {
+	const op = "storage.sqlite.SavePasswordResetToken"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT INTO password_reset_tokens(token_hash, user_id, expires_at) VALUES(?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление токена
+	res, err := stmt.ExecContext(ctx, token.TokenHash, token.UserID, token.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) PasswordResetToken(ctx context.Context, tokenHash []byte) (models.PasswordResetToken, error) {
+	const op = "storage.sqlite.PasswordResetToken"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT id, token_hash, user_id, expires_at, used FROM password_reset_tokens WHERE token_hash = ?")
+	if err != nil {
+		return models.PasswordResetToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenHash)
+
+	var token models.PasswordResetToken
+	var expiresAt int64
+	err = row.Scan(&token.ID, &token.TokenHash, &token.UserID, &expiresAt, &token.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordResetToken{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.PasswordResetToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	return token, nil
+}
+
+// ResetPassword uses up the reset token, together with every other reset
+// token of its user, and sets the new password hash.
+func (s *Storage) ResetPassword(ctx context.Context, tokenID int64, passHash []byte) error {
+	const op = "storage.sqlite.ResetPassword"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM password_reset_tokens WHERE id = ?", tokenID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Токен одноразовый
+	res, err := tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used = TRUE WHERE id = ? AND used = FALSE", tokenID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+
+	// Остальные выданные токены больше не нужны
+	if _, err := tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used = TRUE WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err = tx.ExecContext(ctx, "UPDATE users SET pass_hash = ? WHERE id = ?", passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
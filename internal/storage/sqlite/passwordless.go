@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const passwordlessColumns = "id, user_id, app_id, method, secret_hash, expires_at, attempts, used"
+
+// SavePasswordlessChallenge saves a new challenge, challenges of the user
+// for the app started before stop working.
+func (s *Storage) SavePasswordlessChallenge(ctx context.Context, challenge models.PasswordlessChallenge) (int64, error) {
+	const op = "storage.sqlite.SavePasswordlessChallenge"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE passwordless_challenges SET used = TRUE WHERE user_id = ? AND app_id = ? AND used = FALSE",
+		challenge.UserID, challenge.AppID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO passwordless_challenges(user_id, app_id, method, secret_hash, expires_at)
+		VALUES(?, ?, ?, ?, ?)`, challenge.UserID, challenge.AppID, challenge.Method, challenge.SecretHash, challenge.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+// PasswordlessChallengeBySecret returns the challenge of a login link.
+func (s *Storage) PasswordlessChallengeBySecret(ctx context.Context, secretHash []byte) (models.PasswordlessChallenge, error) {
+	const op = "storage.sqlite.PasswordlessChallengeBySecret"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + passwordlessColumns + " FROM passwordless_challenges WHERE secret_hash = ?")
+	if err != nil {
+		return models.PasswordlessChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	challenge, err := scanPasswordlessChallenge(stmt.QueryRowContext(ctx, secretHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordlessChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.PasswordlessChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return challenge, nil
+}
+
+// ActivePasswordlessChallenge returns the unused challenge of the user for
+// the app, there is at most one.
+func (s *Storage) ActivePasswordlessChallenge(ctx context.Context, userID int64, appID int) (models.PasswordlessChallenge, error) {
+	const op = "storage.sqlite.ActivePasswordlessChallenge"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + passwordlessColumns + ` FROM passwordless_challenges
+		WHERE user_id = ? AND app_id = ? AND used = FALSE ORDER BY id DESC LIMIT 1`)
+	if err != nil {
+		return models.PasswordlessChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	challenge, err := scanPasswordlessChallenge(stmt.QueryRowContext(ctx, userID, appID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordlessChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.PasswordlessChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return challenge, nil
+}
+
+// RecordPasswordlessAttempt counts a wrong code for the challenge and
+// returns how many there have been. The challenge is used up once
+// maxAttempts is reached.
+func (s *Storage) RecordPasswordlessAttempt(ctx context.Context, challengeID int64, maxAttempts int) (int, error) {
+	const op = "storage.sqlite.RecordPasswordlessAttempt"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`UPDATE passwordless_challenges SET attempts = attempts + 1, used = used OR attempts + 1 >= ?
+		WHERE id = ? RETURNING attempts`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var attempts int
+	if err := stmt.QueryRowContext(ctx, maxAttempts, challengeID).Scan(&attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return attempts, nil
+}
+
+// UsePasswordlessChallenge marks the challenge completed, a challenge
+// already used gives ErrTokenUsed.
+func (s *Storage) UsePasswordlessChallenge(ctx context.Context, challengeID int64) error {
+	const op = "storage.sqlite.UsePasswordlessChallenge"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE passwordless_challenges SET used = TRUE WHERE id = ? AND used = FALSE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, challengeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+	return nil
+}
+
+func scanPasswordlessChallenge(row scanner) (models.PasswordlessChallenge, error) {
+	var challenge models.PasswordlessChallenge
+	var expiresAt int64
+	err := row.Scan(&challenge.ID, &challenge.UserID, &challenge.AppID, &challenge.Method, &challenge.SecretHash,
+		&expiresAt, &challenge.Attempts, &challenge.Used)
+	if err != nil {
+		return models.PasswordlessChallenge{}, err
+	}
+	challenge.ExpiresAt = time.Unix(expiresAt, 0)
+	return challenge, nil
+}
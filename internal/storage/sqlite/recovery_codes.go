@@ -0,0 +1,89 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplaceRecoveryCodes deletes every recovery code of the user, used or
+// not, and saves the new ones.
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes [][]byte, now time.Time) error {
+	const op = "storage.sqlite.ReplaceRecoveryCodes"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, hash := range codeHashes {
+		_, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes(user_id, code_hash, created_at) VALUES(?, ?, ?)",
+			userID, hash, now.Unix())
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RecoveryCodes returns unused recovery codes of the user.
+func (s *Storage) RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error) {
+	const op = "storage.sqlite.RecoveryCodes"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT id, user_id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at = 0")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+	for rows.Next() {
+		var code models.RecoveryCode
+		if err := rows.Scan(&code.ID, &code.UserID, &code.CodeHash); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode marks the code used at now, a code already used gives
+// ErrTokenUsed.
+func (s *Storage) UseRecoveryCode(ctx context.Context, codeID int64, now time.Time) error {
+	const op = "storage.sqlite.UseRecoveryCode"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at = 0")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, now.Unix(), codeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+	return nil
+}
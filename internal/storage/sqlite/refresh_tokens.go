@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) (int64, error) {
+	const op = "storage.sqlite.SaveRefreshToken"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT INTO refresh_tokens(token_hash, family_id, user_id, app_id, expires_at, scope) VALUES(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление токена
+	res, err := stmt.ExecContext(ctx, token.TokenHash, token.FamilyID, token.UserID, token.AppID, token.ExpiresAt.Unix(),
+		strings.Join(token.Scopes, " "))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, tokenHash []byte) (models.RefreshToken, error) {
+	const op = "storage.sqlite.RefreshToken"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT id, token_hash, family_id, user_id, app_id, expires_at, rotated, revoked, scope FROM refresh_tokens WHERE token_hash = ?")
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenHash)
+
+	var token models.RefreshToken
+	var expiresAt int64
+	var scope string
+	err = row.Scan(&token.ID, &token.TokenHash, &token.FamilyID, &token.UserID, &token.AppID, &expiresAt, &token.Rotated, &token.Revoked, &scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	token.Scopes = strings.Fields(scope)
+	return token, nil
+}
+
+// RotateRefreshToken marks the token as used and saves its successor in one
+// transaction. If the token has already been rotated ErrRefreshTokenRotated
+// is returned and nothing is saved.
+func (s *Storage) RotateRefreshToken(ctx context.Context, tokenID int64, next models.RefreshToken) (int64, error) {
+	const op = "storage.sqlite.RotateRefreshToken"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// Помечаем токен использованным, только если этого ещё не произошло
+	res, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET rotated = TRUE WHERE id = ? AND rotated = FALSE", tokenID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return 0, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenRotated)
+	}
+
+	// Добавление нового токена той же семьи
+	res, err = tx.ExecContext(ctx,
+		"INSERT INTO refresh_tokens(token_hash, family_id, user_id, app_id, expires_at, scope) VALUES(?, ?, ?, ?, ?, ?)",
+		next.TokenHash, next.FamilyID, next.UserID, next.AppID, next.ExpiresAt.Unix(), strings.Join(next.Scopes, " "),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	const op = "storage.sqlite.RevokeRefreshTokenFamily"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, familyID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) RevokeUserRefreshTokens(ctx context.Context, userID int64) error {
+	const op = "storage.sqlite.RevokeUserRefreshTokens"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Roles returns all defined roles with their permissions.
+func (s *Storage) Roles(ctx context.Context) ([]models.Role, error) {
+	const op = "storage.sqlite.Roles"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`SELECT r.id, r.name, r.description, COALESCE(GROUP_CONCAT(p.name, ' '), '')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id ORDER BY r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		var permissions string
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &permissions); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		role.Permissions = strings.Fields(permissions)
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
+
+// UserRoles returns roles granted to the user in any app.
+func (s *Storage) UserRoles(ctx context.Context, userID int64) ([]models.UserRole, error) {
+	const op = "storage.sqlite.UserRoles"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`SELECT ur.app_id, r.id, r.name, r.description, COALESCE(GROUP_CONCAT(p.name, ' '), '')
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ?
+		GROUP BY ur.app_id, r.id ORDER BY ur.app_id, r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []models.UserRole
+	for rows.Next() {
+		userRole := models.UserRole{UserID: userID}
+		var permissions string
+		err := rows.Scan(&userRole.AppID, &userRole.Role.ID, &userRole.Role.Name, &userRole.Role.Description, &permissions)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		userRole.Role.Permissions = strings.Fields(permissions)
+		roles = append(roles, userRole)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
+
+// AssignRole grants the role to the user in the app, or in every app if
+// appID is zero. Granting a role the user already has is not an error.
+func (s *Storage) AssignRole(ctx context.Context, userID int64, role string, appID int) error {
+	const op = "storage.sqlite.AssignRole"
+
+	roleID, err := s.roleID(ctx, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT OR IGNORE INTO user_roles(user_id, role_id, app_id) VALUES(?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, userID, roleID, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RevokeRole takes the role granted in the app away from the user.
+// Revoking a role the user doesn't have is not an error.
+func (s *Storage) RevokeRole(ctx context.Context, userID int64, role string, appID int) error {
+	const op = "storage.sqlite.RevokeRole"
+
+	roleID, err := s.roleID(ctx, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("DELETE FROM user_roles WHERE user_id = ? AND role_id = ? AND app_id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, userID, roleID, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UserAccess returns roles and permissions the user holds in the app,
+// including ones granted in every app.
+func (s *Storage) UserAccess(ctx context.Context, userID int64, appID int) (models.Access, error) {
+	const op = "storage.sqlite.UserAccess"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`SELECT DISTINCT r.name, COALESCE(p.name, '')
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ? AND ur.app_id IN (0, ?)
+		ORDER BY r.name, p.name`)
+	if err != nil {
+		return models.Access{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID, appID)
+	if err != nil {
+		return models.Access{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var access models.Access
+	roles := make(map[string]bool)
+	permissions := make(map[string]bool)
+	for rows.Next() {
+		var role, permission string
+		if err := rows.Scan(&role, &permission); err != nil {
+			return models.Access{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if !roles[role] {
+			roles[role] = true
+			access.Roles = append(access.Roles, role)
+		}
+		if permission != "" && !permissions[permission] {
+			permissions[permission] = true
+			access.Permissions = append(access.Permissions, permission)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return models.Access{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return access, nil
+}
+
+// HasPermission reports whether a role granted to the user in the app, or
+// in every app, includes the permission.
+func (s *Storage) HasPermission(ctx context.Context, userID int64, appID int, permission string) (bool, error) {
+	const op = "storage.sqlite.HasPermission"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`SELECT EXISTS(SELECT 1
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ? AND ur.app_id IN (0, ?) AND p.name = ?)`)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var has bool
+	if err := stmt.QueryRowContext(ctx, userID, appID, permission).Scan(&has); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return has, nil
+}
+
+func (s *Storage) roleID(ctx context.Context, role string) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM roles WHERE name = ?", role).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, storage.ErrRoleNotFound
+		}
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,206 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const signingKeyColumns = "kid, app_id, algorithm, private_key, status, created_at, activates_at, expires_at"
+
+func (s *Storage) SaveSigningKey(ctx context.Context, key models.SigningKey) error {
+	const op = "storage.sqlite.SaveSigningKey"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT INTO signing_keys(" + signingKeyColumns + ") VALUES(?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = stmt.ExecContext(ctx,
+		key.ID, key.AppID, key.Algorithm, key.PrivateKey, key.Status,
+		key.CreatedAt.Unix(), unixOrZero(key.ActivatesAt), unixOrZero(key.ExpiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) SigningKey(ctx context.Context, kid string) (models.SigningKey, error) {
+	const op = "storage.sqlite.SigningKey"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + signingKeyColumns + " FROM signing_keys WHERE kid = ?")
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := scanSigningKey(stmt.QueryRowContext(ctx, kid))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.SigningKey{}, fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+		}
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return key, nil
+}
+
+// ActiveSigningKey returns the key new tokens of the app are signed with.
+func (s *Storage) ActiveSigningKey(ctx context.Context, appID int) (models.SigningKey, error) {
+	const op = "storage.sqlite.ActiveSigningKey"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + signingKeyColumns + ` FROM signing_keys
+		WHERE app_id = ? AND status = ? ORDER BY activates_at DESC LIMIT 1`)
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := scanSigningKey(stmt.QueryRowContext(ctx, appID, models.SigningKeyActive))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.SigningKey{}, fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+		}
+		return models.SigningKey{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return key, nil
+}
+
+// SigningKeys returns not revoked keys of the app, or of all apps if appID
+// is zero.
+func (s *Storage) SigningKeys(ctx context.Context, appID int) ([]models.SigningKey, error) {
+	const op = "storage.sqlite.SigningKeys"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + signingKeyColumns + ` FROM signing_keys
+		WHERE (? = 0 OR app_id = ?) AND status != ? ORDER BY app_id, created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, appID, appID, models.SigningKeyRevoked)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		key, err := scanSigningKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return keys, nil
+}
+
+// ActivateSigningKey makes the key active and moves the previously active
+// key of its app to retiring until retireUntil.
+func (s *Storage) ActivateSigningKey(ctx context.Context, kid string, now time.Time, retireUntil time.Time) error {
+	const op = "storage.sqlite.ActivateSigningKey"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var appID int
+	err = tx.QueryRowContext(ctx, "SELECT app_id FROM signing_keys WHERE kid = ? AND status != ?", kid, models.SigningKeyRevoked).Scan(&appID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Выводим из оборота текущий активный ключ
+	_, err = tx.ExecContext(ctx,
+		"UPDATE signing_keys SET status = ?, expires_at = ? WHERE app_id = ? AND status = ? AND kid != ?",
+		models.SigningKeyRetiring, retireUntil.Unix(), appID, models.SigningKeyActive, kid,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE signing_keys SET status = ?, activates_at = ?, expires_at = 0 WHERE kid = ?",
+		models.SigningKeyActive, now.Unix(), kid,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RevokeSigningKey revokes the key and erases its private part.
+func (s *Storage) RevokeSigningKey(ctx context.Context, kid string) error {
+	const op = "storage.sqlite.RevokeSigningKey"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE signing_keys SET status = ?, private_key = X'' WHERE kid = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := stmt.ExecContext(ctx, models.SigningKeyRevoked, kid); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RevokeExpiredSigningKeys revokes retiring keys whose tokens have expired
+// and returns how many there were.
+func (s *Storage) RevokeExpiredSigningKeys(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.RevokeExpiredSigningKeys"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE signing_keys SET status = ?, private_key = X'' WHERE status = ? AND expires_at <= ?")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, models.SigningKeyRevoked, models.SigningKeyRetiring, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return revoked, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSigningKey(row scanner) (models.SigningKey, error) {
+	var key models.SigningKey
+	var createdAt, activatesAt, expiresAt int64
+	err := row.Scan(&key.ID, &key.AppID, &key.Algorithm, &key.PrivateKey, &key.Status, &createdAt, &activatesAt, &expiresAt)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	key.CreatedAt = time.Unix(createdAt, 0)
+	key.ActivatesAt = timeOrZero(activatesAt)
+	key.ExpiresAt = timeOrZero(expiresAt)
+	return key, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
@@ -7,9 +7,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Storage struct {
@@ -37,6 +39,9 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (
 	// Добавление пользователя
 	res, err := stmt.ExecContext(ctx, email, passHash)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	// Получаем id созданной записи
@@ -50,14 +55,14 @@ func (s *Storage) SaveUser(ctx context.Context, email string, passHash []byte) (
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.sqlite.User"
 	// Подготовка запроса
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email = ?")
+	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, email_verified FROM users WHERE email = ?")
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
 
 	row := stmt.QueryRowContext(ctx, email)
 	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.PassHash)
+	err = row.Scan(&user.ID, &user.Email, &user.PassHash, &user.EmailVerified)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -67,10 +72,76 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	return user, nil
 }
 
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, email_verified FROM users WHERE id = ?")
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, userID)
+	var user models.User
+	err = row.Scan(&user.ID, &user.Email, &user.PassHash, &user.EmailVerified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return user, nil
+}
+
+func (s *Storage) UpdatePassHash(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.sqlite.UpdatePassHash"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE users SET pass_hash = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return nil
+}
+
+// UpdateEmail changes the email of the user, which then has to be verified
+// again.
+func (s *Storage) UpdateEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.sqlite.UpdateEmail"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("UPDATE users SET email = ?, email_verified = FALSE WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, email, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return nil
+}
+
+// IsAdmin reports whether the user has the admin role in every app.
 func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	const op = "storage.sqlite.IsAdmin"
 	// Подготовка запроса
-	stmt, err := s.db.Prepare("SELECT is_admin FROM users WHERE id = ?")
+	stmt, err := s.db.Prepare(`SELECT EXISTS(SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = u.id AND ur.app_id = 0 AND r.name = 'admin')
+		FROM users u WHERE u.id = ?`)
 	if err != nil {
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
@@ -88,18 +159,18 @@ func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 	return isAdmin, nil
 }
 
+const appColumns = `id, name, secret_hash, require_email_verification, allow_self_registration,
+	previous_secret_hash, previous_secret_expires_at, webauthn_rp_id, webauthn_origins, redirect_uris, scopes`
+
 func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
 	const op = "storage.sqlite.App"
 	// Подготовка запроса
-	stmt, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = ?")
+	stmt, err := s.db.Prepare("SELECT " + appColumns + " FROM apps WHERE id = ?")
 	if err != nil {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	row := stmt.QueryRowContext(ctx, appID)
-
-	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.Secret)
+	app, err := scanApp(stmt.QueryRowContext(ctx, appID))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
@@ -109,16 +180,23 @@ func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
 	return app, nil
 }
 
-func (s *Storage) CreateApp(ctx context.Context, app_name string, app_secret string) (int64, error) {
+func (s *Storage) CreateApp(ctx context.Context, app models.App) (int64, error) {
 	const op = "storage.sqlite.CreateApp"
 	// Подготовка запроса
-	stmt, err := s.db.Prepare("INSERT INTO apps(name, secret) VALUES(?, ?)")
+	stmt, err := s.db.Prepare(`INSERT INTO apps(name, secret_hash, require_email_verification,
+		allow_self_registration, webauthn_rp_id, webauthn_origins, redirect_uris, scopes)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	// Добавление приложения
-	res, err := stmt.ExecContext(ctx, app_name, app_secret)
+	res, err := stmt.ExecContext(ctx, app.Name, app.SecretHash, app.RequireEmailVerification,
+		app.AllowSelfRegistration, app.WebAuthn.RPID, strings.Join(app.WebAuthn.Origins, " "),
+		strings.Join(app.RedirectURIs, " "), strings.Join(app.Scopes, " "))
 	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 	// Получаем id созданной записи
@@ -128,3 +206,148 @@ func (s *Storage) CreateApp(ctx context.Context, app_name string, app_secret str
 	}
 	return appID, nil
 }
+
+// Apps returns all apps ordered by id.
+func (s *Storage) Apps(ctx context.Context) ([]models.App, error) {
+	const op = "storage.sqlite.Apps"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT " + appColumns + " FROM apps ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var apps []models.App
+	for rows.Next() {
+		app, err := scanApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return apps, nil
+}
+
+// UpdateApp saves the name and settings of the app. Secrets are changed
+// only by RotateAppSecret.
+func (s *Storage) UpdateApp(ctx context.Context, app models.App) error {
+	const op = "storage.sqlite.UpdateApp"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`UPDATE apps SET name = ?, require_email_verification = ?, allow_self_registration = ?,
+		webauthn_rp_id = ?, webauthn_origins = ?, redirect_uris = ?, scopes = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, app.Name, app.RequireEmailVerification, app.AllowSelfRegistration,
+		app.WebAuthn.RPID, strings.Join(app.WebAuthn.Origins, " "), strings.Join(app.RedirectURIs, " "),
+		strings.Join(app.Scopes, " "), app.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+	return nil
+}
+
+// DeleteApp deletes the app along with its signing keys and refresh tokens.
+func (s *Storage) DeleteApp(ctx context.Context, appID int) error {
+	const op = "storage.sqlite.DeleteApp"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM apps WHERE id = ?", appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	// Внешние ключи в SQLite по умолчанию не проверяются, удаляем зависимые записи сами
+	for _, query := range []string{
+		"DELETE FROM signing_keys WHERE app_id = ?",
+		"DELETE FROM refresh_tokens WHERE app_id = ?",
+		"DELETE FROM user_apps WHERE app_id = ?",
+		"DELETE FROM user_roles WHERE app_id = ?",
+		"DELETE FROM app_invitations WHERE app_id = ?",
+		"DELETE FROM authorization_codes WHERE app_id = ?",
+		"DELETE FROM device_codes WHERE app_id = ?",
+		"DELETE FROM passwordless_challenges WHERE app_id = ?",
+		"DELETE FROM mfa_challenges WHERE app_id = ?",
+		"DELETE FROM webauthn_sessions WHERE app_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, appID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RotateAppSecret replaces the secret of the app with the hashed one. The
+// replaced secret is kept as the previous one until previousExpiresAt.
+func (s *Storage) RotateAppSecret(ctx context.Context, appID int, secretHash []byte, previousExpiresAt time.Time) error {
+	const op = "storage.sqlite.RotateAppSecret"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare(`UPDATE apps SET previous_secret_hash = secret_hash, previous_secret_expires_at = ?,
+		secret_hash = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	res, err := stmt.ExecContext(ctx, previousExpiresAt.Unix(), secretHash, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+	return nil
+}
+
+func scanApp(row scanner) (models.App, error) {
+	var app models.App
+	var previousExpiresAt int64
+	var origins, redirectURIs, scopes string
+	err := row.Scan(&app.ID, &app.Name, &app.SecretHash, &app.RequireEmailVerification,
+		&app.AllowSelfRegistration, &app.PreviousSecretHash, &previousExpiresAt,
+		&app.WebAuthn.RPID, &origins, &redirectURIs, &scopes)
+	if err != nil {
+		return models.App{}, err
+	}
+	app.PreviousSecretExpiresAt = timeOrZero(previousExpiresAt)
+	app.WebAuthn.Origins = strings.Fields(origins)
+	app.RedirectURIs = strings.Fields(redirectURIs)
+	app.Scopes = strings.Fields(scopes)
+	return app, nil
+}
+
+// isUniqueViolation reports whether err is caused by a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
@@ -0,0 +1,103 @@
+package sqlite
+
+import (
+	"auth/internal/models"
+	"auth/internal/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *Storage) SaveVerificationToken(ctx context.Context, token models.VerificationToken) (int64, error) {
+	const op = "storage.sqlite.SaveVerificationToken"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("INSERT INTO email_verification_tokens(token_hash, user_id, email, expires_at) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Добавление токена
+	res, err := stmt.ExecContext(ctx, token.TokenHash, token.UserID, token.Email, token.ExpiresAt.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	// Получаем id созданной записи
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return id, nil
+}
+
+func (s *Storage) VerificationToken(ctx context.Context, tokenHash []byte) (models.VerificationToken, error) {
+	const op = "storage.sqlite.VerificationToken"
+	// Подготовка запроса
+	stmt, err := s.db.Prepare("SELECT id, token_hash, user_id, email, expires_at, used FROM email_verification_tokens WHERE token_hash = ?")
+	if err != nil {
+		return models.VerificationToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, tokenHash)
+
+	var token models.VerificationToken
+	var expiresAt int64
+	err = row.Scan(&token.ID, &token.TokenHash, &token.UserID, &token.Email, &expiresAt, &token.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.VerificationToken{}, fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return models.VerificationToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	return token, nil
+}
+
+// ConfirmEmail uses up the verification token and marks the email of its
+// user verified, provided the user still has the email the token was
+// issued for.
+func (s *Storage) ConfirmEmail(ctx context.Context, tokenID int64) error {
+	const op = "storage.sqlite.ConfirmEmail"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var email string
+	err = tx.QueryRowContext(ctx, "SELECT user_id, email FROM email_verification_tokens WHERE id = ?", tokenID).Scan(&userID, &email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrTokenNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Токен одноразовый
+	res, err := tx.ExecContext(ctx, "UPDATE email_verification_tokens SET used = TRUE WHERE id = ? AND used = FALSE", tokenID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTokenUsed)
+	}
+
+	res, err = tx.ExecContext(ctx, "UPDATE users SET email_verified = TRUE WHERE id = ? AND email = ?", userID, email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	} else if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -3,7 +3,21 @@ package storage
 import "errors"
 
 var (
-	ErrUserExists   = errors.New("user already exists")
-	ErrUserNotFound = errors.New("user not found")
-	ErrAppNotFound  = errors.New("app not found")
+	ErrUserExists           = errors.New("user already exists")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrAppNotFound          = errors.New("app not found")
+	ErrAppExists            = errors.New("app already exists")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenRotated  = errors.New("refresh token already rotated")
+	ErrSigningKeyNotFound   = errors.New("signing key not found")
+	ErrTokenNotFound        = errors.New("token not found")
+	ErrTokenUsed            = errors.New("token already used")
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrMemberNotFound       = errors.New("user is not a member of the app")
+	ErrTOTPNotFound         = errors.New("totp not found")
+	ErrTOTPConfirmed        = errors.New("totp already confirmed")
+	ErrTOTPStepUsed         = errors.New("totp code already used")
+	ErrPasskeyExists        = errors.New("passkey already registered")
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrUserCodeExists       = errors.New("user code already exists")
 )